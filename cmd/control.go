@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"sylr.dev/fix/config"
+
+	"github.com/alexppxela/order-gatling/order"
+)
+
+// registerControlHandlers exposes the runtime control of the workflow:
+//
+//	GET  /control/state                   workflow and sessions state
+//	POST /control/pause                   stop sending new requests
+//	POST /control/resume                  send requests again
+//	POST /control/rate?value=100          new order rate of the sampled workflow
+//	POST /control/update-tempo?value=50ms update tempo of the amend and quote workflows
+//	POST /control/mass-cancel             cancel the orders of the workflow
+func registerControlHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/control/state", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeControlState(w)
+	})
+	mux.HandleFunc("/control/pause", controlAction(func(r *http.Request) error {
+		return controller.Pause()
+	}))
+	mux.HandleFunc("/control/resume", controlAction(func(r *http.Request) error {
+		return controller.Resume()
+	}))
+	mux.HandleFunc("/control/rate", controlAction(func(r *http.Request) error {
+		rate, err := strconv.ParseFloat(r.FormValue("value"), 64)
+		if err != nil || rate < 0 {
+			return errInvalidControlValue
+		}
+		return controller.SetRate(rate)
+	}))
+	mux.HandleFunc("/control/update-tempo", controlAction(func(r *http.Request) error {
+		tempo, err := time.ParseDuration(r.FormValue("value"))
+		if err != nil || tempo < 0 {
+			return errInvalidControlValue
+		}
+		return controller.SetUpdateTempo(tempo)
+	}))
+	mux.HandleFunc("/control/mass-cancel", controlAction(func(r *http.Request) error {
+		return controller.CancelAllOrders()
+	}))
+}
+
+var errInvalidControlValue = errors.New("invalid value")
+
+// controlAction wraps an action of the control API, answering with the state once it is applied.
+func controlAction(action func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err := action(r)
+		switch {
+		case err == nil:
+			config.GetLogger().Info().Str("path", r.URL.Path).Str("value", r.FormValue("value")).Msg("Control request applied")
+			writeControlState(w)
+		case errors.Is(err, errInvalidControlValue):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, order.ErrNotSupported):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, order.ErrNoWorkflow):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}
+}
+
+func writeControlState(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(controller.State()); err != nil {
+		config.GetLogger().Error().Err(err).Msg("Cannot write control state")
+	}
+}
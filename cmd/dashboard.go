@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+	"sylr.dev/fix/config"
+
+	"github.com/alexppxela/order-gatling/order"
+)
+
+const (
+	dashboardRefresh  = time.Second
+	dashboardWindow   = 10
+	dashboardLogLines = 8
+	dashboardRateStep = 1.1
+)
+
+// useDashboard tells whether the terminal dashboard replaces the console logs.
+func useDashboard() bool {
+	return optionDashboard && term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// logTail keeps the last log lines while the dashboard owns the terminal, then
+// writes logs to its output again once the dashboard is stopped.
+type logTail struct {
+	lines   []string
+	partial []byte
+	out     io.Writer
+	lock    sync.Mutex
+}
+
+func newLogTail() *logTail {
+	return &logTail{}
+}
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.out != nil {
+		return t.out.Write(p)
+	}
+	t.partial = append(t.partial, p...)
+	for {
+		idx := bytes.IndexByte(t.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		t.lines = append(t.lines, string(t.partial[:idx]))
+		t.partial = t.partial[idx+1:]
+	}
+	if len(t.lines) > dashboardLogLines {
+		t.lines = t.lines[len(t.lines)-dashboardLogLines:]
+	}
+	return len(p), nil
+}
+
+func (t *logTail) tail() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// release writes the next logs to the output.
+func (t *logTail) release(out io.Writer) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.out = out
+}
+
+// Dashboard shows the live rates and latencies of the run in the terminal and
+// applies the keys pressed by the operator through the controller.
+type Dashboard struct {
+	controller *order.Controller
+	live       *order.LiveStats
+	logs       *logTail
+	stop       context.CancelFunc
+	status     string
+	Closed     chan bool
+}
+
+func NewDashboard(controller *order.Controller, logs *logTail, stop context.CancelFunc) *Dashboard {
+	return &Dashboard{
+		controller: controller,
+		live:       order.NewLiveStats(dashboardWindow),
+		logs:       logs,
+		stop:       stop,
+		Closed:     make(chan bool),
+	}
+}
+
+// Run refreshes the dashboard until the context is cancelled, the terminal is then restored.
+func (d *Dashboard) Run(ctx context.Context) {
+	defer close(d.Closed)
+	defer d.logs.release(os.Stdout)
+
+	fd := int(os.Stdin.Fd())
+	previous, err := term.MakeRaw(fd)
+	if err != nil {
+		d.logs.release(os.Stdout)
+		config.GetLogger().Error().Err(err).Msg("Cannot start dashboard")
+		return
+	}
+	defer func() {
+		_ = term.Restore(fd, previous)
+		fmt.Fprint(os.Stdout, "\033[?25h\n")
+	}()
+	fmt.Fprint(os.Stdout, "\033[?25l")
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if _, err := os.Stdin.Read(buf); err != nil {
+				return
+			}
+			select {
+			case keys <- buf[0]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(dashboardRefresh)
+	defer ticker.Stop()
+	d.render(d.live.Update())
+	for {
+		select {
+		case <-ticker.C:
+			d.render(d.live.Update())
+		case key := <-keys:
+			d.handleKey(key)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *Dashboard) handleKey(key byte) {
+	var err error
+	switch key {
+	case 'p':
+		err = d.controller.Pause()
+		d.status = "paused"
+	case 'r':
+		err = d.controller.Resume()
+		d.status = "resumed"
+	case '+':
+		err = d.changeRate(dashboardRateStep)
+	case '-':
+		err = d.changeRate(1 / dashboardRateStep)
+	case 'c':
+		err = d.controller.CancelAllOrders()
+		d.status = "mass cancel sent"
+	case 'q', 3: // Ctrl-C does not raise a signal in raw mode
+		d.status = "stopping"
+		d.stop()
+	default:
+		return
+	}
+	if err != nil {
+		d.status = err.Error()
+	}
+}
+
+// changeRate multiplies the new order rate of the sampled workflow, or divides
+// the update tempo of the other ones.
+func (d *Dashboard) changeRate(factor float64) error {
+	state := d.controller.State()
+	if state.Workflow == nil {
+		return order.ErrNoWorkflow
+	}
+	if state.Workflow.Workflow == order.WorkflowSampled {
+		rate := math.Round(state.Workflow.Rate * factor)
+		if rate == state.Workflow.Rate && factor > 1 {
+			rate++
+		}
+		d.status = fmt.Sprintf("rate set to %.0f/s", rate)
+		return d.controller.SetRate(rate)
+	}
+	tempo, err := time.ParseDuration(state.Workflow.UpdateTempo)
+	if err != nil {
+		return err
+	}
+	tempo = time.Duration(float64(tempo) / factor).Round(time.Millisecond)
+	if tempo <= 0 && factor < 1 {
+		tempo = time.Millisecond
+	}
+	d.status = fmt.Sprintf("update tempo set to %s", tempo)
+	return d.controller.SetUpdateTempo(tempo)
+}
+
+func (d *Dashboard) render(snapshot *order.LiveSnapshot) {
+	state := d.controller.State()
+	b := strings.Builder{}
+	b.WriteString("\033[H\033[2J")
+	fmt.Fprintf(&b, "order-gatling %s  %s\r\n", Version, snapshot.Time.Format("15:04:05"))
+	if state.Workflow != nil {
+		workflow := state.Workflow
+		running := "running"
+		if workflow.Paused {
+			running = "PAUSED"
+		}
+		pace := fmt.Sprintf("update tempo %s", workflow.UpdateTempo)
+		if workflow.Workflow == order.WorkflowSampled {
+			pace = fmt.Sprintf("rate %.0f/s", workflow.Rate)
+		}
+		fmt.Fprintf(&b, "workflow %s  %s  %s  in-flight %d  open orders %d\r\n", workflow.Workflow, running, pace, workflow.InFlight, workflow.OpenOrders)
+	} else {
+		b.WriteString("no workflow running\r\n")
+	}
+	sessions := make([]string, 0, len(state.Sessions))
+	for _, session := range state.Sessions {
+		status := "down"
+		if session.Connected {
+			status = "up"
+		}
+		sessions = append(sessions, fmt.Sprintf("%s %s", session.Session, status))
+	}
+	fmt.Fprintf(&b, "sessions %s\r\n", strings.Join(sessions, ", "))
+
+	writeLiveRows(&b, "Message type", snapshot.Types)
+	writeLiveRows(&b, "Symbol", snapshot.Symbols)
+
+	fmt.Fprintf(&b, "\r\n[p] pause  [r] resume  [+] faster  [-] slower  [c] mass cancel  [q] quit  %s\r\n\r\n", d.status)
+	for _, line := range d.logs.tail() {
+		b.WriteString(line)
+		b.WriteString("\r\n")
+	}
+	fmt.Fprint(os.Stdout, b.String())
+}
+
+func writeLiveRows(b *strings.Builder, title string, rows []order.LiveRow) {
+	fmt.Fprintf(b, "\r\n%-28s %9s %9s %9s %9s %9s %9s %9s %9s\r\n", title, "sent/s", "acked/s", "rej/s", "rejects", "p50 ms", "p90 ms", "p99 ms", "max ms")
+	for _, row := range rows {
+		fmt.Fprintf(b, "%-28s %9.1f %9.1f %9.1f %9d", row.Name, row.SendRate, row.AckRate, row.RejectRate, row.Rejected)
+		if row.Samples > 0 {
+			fmt.Fprintf(b, " %9.3f %9.3f %9.3f %9.3f\r\n", row.P50, row.P90, row.P99, row.Max)
+		} else {
+			fmt.Fprintf(b, " %9s %9s %9s %9s\r\n", "-", "-", "-", "-")
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 func InitHTTP() error {
 	options := config.GetOptions()
 
-	if !options.Metrics && !options.PProf {
+	if !options.Metrics && !options.PProf && !optionControl {
 		return nil
 	}
 
@@ -28,6 +28,9 @@ func InitHTTP() error {
 		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
 	}
+	if optionControl {
+		registerControlHandlers(mux)
+	}
 
 	go http.ListenAndServe(fmt.Sprintf(":%d", options.HTTPPort), mux)
 
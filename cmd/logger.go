@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"io"
 	"os"
 	"time"
 
@@ -8,11 +9,14 @@ import (
 	"sylr.dev/fix/config"
 )
 
+// logOutput receives the console logs, it is replaced while the dashboard owns the terminal.
+var logOutput io.Writer = os.Stdout
+
 func InitLogger() error {
 	options := config.GetOptions()
 	zerolog.TimeFieldFormat = time.RFC3339Nano
 	consoleWriter := zerolog.ConsoleWriter{
-		Out:        os.Stdout,
+		Out:        logOutput,
 		TimeFormat: "Jan 2 15:04:05.000-0700",
 	}
 	multi := zerolog.MultiLevelWriter(consoleWriter)
@@ -3,15 +3,19 @@ package cmd
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"sylr.dev/fix/config"
+	fixerrors "sylr.dev/fix/pkg/errors"
 	"sylr.dev/fix/pkg/initiator"
 	"sylr.dev/fix/pkg/utils"
 
@@ -21,13 +25,59 @@ import (
 var Version = "dev"
 
 var (
-	optionSymbols       []string
-	optionRefPrices     []float64
-	optionAccounts      []string
-	optionUpdateTempo   time.Duration
-	optionNoMassCancel  bool
-	optionQuoteWorkflow bool
-	optionNewOrderRate  uint
+	optionSymbols         []string
+	optionRefPrices       []float64
+	optionPriceModels     []string
+	optionInstruments     []string
+	optionAccounts        []string
+	optionParties         []string
+	optionOrderTypes      string
+	optionTimeInForce     string
+	optionExpireAfter     time.Duration
+	optionIceberg         string
+	optionMinQty          string
+	optionOrderActions    string
+	optionUpdateTempo     time.Duration
+	optionNoMassCancel    bool
+	optionQuoteWorkflow   bool
+	optionNewOrderRate    uint
+	optionRateProfile     string
+	optionRateFile        string
+	optionArrival         string
+	optionScenario        string
+	optionReportJSON      string
+	optionReportMd        string
+	optionHistogramLog    string
+	optionHistogramTick   time.Duration
+	optionMaxSessions     int
+	optionDispatch        string
+	optionOnReconnect     string
+	optionNoExitCancel    bool
+	optionDrainTimeout    time.Duration
+	optionControl         bool
+	optionDashboard       bool
+	optionDiscover        bool
+	optionMarketData      string
+	optionFollowMarket    string
+	optionReconcile       bool
+	optionDropCopy        string
+	optionDropCopyTimeout time.Duration
+	optionMaxOpenOrders   int
+	optionMaxNotional     float64
+	optionMaxMessageRate  int
+	optionMaxPosition     float64
+	optionMaxRejectRatio  float64
+)
+
+// discoveryTimeout is the maximum duration to wait for the security list of the venue.
+const discoveryTimeout = 10 * time.Second
+
+// reconcileTimeout is the maximum duration to wait for the trades of a session.
+const reconcileTimeout = 30 * time.Second
+
+var (
+	controller    = order.NewController()
+	dashboardLogs *logTail
 )
 
 // OrderGatlingCmd represents the base command when called without any subcommands.
@@ -36,7 +86,8 @@ var OrderGatlingCmd = &cobra.Command{
 	SilenceUsage: true,
 	Version:      Version,
 	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
-		if err := initiator.ValidateOptions(cmd, args); err != nil {
+		// Every session of the context is driven, so the single session restriction doesn't apply
+		if err := initiator.ValidateOptions(cmd, args); err != nil && !errors.Is(err, fixerrors.ConfigContextMultipleSessions) {
 			return err
 		}
 
@@ -48,6 +99,10 @@ var OrderGatlingCmd = &cobra.Command{
 			return err
 		}
 
+		if useDashboard() {
+			dashboardLogs = newLogTail()
+			logOutput = dashboardLogs
+		}
 		return InitLogger()
 	},
 	RunE: execute,
@@ -61,25 +116,58 @@ func init() {
 	OrderGatlingCmd.PersistentFlags().CountVarP(&options.Verbose, "verbose", "v", "Increase verbosity")
 	OrderGatlingCmd.PersistentFlags().BoolVar(&options.LogCaller, "log-caller", false, "Add caller info to log lines")
 	OrderGatlingCmd.PersistentFlags().BoolVar(&options.Interactive, "interactive", true, "Enable interactive mode")
+	OrderGatlingCmd.PersistentFlags().BoolVar(&optionDashboard, "dashboard", false, "Show the live dashboard in the terminal")
 	OrderGatlingCmd.PersistentFlags().BoolVar(&options.Metrics, "metrics", false, "Enable metrics")
 	OrderGatlingCmd.PersistentFlags().BoolVar(&options.PProf, "pprof", false, "Enable pprof")
 	OrderGatlingCmd.PersistentFlags().IntVar(&options.HTTPPort, "port", 5009, "HTTP port")
+	OrderGatlingCmd.PersistentFlags().BoolVar(&optionControl, "control", false, "Enable the runtime control API")
 	OrderGatlingCmd.PersistentFlags().BoolP("help", "h", false, "Help for fix")
 	OrderGatlingCmd.PersistentFlags().Bool("version", false, "Version for fix")
 
-	OrderGatlingCmd.PersistentFlags().StringSliceVar(&optionSymbols, "symbols", nil, "Symbols")
+	OrderGatlingCmd.PersistentFlags().StringSliceVar(&optionSymbols, "symbols", nil, "Symbols (restricts the discovered symbols with --discover-securities)")
 	OrderGatlingCmd.PersistentFlags().Float64SliceVar(&optionRefPrices, "refprices", nil, "Reference price")
+	OrderGatlingCmd.PersistentFlags().StringArrayVar(&optionPriceModels, "price-model", nil, "Price model of all symbols or of one symbol with <symbol>=<model> (e.g. gbm:volatility=0.001)")
+	OrderGatlingCmd.PersistentFlags().BoolVar(&optionDiscover, "discover-securities", false, "Take symbols, trading parameters and reference prices from the security list of the venue")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionMarketData, "market-data-session", "", "Session subscribing to market data, reference prices then follow the market")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionDropCopy, "drop-copy-session", "", "Read-only session receiving the drop copy of the execution reports")
+	OrderGatlingCmd.PersistentFlags().DurationVar(&optionDropCopyTimeout, "drop-copy-timeout", order.DefaultDropCopyTimeout, "Duration after which an execution report not copied on the drop copy session is missing")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionFollowMarket, "follow-market", order.FollowMid, "Market price followed by orders (mid or bbo)")
+	OrderGatlingCmd.PersistentFlags().StringArrayVar(&optionInstruments, "instrument", nil, "Trading parameters of a symbol (e.g. MONA_EUR:tick=0.005,lot=100)")
 	OrderGatlingCmd.PersistentFlags().StringSliceVar(&optionAccounts, "accounts", nil, "Accounts sent in PartyIDs")
+	OrderGatlingCmd.PersistentFlags().StringArrayVar(&optionParties, "party", nil, "Party sent after the account by all accounts or by one account with <account>=<party> (e.g. ATH:source=5,role=122), none for no party")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionOrderTypes, "order-types", order.OrderLimit, "Weighted mix of order types (e.g. limit=80,market=10,stop=5,stop-limit=3,pegged=2)")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionTimeInForce, "time-in-force", order.TimeInForceDay, "Weighted mix of times in force (e.g. day=80,ioc=10,fok=5,gtd=5)")
+	OrderGatlingCmd.PersistentFlags().DurationVar(&optionExpireAfter, "expire-after", order.DefaultExpireAfter, "Duration before good till date orders expire")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionIceberg, "iceberg", "", "Percentage of limit orders sent as icebergs with their visible and hidden lots (e.g. 20:visible=10,hidden=90)")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionMinQty, "min-qty", "", "Percentage of orders sent with a minimum quantity given as a ratio of their quantity (e.g. 10:ratio=0.5)")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionOrderActions, "order-actions", order.ActionReplace, "Weighted mix of actions on acknowledged orders of the amend workflow (e.g. replace=70,cancel=20,new=10:max-left=20), new leaving up to max-left orders per handler on the book until mass cancelled")
 	OrderGatlingCmd.PersistentFlags().DurationVar(&optionUpdateTempo, "update-tempo", 0*time.Millisecond, "Duration before updating order (ms)")
 	OrderGatlingCmd.PersistentFlags().BoolVar(&optionNoMassCancel, "no-mass-cancel", false, "Do not send mass order cancel request")
+	OrderGatlingCmd.PersistentFlags().BoolVar(&optionNoExitCancel, "no-exit-cancel", false, "Do not cancel orders when stopping")
+	OrderGatlingCmd.PersistentFlags().DurationVar(&optionDrainTimeout, "drain-timeout", 5*time.Second, "Maximum duration to wait for cancel answers when stopping")
+	OrderGatlingCmd.PersistentFlags().BoolVar(&optionReconcile, "reconcile-trades", false, "Compare the positions with the trade capture reports of the venue when stopping")
+	OrderGatlingCmd.PersistentFlags().IntVar(&optionMaxOpenOrders, "max-open-orders", 0, "Halt when sending a new order with this many orders resting on the venue (0 for no limit)")
+	OrderGatlingCmd.PersistentFlags().Float64Var(&optionMaxNotional, "max-notional", 0, "Halt when the traded and resting notional of an account in a symbol would exceed this value (0 for no limit)")
+	OrderGatlingCmd.PersistentFlags().IntVar(&optionMaxMessageRate, "max-message-rate", 0, "Halt when sending more requests per second (0 for no limit)")
+	OrderGatlingCmd.PersistentFlags().Float64Var(&optionMaxPosition, "max-position", 0, "Halt when the net position of an account in a symbol would exceed this quantity (0 for no limit)")
+	OrderGatlingCmd.PersistentFlags().Float64Var(&optionMaxRejectRatio, "max-reject-ratio", 0, "Halt when the ratio of rejected requests exceeds this value between 0 and 1 (0 for no limit)")
 	OrderGatlingCmd.PersistentFlags().BoolVar(&optionQuoteWorkflow, "quote", false, "Use quote instead of order workflow")
 	OrderGatlingCmd.PersistentFlags().UintVar(&optionNewOrderRate, "order-rate", 0, "Number of new order sent per second")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionRateProfile, "rate-profile", "", "Shape of the new order rate (e.g. ramp:from=10,to=1000,duration=5m)")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionRateFile, "rate-profile-file", "", "File describing the shape of the new order rate")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionScenario, "scenario", "", "Scenario file describing the phases of the run")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionArrival, "arrival", order.ArrivalFixed, "Inter-arrival distribution of new orders (fixed or poisson)")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionReportJSON, "report-json", "", "Write the end-of-run report to this JSON file")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionReportMd, "report-markdown", "", "Write the end-of-run report to this Markdown file")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionHistogramLog, "histogram-log", "", "Write latency histograms to this HdrHistogram interval log")
+	OrderGatlingCmd.PersistentFlags().IntVar(&optionMaxSessions, "max-sessions", 0, "Maximum number of sessions of the context to use (0 for all)")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionDispatch, "dispatch", order.DispatchAccount, "Distribution of orders across sessions (account or round-robin)")
+	OrderGatlingCmd.PersistentFlags().StringVar(&optionOnReconnect, "on-reconnect", order.ReconnectResume, "Behaviour after a session logs on again (resume or restart)")
+	OrderGatlingCmd.PersistentFlags().DurationVar(&optionHistogramTick, "histogram-log-interval", 10*time.Second, "Interval between two histograms of the HdrHistogram log")
 
 	initiator.AddPersistentFlags(OrderGatlingCmd)
 	_ = initiator.AddPersistentFlagCompletions(OrderGatlingCmd)
 
-	_ = OrderGatlingCmd.MarkFlagRequired("symbols")
-
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	if err := viper.BindPFlags(OrderGatlingCmd.PersistentFlags()); err != nil {
@@ -88,73 +176,474 @@ func init() {
 }
 
 func validate() error {
-	if len(optionSymbols) == 0 {
-		return errors.New("missing symbol list")
+	if optionMaxSessions < 0 {
+		return errors.New("--max-sessions can't be negative")
+	}
+	if optionDispatch != order.DispatchAccount && optionDispatch != order.DispatchRoundRobin {
+		return errors.New("--dispatch must be account or round-robin")
+	}
+	if optionOnReconnect != order.ReconnectResume && optionOnReconnect != order.ReconnectRestart {
+		return errors.New("--on-reconnect must be resume or restart")
+	}
+	if optionFollowMarket != order.FollowMid && optionFollowMarket != order.FollowBBO {
+		return errors.New("--follow-market must be mid or bbo")
+	}
+	if _, err := createOrderMix(); err != nil {
+		return err
+	}
+	if err := riskLimits().Validate(); err != nil {
+		return err
 	}
-	if len(optionSymbols) != len(optionRefPrices) {
-		return errors.New("number of symbols must match number of reference prices")
+	if len(optionMarketData) > 0 {
+		if _, err := config.GetSession(optionMarketData); err != nil {
+			return fmt.Errorf("market data session: %w", err)
+		}
+	}
+	if len(optionDropCopy) > 0 {
+		if _, err := config.GetSession(optionDropCopy); err != nil {
+			return fmt.Errorf("drop copy session: %w", err)
+		}
+		if optionDropCopy == optionMarketData {
+			return errors.New("--drop-copy-session and --market-data-session must be different sessions")
+		}
+		if optionDropCopyTimeout <= 0 {
+			return errors.New("--drop-copy-timeout must be positive")
+		}
+	}
+	if len(optionScenario) > 0 {
+		if optionDiscover {
+			return errors.New("--discover-securities can't be used with --scenario")
+		}
+		// Symbols, reference prices and accounts are checked for each phase of the scenario
+		return nil
 	}
 	if len(optionAccounts) == 0 {
 		return errors.New("missing account list")
 	}
+	if _, err := order.NewParties(optionAccounts, optionParties); err != nil {
+		return err
+	}
+	if optionDiscover {
+		// Symbols and reference prices are only known once the security list is received
+		if len(optionRefPrices) > 0 {
+			return errors.New("--refprices can't be used with --discover-securities")
+		}
+	} else {
+		if len(optionSymbols) == 0 {
+			return errors.New("missing symbol list")
+		}
+		if len(optionSymbols) != len(optionRefPrices) {
+			return errors.New("number of symbols must match number of reference prices")
+		}
+		if _, err := order.NewPriceModels(optionSymbols, optionRefPrices, optionPriceModels); err != nil {
+			return err
+		}
+		instruments, err := order.NewInstruments(optionSymbols, optionInstruments)
+		if err != nil {
+			return err
+		}
+		mix, err := createOrderMix()
+		if err != nil {
+			return err
+		}
+		if err := mix.CheckInstruments(instruments); err != nil {
+			return err
+		}
+	}
+	nbRateOptions := 0
+	for _, set := range []bool{optionNewOrderRate > 0, len(optionRateProfile) > 0, len(optionRateFile) > 0} {
+		if set {
+			nbRateOptions++
+		}
+	}
+	if nbRateOptions > 1 {
+		return errors.New("--order-rate, --rate-profile and --rate-profile-file are mutually exclusive")
+	}
 	return nil
 }
 
+func useSampledWorkflow() bool {
+	return optionNewOrderRate > 0 || len(optionRateProfile) > 0 || len(optionRateFile) > 0
+}
+
+// riskLimits returns the safety rails given on the command line.
+func riskLimits() order.RiskLimits {
+	return order.RiskLimits{
+		MaxOpenOrders:  optionMaxOpenOrders,
+		MaxNotional:    decimal.NewFromFloat(optionMaxNotional),
+		MaxMessageRate: optionMaxMessageRate,
+		MaxPosition:    decimal.NewFromFloat(optionMaxPosition),
+		MaxRejectRatio: optionMaxRejectRatio,
+	}
+}
+
+func createScheduler() (*order.Scheduler, error) {
+	var rateProfile order.RateProfile
+	var err error
+	switch {
+	case len(optionRateProfile) > 0:
+		rateProfile, err = order.ParseRateProfile(optionRateProfile)
+	case len(optionRateFile) > 0:
+		rateProfile, err = order.LoadRateProfile(optionRateFile)
+	default:
+		rateProfile = order.NewConstantRate(float64(optionNewOrderRate))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return order.NewScheduler(rateProfile, optionArrival)
+}
+
+func createOrderMix() (*order.OrderMix, error) {
+	mix, err := order.ParseOrderMix(optionOrderTypes, optionTimeInForce, optionExpireAfter)
+	if err != nil {
+		return nil, err
+	}
+	if err := mix.SetIceberg(optionIceberg); err != nil {
+		return nil, err
+	}
+	if err := mix.SetMinQty(optionMinQty); err != nil {
+		return nil, err
+	}
+	if err := mix.SetActions(optionOrderActions); err != nil {
+		return nil, err
+	}
+	return mix, nil
+}
+
 func execute(cmd *cobra.Command, args []string) error {
+	started := time.Now()
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, os.Kill)
 
-	orderSender, err := createOrderSender(ctx)
+	var scenario *order.Scenario
+	var scheduler *order.Scheduler
+	var err error
+	if len(optionScenario) > 0 {
+		scenario, err = loadScenario(optionScenario)
+	} else if useSampledWorkflow() {
+		scheduler, err = createScheduler()
+	}
 	if err != nil {
 		cancel()
 		return err
 	}
-	err = orderSender.Connect()
+
+	var dashboard *Dashboard
+	if dashboardLogs != nil {
+		dashboard = NewDashboard(controller, dashboardLogs, cancel)
+		go dashboard.Run(ctx)
+		// The terminal must be restored before returning
+		defer func() {
+			cancel()
+			<-dashboard.Closed
+		}()
+	}
+
+	var histogramLog *order.HistogramLog
+	if len(optionHistogramLog) > 0 {
+		histogramLog, err = order.NewHistogramLog(optionHistogramLog, optionHistogramTick)
+		if err != nil {
+			cancel()
+			return err
+		}
+		go histogramLog.Run(ctx)
+	}
+
+	// Senders outlive the workflow context so orders can be cancelled on shutdown
+	senderCtx, stopSenders := context.WithCancel(context.Background())
+	defer stopSenders()
+
+	orderSenders, err := createOrderSenders(senderCtx)
 	if err != nil {
 		cancel()
 		return err
 	}
+	for _, orderSender := range orderSenders {
+		err = orderSender.Connect()
+		if err != nil {
+			cancel()
+			return err
+		}
+	}
+	var dropCopy *order.DropCopyApp
+	if len(optionDropCopy) > 0 {
+		dropCopy, err = createDropCopy(senderCtx)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if err = dropCopy.Connect(); err != nil {
+			cancel()
+			return err
+		}
+		for _, orderSender := range orderSenders {
+			orderSender.MatchDropCopy(dropCopy)
+		}
+	}
+	if optionDiscover {
+		if err = discoverSecurities(orderSenders); err != nil {
+			cancel()
+			return err
+		}
+	}
+	var marketData *order.MarketDataApp
+	if len(optionMarketData) > 0 {
+		symbols := optionSymbols
+		if scenario != nil {
+			symbols = scenario.Symbols()
+		}
+		marketData, err = createMarketData(senderCtx, symbols)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if err = marketData.Connect(); err != nil {
+			cancel()
+			return err
+		}
+		for _, orderSender := range orderSenders {
+			orderSender.MeasureFeedLatency(marketData.Market())
+			orderSender.ExcludeOwnOrders(marketData.Market())
+		}
+		if scenario != nil {
+			scenario.FollowMarket(marketData.Market(), optionFollowMarket)
+		}
+	}
+	pool, err := order.NewSessionPool(orderSenders, optionDispatch, optionOnReconnect)
+	if err != nil {
+		cancel()
+		return err
+	}
+	var riskGuard *order.RiskGuard
+	if limits := riskLimits(); limits.Enabled() {
+		riskGuard, err = order.NewRiskGuard(limits)
+		if err != nil {
+			cancel()
+			return err
+		}
+		pool.EnforceRiskLimits(riskGuard)
+		go func() {
+			// The workflow has already cancelled its orders, the run stops as on a signal
+			select {
+			case <-riskGuard.Halted():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
 
-	if optionNewOrderRate == 0 {
-		orderManager := order.NewManager(
-			ctx,
-			orderSender,
-			optionAccounts,
-			optionSymbols,
-			optionRefPrices,
-			optionQuoteWorkflow,
-			optionUpdateTempo)
-
-		if !optionNoMassCancel {
-			orderManager.CancelAllOrders()
-			<-time.After(2 * time.Second)
+	var workflow order.Workflow
+	if scenario != nil {
+		workflow, err = scenario.Run(ctx, pool, controller)
+		if err != nil {
+			config.GetLogger().Error().Err(err).Msg("Scenario failed")
 		}
-		orderManager.Start()
+		cancel()
 	} else {
-		orderManager := order.NewSampledManager(
-			ctx,
-			orderSender,
-			optionAccounts,
-			optionSymbols,
-			optionRefPrices,
-			optionNewOrderRate)
+		prices, err := order.NewPriceModels(optionSymbols, optionRefPrices, optionPriceModels)
+		if err != nil {
+			cancel()
+			return err
+		}
+		if marketData != nil {
+			prices, err = order.FollowMarket(prices, marketData.Market(), optionFollowMarket)
+			if err != nil {
+				cancel()
+				return err
+			}
+		}
+		instruments, err := order.NewInstruments(optionSymbols, optionInstruments)
+		if err != nil {
+			cancel()
+			return err
+		}
+		parties, err := order.NewParties(optionAccounts, optionParties)
+		if err != nil {
+			cancel()
+			return err
+		}
+		mix, err := createOrderMix()
+		if err != nil {
+			cancel()
+			return err
+		}
+		// Discovered instruments are only known now
+		if err := mix.CheckInstruments(instruments); err != nil {
+			cancel()
+			return err
+		}
+		if useSampledWorkflow() {
+			workflow = order.NewSampledManager(
+				ctx,
+				pool,
+				optionAccounts,
+				parties,
+				optionSymbols,
+				instruments,
+				prices,
+				mix,
+				scheduler)
+		} else {
+			workflow = order.NewManager(
+				ctx,
+				pool,
+				optionAccounts,
+				parties,
+				optionSymbols,
+				instruments,
+				prices,
+				mix,
+				optionQuoteWorkflow,
+				optionUpdateTempo)
+		}
 
 		if !optionNoMassCancel {
-			orderManager.CancelAllOrders()
+			workflow.CancelAllOrders()
 			<-time.After(2 * time.Second)
 		}
-		orderManager.Start()
+		controller.Attach(pool, workflow)
+		workflow.Start()
 	}
 
 	<-ctx.Done()
+	order.MarkStop()
+	if dashboard != nil {
+		<-dashboard.Closed
+	}
 	config.GetLogger().Info().Msg("Received signal. Stopping services")
 	cancel()
-	<-orderSender.Closed
-	config.GetLogger().Trace().Msg("orderSender is closed")
+	controller.Detach()
+	shutdown(workflow, orderSenders)
+	if optionReconcile {
+		config.GetLogger().Info().Msg("Reconciling positions")
+		if err := order.ReconcileTrades(orderSenders, started, reconcileTimeout); err != nil {
+			config.GetLogger().Error().Err(err).Msg("Positions do not reconcile")
+		}
+	}
+	if dropCopy != nil {
+		dropCopy.Finish()
+	}
+	stopSenders()
+	for _, orderSender := range orderSenders {
+		<-orderSender.Closed
+	}
+	if marketData != nil {
+		<-marketData.Closed
+	}
+	if dropCopy != nil {
+		<-dropCopy.Closed
+	}
+	config.GetLogger().Trace().Msg("orderSenders are closed")
+
+	if histogramLog != nil {
+		if err := histogramLog.Close(); err != nil {
+			config.GetLogger().Error().Err(err).Str("path", optionHistogramLog).Msg("Cannot write histogram log")
+		}
+	}
+	writeReport()
+
+	if riskGuard != nil && riskGuard.Breach() != nil {
+		return riskGuard.Breach()
+	}
+	return nil
+}
+
+// discoverSecurities takes the symbols, instruments and reference prices from the
+// security list of the venue, asking the next session when one fails. Symbols given
+// on the command line restrict the list and instruments given on the command line
+// override the ones of the venue.
+func discoverSecurities(orderSenders []*order.SenderApp) error {
+	var securities []order.Security
+	err := errors.New("no session")
+	for _, orderSender := range orderSenders {
+		securities, err = orderSender.DiscoverSecurities(discoveryTimeout)
+		if err == nil {
+			break
+		}
+		config.GetLogger().Warn().Err(err).Str("session", orderSender.Session()).Msg("Cannot discover securities on session")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot discover securities: %w", err)
+	}
+
+	wanted := make(map[string]bool, len(optionSymbols))
+	for _, symbol := range optionSymbols {
+		wanted[symbol] = true
+	}
+	symbols := make([]string, 0, len(securities))
+	refPrices := make([]float64, 0, len(securities))
+	instruments := make([]string, 0, len(securities)+len(optionInstruments))
+	for _, security := range securities {
+		if len(wanted) > 0 && !wanted[security.Symbol] {
+			continue
+		}
+		delete(wanted, security.Symbol)
+		if !security.RefPrice.IsPositive() {
+			config.GetLogger().Warn().Str("symbol", security.Symbol).Msg("Security without reference price ignored")
+			continue
+		}
+		symbols = append(symbols, security.Symbol)
+		refPrices = append(refPrices, security.RefPrice.InexactFloat64())
+		instruments = append(instruments, security.Instrument())
+	}
+	for symbol := range wanted {
+		config.GetLogger().Warn().Str("symbol", symbol).Msg("Symbol not listed by the venue")
+	}
+	if len(symbols) == 0 {
+		return errors.New("no security to trade in the security list")
+	}
+	config.GetLogger().Info().Strs("symbols", symbols).Msg("Securities discovered")
 
+	optionSymbols = symbols
+	optionRefPrices = refPrices
+	optionInstruments = append(instruments, optionInstruments...)
 	return nil
 }
 
-func createOrderSender(ctx context.Context) (*order.SenderApp, error) {
+// shutdown waits for the workflow to stop sending orders, then cancels the orders
+// it left on the venue and waits for the answers before the sessions log out.
+func shutdown(workflow order.Workflow, orderSenders []*order.SenderApp) {
+	for _, orderSender := range orderSenders {
+		orderSender.Drain()
+	}
+	if workflow == nil {
+		return
+	}
+	<-workflow.Done()
+	if optionNoExitCancel {
+		return
+	}
+
+	config.GetLogger().Info().Msg("Cancelling orders")
+	workflow.CancelAllOrders()
+	deadline := time.Now().Add(optionDrainTimeout)
+	for _, orderSender := range orderSenders {
+		if !orderSender.IsConnected() {
+			continue
+		}
+		orderSender.WaitCancels(time.Until(deadline))
+	}
+}
+
+func writeReport() {
+	report := order.BuildReport()
+	report.Print(os.Stdout)
+
+	if len(optionReportJSON) > 0 {
+		if err := report.WriteJSON(optionReportJSON); err != nil {
+			config.GetLogger().Error().Err(err).Str("path", optionReportJSON).Msg("Cannot write JSON report")
+		}
+	}
+	if len(optionReportMd) > 0 {
+		if err := report.WriteMarkdown(optionReportMd); err != nil {
+			config.GetLogger().Error().Err(err).Str("path", optionReportMd).Msg("Cannot write Markdown report")
+		}
+	}
+}
+
+// createOrderSenders creates one sender per session of the context, each one
+// with its own initiator.
+func createOrderSenders(ctx context.Context) ([]*order.SenderApp, error) {
 	configContext, err := config.GetCurrentContext()
 	if err != nil {
 		return nil, err
@@ -164,14 +653,39 @@ func createOrderSender(ctx context.Context) (*order.SenderApp, error) {
 	if err != nil {
 		return nil, err
 	}
+	// The market data session only subscribes to market data and the drop copy session only listens
+	orderSessions := make([]*config.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.Name != optionMarketData && session.Name != optionDropCopy {
+			orderSessions = append(orderSessions, session)
+		}
+	}
+	sessions = orderSessions
+	if len(sessions) == 0 {
+		return nil, errors.New("no order session in the context")
+	}
+	if optionMaxSessions > 0 && len(sessions) > optionMaxSessions {
+		sessions = sessions[:optionMaxSessions]
+	}
 
-	session := sessions[0]
+	apps := make([]*order.SenderApp, 0, len(sessions))
+	for _, session := range sessions {
+		app, err := createOrderSender(ctx, configContext, session)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+func createOrderSender(ctx context.Context, configContext *config.Context, session *config.Session) (*order.SenderApp, error) {
 	transportDict, appDict, err := session.GetFIXDictionaries()
 	if err != nil {
 		return nil, err
 	}
 
-	settings, err := configContext.ToQuickFixInitiatorSettings()
+	settings, err := sessionSettings(configContext, session)
 	if err != nil {
 		return nil, err
 	}
@@ -185,3 +699,61 @@ func createOrderSender(ctx context.Context) (*order.SenderApp, error) {
 
 	return app, nil
 }
+
+// createMarketData creates the application subscribing to the market data of the
+// symbols on the market data session, with the initiator of the current context.
+func createMarketData(ctx context.Context, symbols []string) (*order.MarketDataApp, error) {
+	configContext, err := config.GetCurrentContext()
+	if err != nil {
+		return nil, err
+	}
+	session, err := config.GetSession(optionMarketData)
+	if err != nil {
+		return nil, err
+	}
+	transportDict, appDict, err := session.GetFIXDictionaries()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := sessionSettings(configContext, session)
+	if err != nil {
+		return nil, err
+	}
+
+	qfLogger := utils.QuickFixAppMessageLogger{Logger: config.GetLogger(), TransportDataDictionary: transportDict, AppDataDictionary: appDict}
+	return order.NewMarketDataApp(ctx, qfLogger, settings, symbols), nil
+}
+
+// createDropCopy creates the application listening to the drop copy session, with
+// the initiator of the current context.
+func createDropCopy(ctx context.Context) (*order.DropCopyApp, error) {
+	configContext, err := config.GetCurrentContext()
+	if err != nil {
+		return nil, err
+	}
+	session, err := config.GetSession(optionDropCopy)
+	if err != nil {
+		return nil, err
+	}
+	transportDict, appDict, err := session.GetFIXDictionaries()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := sessionSettings(configContext, session)
+	if err != nil {
+		return nil, err
+	}
+
+	qfLogger := utils.QuickFixAppMessageLogger{Logger: config.GetLogger(), TransportDataDictionary: transportDict, AppDataDictionary: appDict}
+	return order.NewDropCopyApp(ctx, qfLogger, settings, session, optionDropCopyTimeout), nil
+}
+
+// sessionSettings returns the quickfix settings of a single session of the context.
+func sessionSettings(configContext *config.Context, session *config.Session) (*quickfix.Settings, error) {
+	sessionContext := config.Context{
+		Name:      configContext.Name,
+		Initiator: configContext.Initiator,
+		Sessions:  []string{session.Name},
+	}
+	return sessionContext.ToQuickFixInitiatorSettings()
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+
+	"github.com/alexppxela/order-gatling/order"
+)
+
+// loadScenario reads a scenario file. Settings missing from a phase are taken
+// from the command line.
+func loadScenario(path string) (*order.Scenario, error) {
+	viper.SetConfigFile(path)
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("unable to read scenario: %w", err)
+	}
+
+	scenario := order.Scenario{}
+	if err := viper.UnmarshalKey("phases", &scenario.Phases); err != nil {
+		return nil, fmt.Errorf("unable to parse scenario: %w", err)
+	}
+
+	for i := range scenario.Phases {
+		phase := &scenario.Phases[i]
+		if len(phase.Symbols) == 0 {
+			phase.Symbols = optionSymbols
+			if len(phase.RefPrices) == 0 {
+				phase.RefPrices = optionRefPrices
+			}
+		}
+		if len(phase.PriceModels) == 0 {
+			phase.PriceModels = optionPriceModels
+		}
+		if len(phase.Instruments) == 0 {
+			phase.Instruments = optionInstruments
+		}
+		if len(phase.Accounts) == 0 {
+			phase.Accounts = optionAccounts
+		}
+		if len(phase.Parties) == 0 {
+			phase.Parties = optionParties
+		}
+		if len(phase.OrderTypes) == 0 {
+			phase.OrderTypes = optionOrderTypes
+		}
+		if len(phase.TimeInForce) == 0 {
+			phase.TimeInForce = optionTimeInForce
+		}
+		if phase.ExpireAfter == 0 {
+			phase.ExpireAfter = optionExpireAfter
+		}
+		if len(phase.Iceberg) == 0 {
+			phase.Iceberg = optionIceberg
+		}
+		if len(phase.MinQty) == 0 {
+			phase.MinQty = optionMinQty
+		}
+		if len(phase.OrderActions) == 0 {
+			phase.OrderActions = optionOrderActions
+		}
+		if phase.UpdateTempo == 0 {
+			phase.UpdateTempo = optionUpdateTempo
+		}
+		if len(phase.Arrival) == 0 {
+			phase.Arrival = optionArrival
+		}
+	}
+
+	if err := scenario.Validate(); err != nil {
+		return nil, err
+	}
+	return &scenario, nil
+}
@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/spf13/cobra"
+	"sylr.dev/fix/config"
+	"sylr.dev/fix/pkg/acceptor"
+	"sylr.dev/fix/pkg/utils"
+
+	"github.com/alexppxela/order-gatling/order"
+	"github.com/alexppxela/order-gatling/simulator"
+)
+
+var (
+	optionMirrorSessions bool
+	optionSecurities     []string
+	optionSimDropCopy    string
+)
+
+// SimulateCmd starts a local acceptor answering the gatling workflows.
+var SimulateCmd = &cobra.Command{
+	Use:   "simulate",
+	Short: "Launch a simulated exchange",
+	Long:  "Launch a FIX acceptor which simulates an exchange answering orders, quotes and mass cancels.",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := acceptor.ValidateOptions(cmd, args); err != nil {
+			return err
+		}
+
+		for _, description := range optionSecurities {
+			if _, err := order.ParseSecurity(description); err != nil {
+				return err
+			}
+		}
+
+		if err := InitHTTP(); err != nil {
+			return err
+		}
+
+		return InitLogger()
+	},
+	RunE: executeSimulate,
+}
+
+func init() {
+	options := config.GetOptions()
+
+	SimulateCmd.Flags().StringVar(&options.Acceptor, "acceptor", "", "Acceptor to use (can't be used with --context)")
+	SimulateCmd.Flags().BoolVar(&optionMirrorSessions, "mirror-sessions", true, "Swap sender and target IDs of the sessions so initiator sessions can be reused")
+	SimulateCmd.Flags().StringVar(&optionSimDropCopy, "drop-copy-session", "", "Session receiving a copy of the execution reports of the other sessions")
+	SimulateCmd.Flags().StringArrayVar(&optionSecurities, "security", nil, "Security listed by the venue (e.g. MONA_EUR:tick=0.005,lot=100,price=42.5)")
+
+	OrderGatlingCmd.AddCommand(SimulateCmd)
+}
+
+func executeSimulate(cmd *cobra.Command, args []string) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, os.Kill)
+	defer cancel()
+
+	configContext, err := config.GetCurrentContext()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := configContext.GetSessions()
+	if err != nil {
+		return err
+	}
+
+	if optionMirrorSessions {
+		for _, session := range sessions {
+			mirrorSession(session)
+		}
+	}
+
+	settings, err := configContext.ToQuickFixAcceptorSettings()
+	if err != nil {
+		return err
+	}
+
+	transportDict, appDict, err := sessions[0].GetFIXDictionaries()
+	if err != nil {
+		return err
+	}
+
+	qfLogger := utils.QuickFixAppMessageLogger{Logger: config.GetLogger(), TransportDataDictionary: transportDict, AppDataDictionary: appDict}
+
+	venue := simulator.NewVenueApp(qfLogger, settings)
+	securities := make([]order.Security, 0, len(optionSecurities))
+	for _, description := range optionSecurities {
+		security, _ := order.ParseSecurity(description)
+		securities = append(securities, security)
+	}
+	venue.ListSecurities(securities)
+	if len(optionSimDropCopy) > 0 {
+		dropCopy, err := findSession(sessions, optionSimDropCopy)
+		if err != nil {
+			return err
+		}
+		venue.CopyExecutionReports(quickfix.SessionID{
+			BeginString:  dropCopy.BeginString,
+			SenderCompID: dropCopy.SenderCompID,
+			TargetCompID: dropCopy.TargetCompID,
+		})
+	}
+	if err = venue.Start(); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	config.GetLogger().Info().Msg("Received signal. Stopping simulator")
+	venue.Stop()
+
+	return nil
+}
+
+// mirrorSession turns an initiator session definition into its acceptor counterpart.
+func mirrorSession(session *config.Session) {
+	session.SenderCompID, session.TargetCompID = session.TargetCompID, session.SenderCompID
+	session.SenderSubID, session.TargetSubID = session.TargetSubID, session.SenderSubID
+	session.SenderLocationID, session.TargetLocationID = session.TargetLocationID, session.SenderLocationID
+}
+
+// findSession returns the session of the context with the given name, as used by
+// the acceptor.
+func findSession(sessions []*config.Session, name string) (*config.Session, error) {
+	for _, session := range sessions {
+		if session.Name == name {
+			return session, nil
+		}
+	}
+	return nil, fmt.Errorf("drop copy session %s is not a session of the context", name)
+}
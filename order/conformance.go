@@ -0,0 +1,297 @@
+package order
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// conformanceTimeout is the duration after which unanswered requests, closed
+// orders and execution IDs are forgotten. Duplicates and fills arriving later
+// are not detected.
+const conformanceTimeout = time.Minute
+
+// Protocol violations of the venue found in execution reports.
+const (
+	violationDuplicateExecId  = "duplicate_exec_id"
+	violationWrongOrigClOrdId = "wrong_orig_clordid"
+	violationQtyMismatch      = "qty_mismatch"
+	violationLeavesQtyUp      = "leaves_qty_increase"
+	violationUnexpectedExec   = "unexpected_exec_type"
+	violationInvalidStatus    = "invalid_status_transition"
+	violationFillAfterCancel  = "fill_after_cancel"
+)
+
+var (
+	metricViolations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "order_gatling",
+			Name:      "execution_report_violations_total",
+			Help:      "Number of execution reports breaking the order lifecycle",
+		},
+		[]string{"violation", "session"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricViolations)
+}
+
+// violation is a protocol error found in an execution report.
+type violation struct {
+	kind    string
+	clOrdId string
+	detail  string
+}
+
+// trackedRequest is a new order, replace or cancel waiting for its answer.
+type trackedRequest struct {
+	messageType string
+	origClOrdId string
+	orderQty    decimal.Decimal
+	sent        time.Time
+}
+
+// trackedOrder is the lifecycle of an order as told by its execution reports.
+type trackedOrder struct {
+	orderQty  decimal.Decimal
+	leavesQty decimal.Decimal
+	status    enum.OrdStatus
+	cancelled bool
+	closed    time.Time
+}
+
+func (o *trackedOrder) isClosed() bool {
+	return !o.closed.IsZero()
+}
+
+// conformanceChecker follows the orders sent on a session and checks that their
+// execution reports go through the lifecycle PendingNew, New, PartiallyFilled then
+// Filled, Canceled or Replaced with consistent quantities. Orders unknown to the
+// checker, e.g. quotes, are only checked for duplicate execution IDs.
+type conformanceChecker struct {
+	session   string
+	requests  map[string]*trackedRequest
+	orders    map[string]*trackedOrder
+	execIds   map[string]time.Time
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+func newConformanceChecker(session string) *conformanceChecker {
+	return &conformanceChecker{
+		session:   session,
+		requests:  make(map[string]*trackedRequest),
+		orders:    make(map[string]*trackedOrder),
+		execIds:   make(map[string]time.Time),
+		lastPrune: time.Now(),
+	}
+}
+
+// trackMessage records the new orders, replaces and cancels sent on the session.
+func (c *conformanceChecker) trackMessage(message *quickfix.Message) {
+	typ, err := message.MsgType()
+	if err != nil {
+		return
+	}
+	messageType, found := messageTypeNames[enum.MsgType(typ)]
+	if !found {
+		return
+	}
+	switch enum.MsgType(typ) {
+	case enum.MsgType_ORDER_SINGLE, enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST, enum.MsgType_ORDER_CANCEL_REQUEST:
+	default:
+		return
+	}
+	clOrdId, err := message.Body.GetString(tag.ClOrdID)
+	if err != nil {
+		return
+	}
+	request := &trackedRequest{messageType: messageType, sent: time.Now()}
+	request.origClOrdId, _ = message.Body.GetString(tag.OrigClOrdID)
+	var qty quickfix.FIXDecimal
+	if err := message.Body.GetField(tag.OrderQty, &qty); err == nil {
+		request.orderQty = qty.Decimal
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.requests[clOrdId] = request
+	if time.Since(c.lastPrune) > time.Second {
+		c.prune()
+	}
+}
+
+// rejected forgets a replace or cancel answered with an OrderCancelReject.
+func (c *conformanceChecker) rejected(clOrdId string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.requests, clOrdId)
+}
+
+// check follows the order of an execution report and returns the violations it shows.
+func (c *conformanceChecker) check(execReport executionreport.ExecutionReport) []violation {
+	clOrdId, err := execReport.GetClOrdID()
+	if err != nil {
+		return nil
+	}
+	execType, err := execReport.GetExecType()
+	if err != nil {
+		return nil
+	}
+	status, err := execReport.GetOrdStatus()
+	if err != nil {
+		return nil
+	}
+	execId, _ := execReport.GetExecID()
+	origClOrdId, _ := execReport.GetOrigClOrdID()
+	cumQty, cumErr := execReport.GetCumQty()
+	leavesQty, leavesErr := execReport.GetLeavesQty()
+	hasQty := cumErr == nil && leavesErr == nil
+	orderQty, orderQtyErr := execReport.GetOrderQty()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	violations := make([]violation, 0)
+	report := func(kind string, format string, args ...any) {
+		violations = append(violations, violation{kind: kind, clOrdId: clOrdId, detail: fmt.Sprintf(format, args...)})
+	}
+
+	if len(execId) > 0 {
+		if _, found := c.execIds[execId]; found {
+			report(violationDuplicateExecId, "ExecID %s already received", execId)
+			return violations
+		}
+		c.execIds[execId] = time.Now()
+	}
+
+	// Find the order of the report, answering a request or not
+	order, found := c.orders[clOrdId]
+	request, answering := c.requests[clOrdId]
+	if answering {
+		switch request.messageType {
+		case msgTypeNewOrderSingle:
+			switch execType {
+			case enum.ExecType_PENDING_NEW, enum.ExecType_NEW, enum.ExecType_REJECTED, enum.ExecType_TRADE, enum.ExecType_CANCELED, enum.ExecType_EXPIRED:
+			default:
+				report(violationUnexpectedExec, "ExecType %s answering a NewOrderSingle", execType)
+			}
+			if !found {
+				order = &trackedOrder{orderQty: request.orderQty, leavesQty: request.orderQty}
+			}
+		case msgTypeOrderCancelReplaceRequest, msgTypeOrderCancelRequest:
+			expected := enum.ExecType_REPLACED
+			pending := enum.ExecType_PENDING_REPLACE
+			if request.messageType == msgTypeOrderCancelRequest {
+				expected, pending = enum.ExecType_CANCELED, enum.ExecType_PENDING_CANCEL
+			}
+			if execType != expected && execType != pending {
+				report(violationUnexpectedExec, "ExecType %s answering an %s", execType, request.messageType)
+			}
+			if origClOrdId != request.origClOrdId {
+				report(violationWrongOrigClOrdId, "OrigClOrdID %q instead of %q", origClOrdId, request.origClOrdId)
+			}
+			order = c.orders[request.origClOrdId]
+			if order == nil {
+				// The replaced or cancelled order was sent before the checker started
+				order = &trackedOrder{orderQty: orderQty, leavesQty: leavesQty}
+			}
+			if execType == enum.ExecType_REPLACED {
+				order.orderQty = request.orderQty
+				order.leavesQty = leavesQty
+				delete(c.orders, request.origClOrdId)
+			}
+		}
+		if execType != enum.ExecType_PENDING_NEW && execType != enum.ExecType_PENDING_REPLACE && execType != enum.ExecType_PENDING_CANCEL {
+			delete(c.requests, clOrdId)
+		}
+		c.orders[clOrdId] = order
+	} else if !found {
+		return violations
+	} else {
+		switch execType {
+		case enum.ExecType_NEW, enum.ExecType_PENDING_NEW, enum.ExecType_REPLACED, enum.ExecType_PENDING_REPLACE, enum.ExecType_PENDING_CANCEL:
+			report(violationUnexpectedExec, "unsolicited ExecType %s", execType)
+		}
+	}
+
+	if order.isClosed() {
+		if execType == enum.ExecType_TRADE && order.cancelled {
+			report(violationFillAfterCancel, "fill after the order was %s", order.status)
+		} else {
+			report(violationInvalidStatus, "OrdStatus %s after %s", status, order.status)
+		}
+	} else if order.status == enum.OrdStatus_PARTIALLY_FILLED && (status == enum.OrdStatus_NEW || status == enum.OrdStatus_PENDING_NEW) {
+		report(violationInvalidStatus, "OrdStatus %s after %s", status, order.status)
+	}
+
+	if orderQtyErr == nil && !orderQty.IsZero() && execType != enum.ExecType_PENDING_REPLACE {
+		order.orderQty = orderQty
+	}
+	if hasQty {
+		switch status {
+		case enum.OrdStatus_FILLED, enum.OrdStatus_CANCELED, enum.OrdStatus_EXPIRED, enum.OrdStatus_REJECTED, enum.OrdStatus_DONE_FOR_DAY:
+			if !leavesQty.IsZero() {
+				report(violationQtyMismatch, "LeavesQty %s on a %s order", leavesQty, status)
+			}
+			if cumQty.GreaterThan(order.orderQty) {
+				report(violationQtyMismatch, "CumQty %s above OrderQty %s", cumQty, order.orderQty)
+			}
+		default:
+			if !cumQty.Add(leavesQty).Equal(order.orderQty) {
+				report(violationQtyMismatch, "CumQty %s + LeavesQty %s != OrderQty %s", cumQty, leavesQty, order.orderQty)
+			}
+			if execType != enum.ExecType_REPLACED && leavesQty.GreaterThan(order.leavesQty) {
+				report(violationLeavesQtyUp, "LeavesQty went up from %s to %s", order.leavesQty, leavesQty)
+			}
+		}
+		order.leavesQty = leavesQty
+	}
+
+	if !order.isClosed() {
+		order.status = status
+		switch status {
+		case enum.OrdStatus_CANCELED, enum.OrdStatus_EXPIRED, enum.OrdStatus_REJECTED:
+			order.cancelled = true
+			order.closed = time.Now()
+		case enum.OrdStatus_FILLED:
+			order.closed = time.Now()
+		}
+	}
+	return violations
+}
+
+// prune forgets the requests never answered, the orders closed and the execution
+// IDs received for longer than the timeout. Must be called with the lock held.
+func (c *conformanceChecker) prune() {
+	now := time.Now()
+	c.lastPrune = now
+	for clOrdId, request := range c.requests {
+		if now.Sub(request.sent) > conformanceTimeout {
+			delete(c.requests, clOrdId)
+		}
+	}
+	for clOrdId, order := range c.orders {
+		if order.isClosed() && now.Sub(order.closed) > conformanceTimeout {
+			delete(c.orders, clOrdId)
+		}
+	}
+	for execId, received := range c.execIds {
+		if now.Sub(received) > conformanceTimeout {
+			delete(c.execIds, execId)
+		}
+	}
+}
+
+// record exports a violation as a metric and in the end-of-run report.
+func (c *conformanceChecker) record(v violation) {
+	metricViolations.WithLabelValues(v.kind, c.session).Inc()
+	stats.recordViolation(v.kind)
+}
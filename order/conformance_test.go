@@ -0,0 +1,234 @@
+package order
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/fix50sp2/newordersingle"
+	"github.com/quickfixgo/fix50sp2/ordercancelreplacerequest"
+	"github.com/quickfixgo/fix50sp2/ordercancelrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+// conformanceStep is a request sent on the session or an execution report received.
+type conformanceStep func(c *conformanceChecker) []violation
+
+func sendNew(clOrdId string, qty int64) conformanceStep {
+	return func(c *conformanceChecker) []violation {
+		order := newordersingle.New(field.NewClOrdID(clOrdId), field.NewSide(enum.Side_BUY), field.NewTransactTime(time.Now()), field.NewOrdType(enum.OrdType_LIMIT))
+		order.Set(field.NewOrderQty(decimal.NewFromInt(qty), 0))
+		c.trackMessage(order.ToMessage())
+		return nil
+	}
+}
+
+func sendReplace(clOrdId string, origClOrdId string, qty int64) conformanceStep {
+	return func(c *conformanceChecker) []violation {
+		replace := ordercancelreplacerequest.New(field.NewClOrdID(clOrdId), field.NewSide(enum.Side_BUY), field.NewTransactTime(time.Now()), field.NewOrdType(enum.OrdType_LIMIT))
+		replace.Set(field.NewOrigClOrdID(origClOrdId))
+		replace.Set(field.NewOrderQty(decimal.NewFromInt(qty), 0))
+		c.trackMessage(replace.ToMessage())
+		return nil
+	}
+}
+
+func sendCancel(clOrdId string, origClOrdId string) conformanceStep {
+	return func(c *conformanceChecker) []violation {
+		cancel := ordercancelrequest.New(field.NewClOrdID(clOrdId), field.NewSide(enum.Side_BUY), field.NewTransactTime(time.Now()))
+		cancel.Set(field.NewOrigClOrdID(origClOrdId))
+		c.trackMessage(cancel.ToMessage())
+		return nil
+	}
+}
+
+func receive(execId string, clOrdId string, origClOrdId string, execType enum.ExecType, status enum.OrdStatus, cumQty int64, leavesQty int64) conformanceStep {
+	return func(c *conformanceChecker) []violation {
+		er := executionreport.New(
+			field.NewOrderID("O1"),
+			field.NewExecID(execId),
+			field.NewExecType(execType),
+			field.NewOrdStatus(status),
+			field.NewSide(enum.Side_BUY),
+			field.NewLeavesQty(decimal.NewFromInt(leavesQty), 0),
+			field.NewCumQty(decimal.NewFromInt(cumQty), 0),
+		)
+		er.SetClOrdID(clOrdId)
+		if len(origClOrdId) > 0 {
+			er.SetOrigClOrdID(origClOrdId)
+		}
+		return c.check(er)
+	}
+}
+
+func TestConformanceChecker(t *testing.T) {
+	tests := []struct {
+		name       string
+		steps      []conformanceStep
+		violations []string
+	}{
+		{
+			name: "filled order",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_PENDING_NEW, enum.OrdStatus_PENDING_NEW, 0, 10),
+				receive("E2", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+				receive("E3", "1", "", enum.ExecType_TRADE, enum.OrdStatus_PARTIALLY_FILLED, 4, 6),
+				receive("E4", "1", "", enum.ExecType_TRADE, enum.OrdStatus_FILLED, 10, 0),
+			},
+		},
+		{
+			name: "replaced then filled",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+				sendReplace("2", "1", 20),
+				receive("E2", "2", "1", enum.ExecType_PENDING_REPLACE, enum.OrdStatus_PENDING_REPLACE, 0, 10),
+				receive("E3", "2", "1", enum.ExecType_REPLACED, enum.OrdStatus_NEW, 0, 20),
+				receive("E4", "2", "", enum.ExecType_TRADE, enum.OrdStatus_FILLED, 20, 0),
+			},
+		},
+		{
+			name: "rejected order",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_REJECTED, enum.OrdStatus_REJECTED, 0, 0),
+			},
+		},
+		{
+			name: "unknown order",
+			steps: []conformanceStep{
+				receive("E1", "Q1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 5),
+			},
+		},
+		{
+			name: "duplicate execution ID",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+				receive("E1", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+			},
+			violations: []string{violationDuplicateExecId},
+		},
+		{
+			name: "replace answered for another order",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+				sendReplace("2", "1", 10),
+				receive("E2", "2", "X", enum.ExecType_REPLACED, enum.OrdStatus_NEW, 0, 10),
+			},
+			violations: []string{violationWrongOrigClOrdId},
+		},
+		{
+			name: "new order answered with a replace",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_REPLACED, enum.OrdStatus_NEW, 0, 10),
+			},
+			violations: []string{violationUnexpectedExec},
+		},
+		{
+			name: "unsolicited new",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+				receive("E2", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+			},
+			violations: []string{violationUnexpectedExec},
+		},
+		{
+			name: "quantities not adding up",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 8),
+			},
+			violations: []string{violationQtyMismatch},
+		},
+		{
+			name: "leaves quantity on a filled order",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_TRADE, enum.OrdStatus_FILLED, 10, 2),
+			},
+			violations: []string{violationQtyMismatch},
+		},
+		{
+			name: "leaves quantity going up",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_TRADE, enum.OrdStatus_PARTIALLY_FILLED, 4, 6),
+				receive("E2", "1", "", enum.ExecType_TRADE, enum.OrdStatus_PARTIALLY_FILLED, 2, 8),
+			},
+			violations: []string{violationLeavesQtyUp},
+		},
+		{
+			name: "new after a partial fill",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_TRADE, enum.OrdStatus_PARTIALLY_FILLED, 4, 6),
+				sendReplace("2", "1", 10),
+				receive("E2", "2", "1", enum.ExecType_REPLACED, enum.OrdStatus_NEW, 4, 6),
+			},
+			violations: []string{violationInvalidStatus},
+		},
+		{
+			name: "trade on a filled order",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_TRADE, enum.OrdStatus_FILLED, 10, 0),
+				receive("E2", "1", "", enum.ExecType_TRADE, enum.OrdStatus_FILLED, 10, 0),
+			},
+			violations: []string{violationInvalidStatus},
+		},
+		{
+			name: "fill after cancel",
+			steps: []conformanceStep{
+				sendNew("1", 10),
+				receive("E1", "1", "", enum.ExecType_NEW, enum.OrdStatus_NEW, 0, 10),
+				sendCancel("2", "1"),
+				receive("E2", "2", "1", enum.ExecType_CANCELED, enum.OrdStatus_CANCELED, 0, 0),
+				receive("E3", "2", "", enum.ExecType_TRADE, enum.OrdStatus_FILLED, 10, 0),
+			},
+			violations: []string{violationFillAfterCancel},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checker := newConformanceChecker("test")
+			violations := make([]string, 0)
+			for _, step := range test.steps {
+				for _, v := range step(checker) {
+					violations = append(violations, v.kind)
+				}
+			}
+			if len(test.violations) == 0 {
+				test.violations = []string{}
+			}
+			if !reflect.DeepEqual(violations, test.violations) {
+				t.Fatalf("violations %v, expected %v", violations, test.violations)
+			}
+		})
+	}
+}
+
+func TestConformanceCheckerTrackedMessages(t *testing.T) {
+	checker := newConformanceChecker("test")
+	sendNew("1", 10)(checker)
+	sendReplace("2", "1", 10)(checker)
+	sendCancel("3", "2")(checker)
+	heartbeat := quickfix.NewMessage()
+	heartbeat.Header.Set(field.NewMsgType(enum.MsgType_HEARTBEAT))
+	checker.trackMessage(heartbeat)
+	if len(checker.requests) != 3 {
+		t.Fatalf("%d requests tracked, expected 3", len(checker.requests))
+	}
+	checker.rejected("3")
+	if _, found := checker.requests["3"]; found || len(checker.requests) != 2 {
+		t.Fatal("rejected cancel still tracked")
+	}
+}
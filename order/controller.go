@@ -0,0 +1,129 @@
+package order
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNoWorkflow   = errors.New("no workflow is running")
+	ErrNotSupported = errors.New("not supported by the running workflow")
+)
+
+type SessionState struct {
+	Session   string `json:"session"`
+	Connected bool   `json:"connected"`
+}
+
+type ControlState struct {
+	Workflow *WorkflowState `json:"workflow,omitempty"`
+	Sessions []SessionState `json:"sessions"`
+}
+
+// Controller adjusts the load while the run is going on. It follows the running
+// workflow, so a pause requested during a scenario phase also applies to the next ones.
+type Controller struct {
+	pool     *SessionPool
+	workflow Workflow
+	paused   bool
+	lock     sync.Mutex
+}
+
+func NewController() *Controller {
+	return &Controller{}
+}
+
+// Attach makes the workflow the one to control. It must be called before the workflow is started.
+func (c *Controller) Attach(pool *SessionPool, workflow Workflow) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.pool = pool
+	c.workflow = workflow
+	if workflow != nil && c.paused {
+		workflow.Pause()
+	}
+}
+
+// Detach stops controlling the workflow, e.g. when the run is stopping.
+func (c *Controller) Detach() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.workflow = nil
+}
+
+func (c *Controller) Pause() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.workflow == nil {
+		return ErrNoWorkflow
+	}
+	c.paused = true
+	c.workflow.Pause()
+	return nil
+}
+
+func (c *Controller) Resume() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.workflow == nil {
+		return ErrNoWorkflow
+	}
+	c.paused = false
+	c.workflow.Resume()
+	return nil
+}
+
+func (c *Controller) SetRate(rate float64) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.workflow == nil {
+		return ErrNoWorkflow
+	}
+	controller, ok := c.workflow.(RateController)
+	if !ok {
+		return ErrNotSupported
+	}
+	controller.SetRate(rate)
+	return nil
+}
+
+func (c *Controller) SetUpdateTempo(tempo time.Duration) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.workflow == nil {
+		return ErrNoWorkflow
+	}
+	controller, ok := c.workflow.(TempoController)
+	if !ok {
+		return ErrNotSupported
+	}
+	controller.SetUpdateTempo(tempo)
+	return nil
+}
+
+func (c *Controller) CancelAllOrders() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.workflow == nil {
+		return ErrNoWorkflow
+	}
+	c.workflow.CancelAllOrders()
+	return nil
+}
+
+func (c *Controller) State() ControlState {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	state := ControlState{Sessions: []SessionState{}}
+	if c.workflow != nil {
+		workflowState := c.workflow.State()
+		state.Workflow = &workflowState
+	}
+	if c.pool != nil {
+		for _, app := range c.pool.Apps() {
+			state.Sessions = append(state.Sessions, SessionState{Session: app.Session(), Connected: app.IsConnected()})
+		}
+	}
+	return state
+}
@@ -0,0 +1,216 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/rs/zerolog"
+	"sylr.dev/fix/config"
+	fixerrors "sylr.dev/fix/pkg/errors"
+	"sylr.dev/fix/pkg/initiator"
+	fixutils "sylr.dev/fix/pkg/utils"
+)
+
+// DropCopyApp listens to the execution reports copied by the venue on a read-only
+// session and matches them with the ones received on the order sessions.
+type DropCopyApp struct {
+	// Logger.
+	fixutils.QuickFixAppMessageLogger
+
+	// Quickfix settings of the drop copy session.
+	settings *quickfix.Settings
+
+	// Quickfix initiator.
+	initiator *quickfix.Initiator
+
+	// Quickfix settings of the drop copy session, from the configuration.
+	sessionConfig *config.Session
+
+	// Message router.
+	*quickfix.MessageRouter
+
+	// logonStatusChan is a chan raising connection status event.
+	logonStatusChan chan bool
+
+	// matcher matches the copies with the execution reports of the order sessions.
+	matcher *dropCopyMatcher
+
+	// Closed is a chan to notify when application is closed properly.
+	Closed chan bool
+
+	isStopping atomic.Bool
+}
+
+var _ quickfix.Application = (*DropCopyApp)(nil)
+
+// NewDropCopyApp creates an Application listening to the drop copy session. Execution
+// reports not copied within the timeout are missing.
+func NewDropCopyApp(
+	ctx context.Context,
+	quickFixAppMessageLogger fixutils.QuickFixAppMessageLogger,
+	settings *quickfix.Settings,
+	sessionConfig *config.Session,
+	timeout time.Duration) *DropCopyApp {
+	app := DropCopyApp{
+		QuickFixAppMessageLogger: quickFixAppMessageLogger,
+		MessageRouter:            quickfix.NewMessageRouter(),
+		settings:                 settings,
+		sessionConfig:            sessionConfig,
+		logonStatusChan:          make(chan bool),
+		matcher:                  newDropCopyMatcher(sessionConfig.Name, timeout),
+		Closed:                   make(chan bool),
+	}
+
+	app.MessageRouter.AddRoute(executionreport.Route(app.onExecutionReport))
+
+	go app.handleContextDone(ctx)
+
+	return &app
+}
+
+func (a *DropCopyApp) handleContextDone(ctx context.Context) {
+	<-ctx.Done()
+	a.isStopping.Store(true)
+	if a.initiator != nil {
+		a.initiator.Stop()
+	}
+	close(a.logonStatusChan)
+	a.Closed <- true
+}
+
+// OnCreate is called when a session is created. Note that sessions are created
+// upon initiator/acceptor start and not when a connection is established.
+func (a *DropCopyApp) OnCreate(sessionID quickfix.SessionID) {
+	a.Logger.Debug().Str("session", sessionID.String()).Msg("Created")
+}
+
+// OnLogon is called when a FIX logon occurs.
+func (a *DropCopyApp) OnLogon(sessionID quickfix.SessionID) {
+	a.Logger.Info().Str("session", sessionID.String()).Msg("Successful Logon")
+	a.logonStatusChan <- true
+}
+
+// OnLogout is called when a FIX logout occurs.
+func (a *DropCopyApp) OnLogout(sessionID quickfix.SessionID) {
+	a.Logger.Info().Str("session", sessionID.String()).Msg("Successful Logout")
+	if !a.isStopping.Load() {
+		a.logonStatusChan <- false
+	}
+}
+
+// ToAdmin is called when sending a FIX message regarding the FIX protocol, e.g.:
+// LOGIN, LOGOUT, HEARTBEAT, TEST ... etc.
+func (a *DropCopyApp) ToAdmin(message *quickfix.Message, sessionID quickfix.SessionID) {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, true)
+
+	typ, err := message.MsgType()
+	if err != nil || typ != string(enum.MsgType_LOGON) {
+		return
+	}
+	if session, ok := a.settings.SessionSettings()[sessionID]; ok {
+		for _, credential := range []struct {
+			setting string
+			tag     quickfix.Tag
+		}{
+			{"Username", tag.Username},
+			{"Password", tag.Password},
+		} {
+			if value, err := session.Setting(credential.setting); err == nil && len(value) > 0 {
+				message.Header.SetField(credential.tag, quickfix.FIXString(value))
+			}
+		}
+	}
+}
+
+// FromAdmin is called when receiving a FIX message regarding the FIX protocol, e.g.:
+// LOGIN, LOGOUT, HEARTBEAT, TEST ... etc.
+func (a *DropCopyApp) FromAdmin(message *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, false)
+	return nil
+}
+
+// ToApp is called when sending a FIX message that is not considered "Admin". The
+// drop copy session is read-only, application messages are not sent.
+func (a *DropCopyApp) ToApp(message *quickfix.Message, sessionID quickfix.SessionID) error {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, true)
+	return nil
+}
+
+// FromApp is called when receiving a FIX message that is not considered "Admin".
+// Messages other than execution reports are ignored.
+func (a *DropCopyApp) FromApp(message *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, false)
+	typ, err := message.MsgType()
+	if err != nil || enum.MsgType(typ) != enum.MsgType_EXECUTION_REPORT {
+		return nil
+	}
+	return a.MessageRouter.Route(message, sessionID)
+}
+
+func (a *DropCopyApp) onExecutionReport(msg executionreport.ExecutionReport, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.matcher.copied(msg)
+	return nil
+}
+
+// Session returns the name of the configured session, used to label metrics.
+func (a *DropCopyApp) Session() string {
+	return a.sessionConfig.Name
+}
+
+// Finish waits until every execution report of the order sessions is copied or the
+// timeout expires, then records the matching and reconciles the positions of the
+// drop copy in the report of the run.
+func (a *DropCopyApp) Finish() {
+	deadline := time.Now().Add(a.matcher.timeout)
+	for a.matcher.pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	report := a.matcher.finish()
+	stats.recordDropCopy(report)
+	stats.recordReconciliation(reconcile("drop_copy", positions, a.matcher.positions))
+	if report.Missing > 0 || report.Extra > 0 {
+		a.Logger.Warn().Uint64("missing", report.Missing).Uint64("extra", report.Extra).Uint64("late", report.Late).Msg("Drop copy does not match the execution reports")
+	}
+}
+
+func (a *DropCopyApp) Connect() error {
+	opt := config.GetOptions()
+	var quickfixLogger *zerolog.Logger
+	if opt.QuickFixLogging {
+		quickfixLogger = a.Logger
+	}
+	var err error
+	a.initiator, err = initiator.Initiate(a, a.settings, quickfixLogger)
+	if err != nil {
+		return fmt.Errorf("unable to create drop copy initiator: %s", err)
+	}
+
+	err = a.initiator.Start()
+	if err != nil {
+		return fmt.Errorf("unable to start drop copy initiator: %s", err)
+	}
+
+	// Wait for session connection
+	select {
+	case <-time.After(30 * time.Second):
+		return errors.New("cannot connect to FIX drop copy acceptor")
+	case status, ok := <-a.logonStatusChan:
+		if !ok || !status {
+			return fixerrors.FixLogout
+		}
+	}
+	go func() {
+		// The initiator logs on again by itself, copies sent meanwhile are missing
+		for range a.logonStatusChan {
+		}
+	}()
+
+	return nil
+}
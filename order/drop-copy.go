@@ -0,0 +1,225 @@
+package order
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+)
+
+// DefaultDropCopyTimeout is the duration after which an execution report not copied
+// by the drop copy session is deemed missing, and a copy without report extra.
+const DefaultDropCopyTimeout = 5 * time.Second
+
+// Outcomes of the matching of execution reports with their drop copies.
+const (
+	dropCopyMatched = "matched"
+	dropCopyLate    = "late"
+	dropCopyMissing = "missing"
+	dropCopyExtra   = "extra"
+)
+
+var (
+	metricDropCopyLag = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Subsystem: "order_gatling",
+			Name:      "drop_copy_lag_seconds_summary",
+			Help:      "Duration between an execution report and its drop copy",
+			Objectives: map[float64]float64{
+				0.5:  0.05,
+				0.9:  0.05,
+				0.95: 0.01,
+				0.99: 0.005,
+			},
+		},
+		[]string{"session"},
+	)
+	metricDropCopies = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "order_gatling",
+			Name:      "drop_copy_reports_total",
+			Help:      "Number of execution reports matched, copied late, missing or extra on the drop copy session",
+		},
+		[]string{"result", "session"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricDropCopyLag, metricDropCopies)
+}
+
+// dropCopyKey identifies an execution report and its copy: the ExecID, or else the
+// ClOrdID with the execution type and cumulative quantity.
+func dropCopyKey(execReport executionreport.ExecutionReport) (string, bool) {
+	if execId, err := execReport.GetExecID(); err == nil && len(execId) > 0 {
+		return "exec:" + execId, true
+	}
+	clOrdId, err := execReport.GetClOrdID()
+	if err != nil {
+		return "", false
+	}
+	execType, _ := execReport.GetExecType()
+	cumQty, _ := execReport.GetCumQty()
+	return fmt.Sprintf("order:%s/%s/%s", clOrdId, execType, cumQty), true
+}
+
+// dropCopyMatcher matches the execution reports received on the order sessions with
+// the ones copied on the drop copy session. Either may come first. A report not
+// copied within the timeout is missing until its copy comes late, and a copy not
+// matching any report within the timeout is extra.
+type dropCopyMatcher struct {
+	session   string
+	timeout   time.Duration
+	reports   map[string]time.Time
+	copies    map[string]time.Time
+	missed    map[string]time.Time
+	matched   uint64
+	late      uint64
+	missing   uint64
+	extra     uint64
+	lags      *hdrhistogram.Histogram
+	positions *positionBook
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+func newDropCopyMatcher(session string, timeout time.Duration) *dropCopyMatcher {
+	return &dropCopyMatcher{
+		session:   session,
+		timeout:   timeout,
+		reports:   make(map[string]time.Time),
+		copies:    make(map[string]time.Time),
+		missed:    make(map[string]time.Time),
+		lags:      newLatencyHistogram(),
+		positions: newPositionBook(),
+		lastPrune: time.Now(),
+	}
+}
+
+// reported records an execution report received on an order session.
+func (m *dropCopyMatcher) reported(execReport executionreport.ExecutionReport) {
+	key, found := dropCopyKey(execReport)
+	if !found {
+		return
+	}
+	received := execReport.Message.ReceiveTime
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if copied, found := m.copies[key]; found {
+		// The copy came first
+		delete(m.copies, key)
+		m.observe(dropCopyMatched, copied.Sub(received))
+	} else {
+		m.reports[key] = received
+	}
+	if time.Since(m.lastPrune) > time.Second {
+		m.prune(time.Now())
+	}
+}
+
+// copied records an execution report received on the drop copy session. A copy
+// received twice is extra.
+func (m *dropCopyMatcher) copied(execReport executionreport.ExecutionReport) {
+	key, found := dropCopyKey(execReport)
+	if !found {
+		return
+	}
+	received := execReport.Message.ReceiveTime
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, found := m.copies[key]; found {
+		m.extra++
+		metricDropCopies.WithLabelValues(dropCopyExtra, m.session).Inc()
+	} else if reported, found := m.reports[key]; found {
+		delete(m.reports, key)
+		lag := received.Sub(reported)
+		result := dropCopyMatched
+		if lag > m.timeout {
+			result = dropCopyLate
+		}
+		m.observe(result, lag)
+	} else if reported, found := m.missed[key]; found {
+		delete(m.missed, key)
+		m.missing--
+		m.observe(dropCopyLate, received.Sub(reported))
+	} else {
+		m.copies[key] = received
+	}
+	m.positions.recordFill(execReport, m.session)
+	if time.Since(m.lastPrune) > time.Second {
+		m.prune(time.Now())
+	}
+}
+
+// observe counts a report copied on time or late. Must be called with the lock held.
+func (m *dropCopyMatcher) observe(result string, lag time.Duration) {
+	if result == dropCopyLate {
+		m.late++
+	} else {
+		m.matched++
+	}
+	if lag < 0 {
+		lag = 0
+	}
+	_ = m.lags.RecordValue(clampLatency(lag))
+	metricDropCopyLag.WithLabelValues(m.session).Observe(lag.Seconds())
+	metricDropCopies.WithLabelValues(result, m.session).Inc()
+}
+
+// prune counts the reports which were not copied in time as missing and the copies
+// which match no report as extra. Must be called with the lock held.
+func (m *dropCopyMatcher) prune(now time.Time) {
+	m.lastPrune = now
+	for key, received := range m.reports {
+		if now.Sub(received) > m.timeout {
+			delete(m.reports, key)
+			m.missed[key] = received
+			m.missing++
+			metricDropCopies.WithLabelValues(dropCopyMissing, m.session).Inc()
+		}
+	}
+	for key, received := range m.copies {
+		if now.Sub(received) > m.timeout {
+			delete(m.copies, key)
+			m.extra++
+			metricDropCopies.WithLabelValues(dropCopyExtra, m.session).Inc()
+		}
+	}
+}
+
+// pending returns the number of reports waiting for their copy.
+func (m *dropCopyMatcher) pending() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.reports)
+}
+
+// DropCopyReport gives the execution reports of the order sessions matched with their
+// copies on the drop copy session, and the lag of the copies.
+type DropCopyReport struct {
+	Matched uint64         `json:"matched"`
+	Late    uint64         `json:"late"`
+	Missing uint64         `json:"missing"`
+	Extra   uint64         `json:"extra"`
+	Lag     *LatencyReport `json:"lag,omitempty"`
+}
+
+// finish counts every report and copy still unmatched as missing or extra and
+// summarizes the matching.
+func (m *dropCopyMatcher) finish() *DropCopyReport {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.prune(time.Now().Add(m.timeout + time.Nanosecond))
+	return &DropCopyReport{
+		Matched: m.matched,
+		Late:    m.late,
+		Missing: m.missing,
+		Extra:   m.extra,
+		Lag:     newLatencyReport(m.lags),
+	}
+}
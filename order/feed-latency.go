@@ -0,0 +1,182 @@
+package order
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// feedTimeout is the duration after which an order not seen in the market data
+// feed is deemed unpublished, e.g. because it was filled on arrival.
+const feedTimeout = 10 * time.Second
+
+var (
+	metricFeedLatency = prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Subsystem: "order_gatling",
+			Name:      "order_to_feed_duration_seconds_summary",
+			Help:      "Duration between sending a request and the market data update of its price level",
+			Objectives: map[float64]float64{
+				0.5:  0.05,
+				0.9:  0.05,
+				0.95: 0.01,
+				0.99: 0.005,
+			},
+		},
+		[]string{"type", "session"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricFeedLatency)
+}
+
+// feedKey is a price level of the market data feed.
+type feedKey struct {
+	symbol string
+	side   enum.Side
+	price  string
+}
+
+type feedRequest struct {
+	messageType string
+	session     string
+	sent        time.Time
+}
+
+// feedTracker correlates the requests sent by the gatling with the updates of the
+// market data feed. A request is published by the first update of its price level
+// received after it was sent, which publishes every request pending on the level
+// as feeds may aggregate several changes in one update.
+type feedTracker struct {
+	symbols   map[string]bool
+	pending   map[feedKey][]feedRequest
+	lastPrune time.Time
+	lock      sync.Mutex
+}
+
+func newFeedTracker(symbols []string) *feedTracker {
+	subscribed := make(map[string]bool, len(symbols))
+	for _, symbol := range symbols {
+		subscribed[symbol] = true
+	}
+	return &feedTracker{
+		symbols:   subscribed,
+		pending:   make(map[feedKey][]feedRequest),
+		lastPrune: time.Now(),
+	}
+}
+
+// track records a request changing a price level.
+func (t *feedTracker) track(key feedKey, request feedRequest) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.pending[key] = append(t.pending[key], request)
+	if time.Since(t.lastPrune) > time.Second {
+		t.prune()
+	}
+}
+
+// published observes the latency of the requests waiting for an update of the price level.
+func (t *feedTracker) published(key feedKey, received time.Time) {
+	t.lock.Lock()
+	requests, found := t.pending[key]
+	delete(t.pending, key)
+	t.lock.Unlock()
+	if !found {
+		return
+	}
+
+	for _, request := range requests {
+		latency := received.Sub(request.sent)
+		if latency < 0 {
+			// The update was read from the socket before the request was sent
+			continue
+		}
+		metricFeedLatency.WithLabelValues(request.messageType, request.session).Observe(latency.Seconds())
+		stats.recordFeedLatency(request.messageType, latency)
+	}
+}
+
+// prune forgets the requests which were not published in time. Must be called with the lock held.
+func (t *feedTracker) prune() {
+	now := time.Now()
+	t.lastPrune = now
+	for key, requests := range t.pending {
+		expired := 0
+		for expired < len(requests) && now.Sub(requests[expired].sent) > feedTimeout {
+			stats.recordFeedMissed(requests[expired].messageType)
+			expired++
+		}
+		switch {
+		case expired == len(requests):
+			delete(t.pending, key)
+		case expired > 0:
+			t.pending[key] = requests[expired:]
+		}
+	}
+}
+
+// trackMessage records the price levels of the subscribed symbols an outgoing new order,
+// replace or quote changes. Only limit orders resting on the book are shown at their price.
+func (t *feedTracker) trackMessage(message *quickfix.Message, session string) {
+	typ, err := message.MsgType()
+	if err != nil {
+		return
+	}
+	symbol, err := message.Body.GetString(tag.Symbol)
+	if err != nil || !t.symbols[symbol] {
+		return
+	}
+	now := time.Now()
+
+	switch enum.MsgType(typ) {
+	case enum.MsgType_ORDER_SINGLE, enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST:
+		messageType := msgTypeNewOrderSingle
+		if enum.MsgType(typ) == enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST {
+			messageType = msgTypeOrderCancelReplaceRequest
+		}
+		if !restsAtPrice(message) {
+			return
+		}
+		side, err := message.Body.GetString(tag.Side)
+		if err != nil {
+			return
+		}
+		if price, found := priceOf(message, tag.Price); found {
+			t.track(feedKey{symbol: symbol, side: enum.Side(side), price: price.String()}, feedRequest{messageType: messageType, session: session, sent: now})
+		}
+	case enum.MsgType_QUOTE:
+		if price, found := priceOf(message, tag.BidPx); found {
+			t.track(feedKey{symbol: symbol, side: enum.Side_BUY, price: price.String()}, feedRequest{messageType: msgTypeQuote, session: session, sent: now})
+		}
+		if price, found := priceOf(message, tag.OfferPx); found {
+			t.track(feedKey{symbol: symbol, side: enum.Side_SELL, price: price.String()}, feedRequest{messageType: msgTypeQuote, session: session, sent: now})
+		}
+	}
+}
+
+func restsAtPrice(message *quickfix.Message) bool {
+	ordType, err := message.Body.GetString(tag.OrdType)
+	if err != nil || enum.OrdType(ordType) != enum.OrdType_LIMIT {
+		return false
+	}
+	tif, err := message.Body.GetString(tag.TimeInForce)
+	if err != nil {
+		return true
+	}
+	return enum.TimeInForce(tif) != enum.TimeInForce_IMMEDIATE_OR_CANCEL && enum.TimeInForce(tif) != enum.TimeInForce_FILL_OR_KILL
+}
+
+func priceOf(message *quickfix.Message, priceTag quickfix.Tag) (decimal.Decimal, bool) {
+	var price quickfix.FIXDecimal
+	if err := message.Body.GetField(priceTag, &price); err != nil {
+		return decimal.Zero, false
+	}
+	return price.Decimal, true
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/sha1"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,9 +12,38 @@ import (
 	"github.com/quickfixgo/fix50sp2/newordersingle"
 	"github.com/quickfixgo/fix50sp2/ordermasscancelrequest"
 	"github.com/quickfixgo/quickfix"
-	"github.com/shopspring/decimal"
 )
 
+const (
+	msgTypeNewOrderSingle            = "NewOrderSingle"
+	msgTypeOrderCancelReplaceRequest = "OrderCancelReplaceRequest"
+	msgTypeOrderCancelRequest        = "OrderCancelRequest"
+	msgTypeOrderMassCancelRequest    = "OrderMassCancelRequest"
+	msgTypeQuote                     = "Quote"
+	msgTypeQuoteCancel               = "QuoteCancel"
+)
+
+var messageTypeNames = map[enum.MsgType]string{
+	enum.MsgType_ORDER_SINGLE:                 msgTypeNewOrderSingle,
+	enum.MsgType_ORDER_CANCEL_REPLACE_REQUEST: msgTypeOrderCancelReplaceRequest,
+	enum.MsgType_ORDER_CANCEL_REQUEST:         msgTypeOrderCancelRequest,
+	enum.MsgType_ORDER_MASS_CANCEL_REQUEST:    msgTypeOrderMassCancelRequest,
+	enum.MsgType_QUOTE:                        msgTypeQuote,
+	enum.MsgType_QUOTE_CANCEL:                 msgTypeQuoteCancel,
+}
+
+// messageTypeOf returns the name used in metrics and reports for a message.
+func messageTypeOf(msg quickfix.Messagable) string {
+	msgType, err := msg.ToMessage().MsgType()
+	if err != nil {
+		return "Unknown"
+	}
+	if name, found := messageTypeNames[enum.MsgType(msgType)]; found {
+		return name
+	}
+	return msgType
+}
+
 type Handler interface {
 	GetSymbol() string
 	GetSide() enum.Side
@@ -23,6 +51,8 @@ type Handler interface {
 	GetAccount() string
 	GetTimestamp() time.Time
 	GetMessageType() string
+	// Rests tells whether the last order stays on the venue once acknowledged.
+	Rests() bool
 
 	UpdateClientOrderId(newId string)
 
@@ -32,41 +62,23 @@ type Handler interface {
 	BuildOrderRequest() (quickfix.Messagable, string)
 }
 
-func generateOrderQuantity() decimal.Decimal {
-	qty := 90 + rand.Intn(20)
-	return decimal.NewFromInt(int64(qty))
-}
-
-func generatePrice(refPrice float64) decimal.Decimal {
-	p := refPrice - 0.05 + rand.Float64()*0.10
-	return decimal.NewFromFloat(p)
-}
-
-func buildNewOrderSingle(side enum.Side, price float64, symbol string, account string) (quickfix.Messagable, string) {
+// buildNewOrderSingle builds an order of the given kind placed at an offset from the reference price of the model.
+func buildNewOrderSingle(side enum.Side, instrument *Instrument, kind orderKind, prices PriceModel, offset float64, account string, parties []Party) (quickfix.Messagable, string) {
 	clOrdId := uuid.New().String()
 	order := newordersingle.New(
 		field.NewClOrdID(clOrdId),
 		field.NewSide(side),
 		field.NewTransactTime(time.Now()),
-		field.NewOrdType(enum.OrdType_LIMIT),
+		field.NewOrdType(kind.ordType),
 	)
-	order.Set(field.NewOrderQty(generateOrderQuantity(), 0))
-	order.Set(field.NewPrice(generatePrice(price), 2))
-	order.Set(field.NewSymbol(symbol))
-	order.Set(field.NewTimeInForce(enum.TimeInForce_DAY))
-	partyIdsGroup := newordersingle.NewNoPartyIDsRepeatingGroup()
-	partyIds := partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID(account))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_CUSTOMER_ACCOUNT))
-	partyIds = partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID("ATH"))
-	partyIds.Set(field.NewPartyIDSource(enum.PartyIDSource_CHINESE_INVESTOR_ID))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_INVESTMENT_DECISION_MAKER))
-	order.SetNoPartyIDs(partyIdsGroup)
+	setOrderQty(order.Body, kind, instrument)
+	order.Set(field.NewSymbol(instrument.Symbol))
+	setOrderKind(order.Body, kind, instrument, prices, offset)
+	order.SetGroup(newPartiesGroup(account, parties))
 	return order, clOrdId
 }
 
-func BuildMassCancelRequest(side enum.Side, symbol string, account string) quickfix.Messagable {
+func BuildMassCancelRequest(side enum.Side, symbol string, account string, parties []Party) quickfix.Messagable {
 	h := sha1.New()
 	h.Write([]byte(fmt.Sprintf("%s%v%s", symbol, side, account)))
 	massCancel := ordermasscancelrequest.New(
@@ -76,14 +88,6 @@ func BuildMassCancelRequest(side enum.Side, symbol string, account string) quick
 	)
 	massCancel.Set(field.NewSide(side))
 	massCancel.Set(field.NewSymbol(symbol))
-	partyIdsGroup := ordermasscancelrequest.NewNoPartyIDsRepeatingGroup()
-	partyIds := partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID(account))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_CUSTOMER_ACCOUNT))
-	partyIds = partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID("ATH"))
-	partyIds.Set(field.NewPartyIDSource(enum.PartyIDSource_CHINESE_INVESTOR_ID))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_INVESTMENT_DECISION_MAKER))
-	massCancel.SetNoPartyIDs(partyIdsGroup)
+	massCancel.SetGroup(newPartiesGroup(account, parties))
 	return massCancel
 }
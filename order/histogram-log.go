@@ -0,0 +1,145 @@
+package order
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const histogramLogFormatVersion = "1.3"
+
+// HistogramLog periodically writes the latencies of each message type to an
+// HdrHistogram interval log (.hlog). Histograms are tagged with their message type,
+// so logs of several runs or instances can be merged with the HdrHistogram tools.
+type HistogramLog struct {
+	path     string
+	interval time.Duration
+	file     *os.File
+	writer   *bufio.Writer
+	start    time.Time
+	last     time.Time
+	lock     sync.Mutex
+}
+
+func NewHistogramLog(path string, interval time.Duration) (*HistogramLog, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid histogram log interval %s", interval)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	l := &HistogramLog{
+		path:     path,
+		interval: interval,
+		file:     file,
+		writer:   bufio.NewWriter(file),
+		start:    start,
+		last:     start,
+	}
+	startSec := float64(start.UnixMilli()) / 1000
+	fmt.Fprintf(l.writer, "#[Histogram log format version %s]\n", histogramLogFormatVersion)
+	fmt.Fprintf(l.writer, "#[StartTime: %.3f (seconds since epoch), %s]\n", startSec, start.Format(time.RFC3339))
+	fmt.Fprintf(l.writer, "#[BaseTime: %.3f (seconds since epoch)]\n", startSec)
+	fmt.Fprintf(l.writer, "#[Values are in microseconds]\n")
+	fmt.Fprintf(l.writer, "\"StartTimestamp\",\"Interval_Length\",\"Interval_Max\",\"Interval_Compressed_Histogram\"\n")
+	if err := l.writer.Flush(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Run writes an interval every period until the context is cancelled.
+func (l *HistogramLog) Run(ctx context.Context) {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := l.writeInterval(); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (l *HistogramLog) writeInterval() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.file == nil {
+		return os.ErrClosed
+	}
+
+	now := time.Now()
+	intervals := stats.rotateIntervals()
+	messageTypes := make([]string, 0, len(intervals))
+	for messageType := range intervals {
+		messageTypes = append(messageTypes, messageType)
+	}
+	sort.Strings(messageTypes)
+
+	startTimestamp := l.last.Sub(l.start).Seconds()
+	length := now.Sub(l.last).Seconds()
+	for _, messageType := range messageTypes {
+		histogram := intervals[messageType]
+		payload, err := histogram.Encode(hdrhistogram.V2CompressedEncodingCookieBase)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(l.writer, "Tag=%s,%.3f,%.3f,%.3f,%s\n", messageType, startTimestamp, length, toMilliseconds(histogram.Max()), payload)
+	}
+	l.last = now
+	return l.writer.Flush()
+}
+
+// Close writes the last interval, the percentile distribution of the whole run of
+// each message type next to the log (<log>.<type>.hgrm) and closes the log.
+func (l *HistogramLog) Close() error {
+	if err := l.writeInterval(); err != nil {
+		return err
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	err := l.file.Close()
+	l.file = nil
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(l.path, filepath.Ext(l.path))
+	for messageType, histogram := range stats.snapshotLatencies() {
+		if err := writePercentileDistribution(fmt.Sprintf("%s.%s.hgrm", base, messageType), histogram); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePercentileDistribution writes a histogram in the .hgrm format with values in milliseconds.
+func writePercentileDistribution(path string, histogram *hdrhistogram.Histogram) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	if _, err := histogram.PercentilesPrint(writer, 5, 1000); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
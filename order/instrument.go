@@ -0,0 +1,189 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+
+	"github.com/shopspring/decimal"
+)
+
+// Instrument holds the trading parameters of a symbol which every order must conform to.
+type Instrument struct {
+	Symbol         string
+	TickSize       decimal.Decimal
+	LotSize        decimal.Decimal
+	MinQty         decimal.Decimal
+	MaxQty         decimal.Decimal
+	PricePrecision int32
+	QtyPrecision   int32
+}
+
+// defaultMinLots is the smallest quantity of generated orders, in lots, when the
+// instrument doesn't give one. Orders are then from 90 to 109 lots.
+const (
+	defaultMinLots   = 90
+	defaultLotsRange = 19
+)
+
+// NewInstrument creates an instrument with the given tick and lot sizes. Quantities
+// default to 90 to 109 lots and precisions to the ones of the tick and lot sizes.
+func NewInstrument(symbol string, tickSize decimal.Decimal, lotSize decimal.Decimal) *Instrument {
+	return &Instrument{
+		Symbol:         symbol,
+		TickSize:       tickSize,
+		LotSize:        lotSize,
+		MinQty:         lotSize.Mul(decimal.NewFromInt(defaultMinLots)),
+		MaxQty:         lotSize.Mul(decimal.NewFromInt(defaultMinLots + defaultLotsRange)),
+		PricePrecision: precisionOf(tickSize),
+		QtyPrecision:   precisionOf(lotSize),
+	}
+}
+
+// DefaultInstrument trades by ticks of 0.01 and lots of 1.
+func DefaultInstrument(symbol string) *Instrument {
+	return NewInstrument(symbol, decimal.New(1, -2), decimal.New(1, 0))
+}
+
+// precisionOf returns the number of decimals needed to write a value.
+func precisionOf(value decimal.Decimal) int32 {
+	if exp := value.Exponent(); exp < 0 {
+		return -exp
+	}
+	return 0
+}
+
+func (i *Instrument) Validate() error {
+	if !i.TickSize.IsPositive() {
+		return fmt.Errorf("instrument %s: tick size must be positive", i.Symbol)
+	}
+	if !i.LotSize.IsPositive() {
+		return fmt.Errorf("instrument %s: lot size must be positive", i.Symbol)
+	}
+	if i.PricePrecision < precisionOf(i.TickSize) {
+		return fmt.Errorf("instrument %s: price precision is too small for tick size %s", i.Symbol, i.TickSize)
+	}
+	if i.QtyPrecision < precisionOf(i.LotSize) {
+		return fmt.Errorf("instrument %s: quantity precision is too small for lot size %s", i.Symbol, i.LotSize)
+	}
+	if i.minLots() > i.maxLots() {
+		return fmt.Errorf("instrument %s: no multiple of lot size %s between %s and %s", i.Symbol, i.LotSize, i.MinQty, i.MaxQty)
+	}
+	return nil
+}
+
+func (i *Instrument) minLots() int64 {
+	lots := i.MinQty.Div(i.LotSize).Ceil().IntPart()
+	if lots < 1 {
+		return 1
+	}
+	return lots
+}
+
+func (i *Instrument) maxLots() int64 {
+	return i.MaxQty.Div(i.LotSize).Floor().IntPart()
+}
+
+// Ticks returns the price difference of a number of ticks.
+func (i *Instrument) Ticks(n int) float64 {
+	return i.TickSize.Mul(decimal.NewFromInt(int64(n))).InexactFloat64()
+}
+
+// RoundPrice rounds a price to the nearest tick, one tick being the lowest price.
+func (i *Instrument) RoundPrice(price float64) decimal.Decimal {
+	ticks := decimal.NewFromFloat(price).Div(i.TickSize).Round(0)
+	if ticks.LessThan(decimal.NewFromInt(1)) {
+		ticks = decimal.NewFromInt(1)
+	}
+	return ticks.Mul(i.TickSize)
+}
+
+// RoundOffset rounds a price difference to the nearest tick.
+func (i *Instrument) RoundOffset(offset float64) decimal.Decimal {
+	return decimal.NewFromFloat(offset).Div(i.TickSize).Round(0).Mul(i.TickSize)
+}
+
+// GenerateQuantity draws a quantity made of whole lots between the minimum and the maximum quantity.
+func (i *Instrument) GenerateQuantity() decimal.Decimal {
+	lots := i.minLots() + rand.Int63n(i.maxLots()-i.minLots()+1)
+	return i.LotSize.Mul(decimal.NewFromInt(lots))
+}
+
+// ParseInstrument builds an instrument from a description such as
+// "MONA_EUR:tick=0.005,lot=100,min-qty=100,max-qty=10000,price-precision=3,qty-precision=0".
+func ParseInstrument(description string) (*Instrument, error) {
+	symbol, params, err := parseSpec(description)
+	if err != nil {
+		return nil, fmt.Errorf("invalid instrument: %w", err)
+	}
+	if len(symbol) == 0 {
+		return nil, errors.New("instrument needs a symbol")
+	}
+	if err := params.only("tick", "lot", "min-qty", "max-qty", "price-precision", "qty-precision"); err != nil {
+		return nil, fmt.Errorf("invalid instrument %s: %w", symbol, err)
+	}
+
+	instrument := DefaultInstrument(symbol)
+	tickSize := params.decimalValue("tick", instrument.TickSize)
+	lotSize := params.decimalValue("lot", instrument.LotSize)
+	instrument = NewInstrument(symbol, tickSize, lotSize)
+	instrument.MinQty = params.decimalValue("min-qty", instrument.MinQty)
+	instrument.MaxQty = params.decimalValue("max-qty", instrument.MaxQty)
+	if _, found := params.values["max-qty"]; !found && instrument.MaxQty.LessThan(instrument.MinQty) {
+		instrument.MaxQty = instrument.MinQty.Add(lotSize.Mul(decimal.NewFromInt(defaultLotsRange)))
+	}
+	instrument.PricePrecision = params.precision("price-precision", instrument.PricePrecision)
+	instrument.QtyPrecision = params.precision("qty-precision", instrument.QtyPrecision)
+	if params.err != nil {
+		return nil, fmt.Errorf("invalid instrument %s: %w", symbol, params.err)
+	}
+	if err := instrument.Validate(); err != nil {
+		return nil, err
+	}
+	return instrument, nil
+}
+
+// NewInstruments builds the instrument of each symbol, symbols without description
+// trading with the default instrument.
+func NewInstruments(symbols []string, descriptions []string) (map[string]*Instrument, error) {
+	instruments := make(map[string]*Instrument, len(symbols))
+	for _, symbol := range symbols {
+		instruments[symbol] = DefaultInstrument(symbol)
+	}
+	for _, description := range descriptions {
+		instrument, err := ParseInstrument(description)
+		if err != nil {
+			return nil, err
+		}
+		if _, found := instruments[instrument.Symbol]; !found {
+			return nil, fmt.Errorf("instrument given for unknown symbol %s", instrument.Symbol)
+		}
+		instruments[instrument.Symbol] = instrument
+	}
+	return instruments, nil
+}
+
+func (p *specParams) decimalValue(key string, defaultValue decimal.Decimal) decimal.Decimal {
+	value, found := p.values[key]
+	if !found {
+		return defaultValue
+	}
+	d, err := decimal.NewFromString(value)
+	if err != nil {
+		p.setError(fmt.Errorf("%s: %w", key, err))
+	}
+	return d
+}
+
+func (p *specParams) precision(key string, defaultValue int32) int32 {
+	value, found := p.values[key]
+	if !found {
+		return defaultValue
+	}
+	precision, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || precision < 0 {
+		p.setError(fmt.Errorf("%s: invalid precision %q", key, value))
+	}
+	return int32(precision)
+}
@@ -0,0 +1,93 @@
+package order
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestParseInstrument(t *testing.T) {
+	tests := []struct {
+		description string
+		err         string
+		// expected instrument as tick, lot, min-qty, max-qty, price and quantity precisions
+		want []string
+	}{
+		{description: "XXX", want: []string{"0.01", "1", "90", "109", "2", "0"}},
+		{description: "XXX:tick=0.005,lot=100", want: []string{"0.005", "100", "9000", "10900", "3", "0"}},
+		{description: "XXX:lot=0.1,min-qty=1,max-qty=2", want: []string{"0.01", "0.1", "1", "2", "2", "1"}},
+		{description: "XXX:min-qty=500", want: []string{"0.01", "1", "500", "519", "2", "0"}},
+		{description: "XXX:tick=0.5,price-precision=3,qty-precision=2", want: []string{"0.5", "1", "90", "109", "3", "2"}},
+		{description: ":tick=0.1", err: "needs a symbol"},
+		{description: "XXX:tick=abc", err: "invalid instrument XXX"},
+		{description: "XXX:tik=0.1", err: `unknown parameter "tik"`},
+		{description: "XXX:tick=0", err: "tick size must be positive"},
+		{description: "XXX:lot=-1", err: "lot size must be positive"},
+		{description: "XXX:tick=0.001,price-precision=2", err: "price precision is too small"},
+		{description: "XXX:qty-precision=-1", err: "invalid precision"},
+		{description: "XXX:lot=100,min-qty=150,max-qty=180", err: "no multiple of lot size"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			instrument, err := ParseInstrument(test.description)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			got := []string{
+				instrument.TickSize.String(), instrument.LotSize.String(), instrument.MinQty.String(), instrument.MaxQty.String(),
+				decimal.NewFromInt32(instrument.PricePrecision).String(), decimal.NewFromInt32(instrument.QtyPrecision).String(),
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("instrument %v, expected %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundPrice(t *testing.T) {
+	tests := []struct {
+		tick  string
+		price float64
+		want  string
+	}{
+		{"0.01", 101.504, "101.5"},
+		{"0.01", 101.505, "101.51"},
+		{"0.005", 101.5037, "101.505"},
+		{"0.5", 10.74, "10.5"},
+		{"0.5", 10.76, "11"},
+		{"0.01", 0.001, "0.01"},
+		{"0.01", -5, "0.01"},
+		{"5", 12, "10"},
+	}
+	for _, test := range tests {
+		instrument := NewInstrument("XXX", decimal.RequireFromString(test.tick), decimal.NewFromInt(1))
+		if got := instrument.RoundPrice(test.price); !got.Equal(decimal.RequireFromString(test.want)) {
+			t.Errorf("price %v with tick %s rounded to %s, expected %s", test.price, test.tick, got, test.want)
+		}
+	}
+}
+
+func TestGenerateQuantity(t *testing.T) {
+	tests := []string{"XXX", "XXX:lot=100,min-qty=150,max-qty=1000", "XXX:lot=0.1,min-qty=1,max-qty=1", "XXX:lot=5,min-qty=0,max-qty=12"}
+	for _, description := range tests {
+		instrument, err := ParseInstrument(description)
+		if err != nil {
+			t.Fatalf("%s: %v", description, err)
+		}
+		for i := 0; i < 1000; i++ {
+			qty := instrument.GenerateQuantity()
+			if qty.LessThan(instrument.MinQty) || qty.GreaterThan(instrument.MaxQty) || !qty.Mod(instrument.LotSize).IsZero() || !qty.IsPositive() {
+				t.Fatalf("%s: quantity %s is not a positive multiple of %s between %s and %s", description, qty, instrument.LotSize, instrument.MinQty, instrument.MaxQty)
+			}
+		}
+	}
+}
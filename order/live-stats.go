@@ -0,0 +1,108 @@
+package order
+
+import (
+	"sort"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// LiveRow gives the rates since the previous update and the latency percentiles over
+// the rolling window of a message type or a symbol.
+type LiveRow struct {
+	Name       string
+	SendRate   float64
+	AckRate    float64
+	RejectRate float64
+	Rejected   uint64
+	Samples    int64
+	P50        float64
+	P90        float64
+	P99        float64
+	Max        float64
+}
+
+type LiveSnapshot struct {
+	Time    time.Time
+	Types   []LiveRow
+	Symbols []LiveRow
+}
+
+// LiveStats follows the run statistics for the dashboard. Each update gives the
+// rates since the previous one, and the latency percentiles over the last updates.
+type LiveStats struct {
+	window  int
+	last    time.Time
+	types   *liveSeries
+	symbols *liveSeries
+}
+
+type liveSeries struct {
+	previous map[string]liveCounters
+	history  []map[string]*hdrhistogram.Histogram
+}
+
+// NewLiveStats computes latency percentiles over the given number of updates.
+func NewLiveStats(window int) *LiveStats {
+	if window < 1 {
+		window = 1
+	}
+	return &LiveStats{
+		window:  window,
+		last:    time.Now(),
+		types:   &liveSeries{},
+		symbols: &liveSeries{},
+	}
+}
+
+func (l *LiveStats) Update() *LiveSnapshot {
+	types, symbols := stats.rotateRecent()
+	now := time.Now()
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	return &LiveSnapshot{
+		Time:    now,
+		Types:   l.types.update(types, elapsed, l.window),
+		Symbols: l.symbols.update(symbols, elapsed, l.window),
+	}
+}
+
+func (s *liveSeries) update(current map[string]liveCounters, elapsed float64, window int) []LiveRow {
+	recent := make(map[string]*hdrhistogram.Histogram, len(current))
+	for name, counters := range current {
+		recent[name] = counters.recent
+	}
+	s.history = append(s.history, recent)
+	if len(s.history) > window {
+		s.history = s.history[len(s.history)-window:]
+	}
+
+	rows := make([]LiveRow, 0, len(current))
+	for name, counters := range current {
+		previous := s.previous[name]
+		row := LiveRow{Name: name, Rejected: counters.rejected}
+		if elapsed > 0 {
+			row.SendRate = float64(counters.sent-previous.sent) / elapsed
+			row.AckRate = float64(counters.acked-previous.acked) / elapsed
+			row.RejectRate = float64(counters.rejected-previous.rejected) / elapsed
+		}
+
+		latencies := newLatencyHistogram()
+		for _, histograms := range s.history {
+			if histogram, found := histograms[name]; found {
+				latencies.Merge(histogram)
+			}
+		}
+		row.Samples = latencies.TotalCount()
+		if row.Samples > 0 {
+			row.P50 = toMilliseconds(latencies.ValueAtPercentile(50))
+			row.P90 = toMilliseconds(latencies.ValueAtPercentile(90))
+			row.P99 = toMilliseconds(latencies.ValueAtPercentile(99))
+			row.Max = toMilliseconds(latencies.Max())
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	s.previous = current
+	return rows
+}
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/fix50sp2/ordercancelreject"
 	"github.com/quickfixgo/fix50sp2/quotestatusreport"
 	"github.com/quickfixgo/quickfix"
 )
@@ -28,18 +30,27 @@ var (
 				0.99: 0.005,
 			},
 		},
-		[]string{"type"},
+		[]string{"type", "session"},
 	)
 )
 
 type Manager struct {
-	context     context.Context
-	app         *SenderApp
-	orders      []Handler
-	updateTempo time.Duration
-	ordersMap   map[string]Handler
-	orderLock   sync.Mutex
-	Closed      chan bool
+	context          context.Context
+	pool             *SessionPool
+	prices           map[string]PriceModel
+	useQuoteWorkflow bool
+	orders           []Handler
+	sessions         map[Handler]*SenderApp
+	acked            map[Handler]string
+	updateTempo      atomic.Int64
+	ordersMap        map[string]Handler
+	orderLock        sync.Mutex
+	Closed           chan bool
+
+	// parked holds the handlers which had a request to send while generation was paused.
+	paused    bool
+	parked    map[Handler]bool
+	pauseLock sync.Mutex
 }
 
 func init() {
@@ -48,41 +59,65 @@ func init() {
 
 func NewManager(
 	context context.Context,
-	app *SenderApp,
+	pool *SessionPool,
 	accounts []string,
+	parties map[string][]Party,
 	symbols []string,
-	refPrices []float64,
+	instruments map[string]*Instrument,
+	prices map[string]PriceModel,
+	mix *OrderMix,
 	useQuoteWorkflow bool,
 	updateTempo time.Duration) *Manager {
 	mgr := &Manager{
-		context:     context,
-		app:         app,
-		orders:      make([]Handler, 0, len(accounts)*2*len(symbols)),
-		updateTempo: updateTempo,
-		ordersMap:   make(map[string]Handler, len(accounts)*2*len(symbols)),
-		orderLock:   sync.Mutex{},
-		Closed:      make(chan bool),
+		context:          context,
+		pool:             pool,
+		prices:           prices,
+		useQuoteWorkflow: useQuoteWorkflow,
+		orders:           make([]Handler, 0, len(accounts)*2*len(symbols)),
+		sessions:         make(map[Handler]*SenderApp, len(accounts)*2*len(symbols)),
+		acked:            make(map[Handler]string, len(accounts)*2*len(symbols)),
+		ordersMap:        make(map[string]Handler, len(accounts)*2*len(symbols)),
+		orderLock:        sync.Mutex{},
+		Closed:           make(chan bool),
+		parked:           make(map[Handler]bool),
 	}
+	mgr.updateTempo.Store(int64(updateTempo))
 
-	for idx, symbol := range symbols {
+	for _, symbol := range symbols {
+		instrument := instruments[symbol]
 		for i, account := range accounts {
-			offset := 0.10 + 0.01*float64(i)
+			offset := instrument.Ticks(10 + i)
 			if useQuoteWorkflow {
 				mgr.orders = append(
 					mgr.orders,
-					NewQuoteHandler(symbol, refPrices[idx]-offset, refPrices[idx]+offset, account),
+					NewQuoteHandler(instrument, prices[symbol], offset, account, parties[account]),
 				)
 			} else {
 				mgr.orders = append(
 					mgr.orders,
-					NewOrderHandler(symbol, refPrices[idx]-offset, enum.Side_BUY, account),
-					NewOrderHandler(symbol, refPrices[idx]+offset, enum.Side_SELL, account),
+					NewOrderHandler(instrument, prices[symbol], -offset, mix, enum.Side_BUY, account, parties[account]),
+					NewOrderHandler(instrument, prices[symbol], offset, mix, enum.Side_SELL, account, parties[account]),
 				)
 			}
 		}
 	}
 
-	go mgr.processExecutionReports()
+	for _, order := range mgr.orders {
+		mgr.sessions[order] = pool.Assign(order.GetAccount())
+	}
+
+	var wg sync.WaitGroup
+	for _, app := range pool.Apps() {
+		wg.Add(1)
+		go func(app *SenderApp) {
+			defer wg.Done()
+			mgr.processExecutionReports(app)
+		}(app)
+	}
+	go func() {
+		wg.Wait()
+		close(mgr.Closed)
+	}()
 	return mgr
 }
 func (m *Manager) getOrder(id string) (Handler, bool) {
@@ -99,121 +134,328 @@ func (m *Manager) updateClientOrderId(newId string, o Handler) {
 		delete(m.ordersMap, o.GetLastOrderId())
 	}
 	o.UpdateClientOrderId(newId)
-	m.ordersMap[newId] = o
+	if len(newId) > 0 {
+		m.ordersMap[newId] = o
+	}
+}
+
+// setAckedOrderId records the last order of the handler acknowledged by the venue.
+func (m *Manager) setAckedOrderId(o Handler, id string) {
+	m.orderLock.Lock()
+	defer m.orderLock.Unlock()
+	m.acked[o] = id
+}
+
+// getLastOrderId returns the last order sent by the handler.
+func (m *Manager) getLastOrderId(o Handler) string {
+	m.orderLock.Lock()
+	defer m.orderLock.Unlock()
+	return o.GetLastOrderId()
+}
+
+func (m *Manager) getAckedOrderId(o Handler) string {
+	m.orderLock.Lock()
+	defer m.orderLock.Unlock()
+	return m.acked[o]
 }
 
 func (m *Manager) CancelAllOrders() {
 	for _, order := range m.orders {
-		massCancel := order.BuildMassCancelRequest()
-		err := quickfix.SendToTarget(massCancel, m.app.sessionId)
-		if err != nil {
-			m.app.Logger.Err(err).Str("account", order.GetAccount()).Str("symbol", order.GetSymbol()).Any("side", order.GetSide()).Msg("Cannot send mass cancel request")
+		for _, app := range m.pool.SessionsOf(order.GetAccount()) {
+			massCancel := order.BuildMassCancelRequest()
+			err := quickfix.SendToTarget(massCancel, app.sessionId)
+			if err != nil {
+				app.Logger.Err(err).Str("account", order.GetAccount()).Str("symbol", order.GetSymbol()).Any("side", order.GetSide()).Msg("Cannot send mass cancel request")
+				continue
+			}
+			stats.recordSent(messageTypeOf(massCancel), order.GetSymbol())
 		}
 	}
 }
 
+func (m *Manager) Done() <-chan bool {
+	return m.Closed
+}
+
 func (m *Manager) Start() {
+	stats.markStart()
 	for _, order := range m.orders {
+		if m.park(order) {
+			continue
+		}
 		_ = m.sendOrderRequest(order)
 	}
 }
 
+func (m *Manager) Pause() {
+	m.pauseLock.Lock()
+	defer m.pauseLock.Unlock()
+	m.paused = true
+}
+
+// Resume sends the requests of the handlers parked while generation was paused.
+func (m *Manager) Resume() {
+	m.pauseLock.Lock()
+	m.paused = false
+	parked := m.parked
+	m.parked = make(map[Handler]bool)
+	m.pauseLock.Unlock()
+
+	for order := range parked {
+		if err := m.sendOrderRequest(order); err != nil {
+			m.sessions[order].Logger.Err(err).Str("account", order.GetAccount()).Str("symbol", order.GetSymbol()).Msg("Cannot resume order")
+		}
+	}
+}
+
+// park keeps the handler aside while generation is paused and tells whether it was parked.
+func (m *Manager) park(order Handler) bool {
+	m.pauseLock.Lock()
+	defer m.pauseLock.Unlock()
+	if !m.paused {
+		return false
+	}
+	m.parked[order] = true
+	return true
+}
+
+func (m *Manager) SetUpdateTempo(tempo time.Duration) {
+	m.updateTempo.Store(int64(tempo))
+}
+
+func (m *Manager) State() WorkflowState {
+	state := WorkflowState{
+		Workflow:    WorkflowAmend,
+		UpdateTempo: time.Duration(m.updateTempo.Load()).String(),
+	}
+	if m.useQuoteWorkflow {
+		state.Workflow = WorkflowQuote
+	}
+	m.pauseLock.Lock()
+	state.Paused = m.paused
+	m.pauseLock.Unlock()
+
+	m.orderLock.Lock()
+	defer m.orderLock.Unlock()
+	for _, order := range m.orders {
+		acked := m.acked[order]
+		if len(acked) > 0 {
+			state.OpenOrders++
+		}
+		if len(order.GetLastOrderId()) > 0 && order.GetLastOrderId() != acked {
+			state.InFlight++
+		}
+	}
+	return state
+}
+
 func (m *Manager) sendOrderRequest(order Handler) error {
+	app := m.sessions[order]
+	if !app.IsConnected() {
+		return fmt.Errorf("session %s is logged out", app.Session())
+	}
+	// The price model only moves once the request is sent
 	nos, orderId := order.BuildOrderRequest()
+	if err := m.pool.checkRisk(nos, order.GetAccount(), order.GetSymbol(), m.prices[order.GetSymbol()]); err != nil {
+		m.halt(err)
+		return err
+	}
 	m.updateClientOrderId(orderId, order)
-	err := quickfix.SendToTarget(nos, m.app.sessionId)
-	m.app.Logger.Debug().Str("clordid", orderId).Msg("New order single sent")
+	err := quickfix.SendToTarget(nos, app.sessionId)
+	app.Logger.Debug().Str("clordid", orderId).Msg("New order single sent")
 	if err != nil {
-		m.app.Logger.Err(err).Str("account", order.GetAccount()).Str("symbol", order.GetSymbol()).Any("side", order.GetSide()).Msg("Cannot send new order single")
+		m.pool.forgetRisk(orderId)
+		app.Logger.Err(err).Str("account", order.GetAccount()).Str("symbol", order.GetSymbol()).Any("side", order.GetSide()).Msg("Cannot send new order single")
 		return err
 	}
+	m.prices[order.GetSymbol()].Advance()
+	stats.recordSent(order.GetMessageType(), order.GetSymbol())
 	return nil
 }
 
+// halt mass cancels the orders and stops generation when a request breached a risk limit.
+func (m *Manager) halt(err error) {
+	var breach *RiskBreach
+	if !errors.As(err, &breach) {
+		return
+	}
+	m.pool.Logger().Error().Err(err).Msg("Risk limit breached, cancelling all orders and halting generation")
+	m.Pause()
+	m.CancelAllOrders()
+}
+
 func (m *Manager) sendMessage(order Handler, sendMessageFunc func(Handler) error) error {
-	if m.updateTempo <= 0 {
+	updateTempo := time.Duration(m.updateTempo.Load())
+	if updateTempo <= 0 {
+		if m.park(order) {
+			return nil
+		}
 		return sendMessageFunc(order)
 	}
+	// A request sent meanwhile, e.g. a new order after a cancel, supersedes the delayed one
+	lastOrderId := m.getLastOrderId(order)
 	go func() {
-		time.Sleep(m.updateTempo)
+		time.Sleep(updateTempo)
+		if m.context.Err() != nil || m.getLastOrderId(order) != lastOrderId || m.park(order) {
+			return
+		}
 		_ = sendMessageFunc(order)
 	}()
 	return nil
 }
 
-func (m *Manager) processExecutionReports() {
+func (m *Manager) processExecutionReports(app *SenderApp) {
 LOOP:
 	for {
 		select {
-		case msg, ok := <-m.app.ExecReportNotification:
+		case msg, ok := <-app.ExecReportNotification:
 			if !ok {
 				break LOOP
 			}
-			if err := m.processExecutionReport(msg); err != nil {
-				m.app.Logger.Error().Err(err).Any("msg", strings.Replace(msg.Message.String(), "\001", "|", -1)).Msg("Cannot process fix message")
+			if err := m.processExecutionReport(app, msg); err != nil {
+				app.Logger.Error().Err(err).Any("msg", strings.Replace(msg.Message.String(), "\001", "|", -1)).Msg("Cannot process fix message")
 			}
 
-		case msg, ok := <-m.app.QuoteStatusReportNotification:
+		case msg, ok := <-app.QuoteStatusReportNotification:
 			if !ok {
 				break LOOP
 			}
-			if err := m.processQuoteStatusReport(msg); err != nil {
-				m.app.Logger.Error().Err(err).Any("msg", strings.Replace(msg.Message.String(), "\001", "|", -1)).Msg("Cannot process fix message")
+			if err := m.processQuoteStatusReport(app, msg); err != nil {
+				app.Logger.Error().Err(err).Any("msg", strings.Replace(msg.Message.String(), "\001", "|", -1)).Msg("Cannot process fix message")
 			}
 
+		case msg, ok := <-app.OrderCancelRejectNotification:
+			if !ok {
+				break LOOP
+			}
+			if err := m.processOrderCancelReject(app, msg); err != nil {
+				app.Logger.Error().Err(err).Any("msg", strings.Replace(msg.Message.String(), "\001", "|", -1)).Msg("Cannot process fix message")
+			}
+
+		case <-app.ReconnectNotification:
+			m.resumeSession(app)
+
 		case <-m.context.Done():
-			m.app.Logger.Error().Err(m.context.Err()).Msg("Order manager is stopping")
-			m.Closed <- true
+			app.Logger.Error().Err(m.context.Err()).Str("session", app.Session()).Msg("Order manager is stopping")
 			return
 		}
 	}
 }
 
-func (m *Manager) processExecutionReport(execReport executionreport.ExecutionReport) error {
+func (m *Manager) processExecutionReport(app *SenderApp, execReport executionreport.ExecutionReport) error {
 	clOrdId, err := execReport.GetClOrdID()
 	if err != nil {
 		return errors.New("missing ClOrdID in ExecutionReport")
 	}
 	order, found := m.getOrder(clOrdId)
 	if !found {
-		m.app.Logger.Trace().Str("clOrdId", clOrdId).Msg("Order not found")
+		app.Logger.Trace().Str("clOrdId", clOrdId).Msg("Order not found")
 		return nil
 	}
-	metricOrderRoundtrip.WithLabelValues(order.GetMessageType()).Observe(time.Since(order.GetTimestamp()).Seconds())
 	status, err := execReport.GetOrdStatus()
 	if err != nil {
 		return errors.New("missing OrdStatus in ExecutionReport")
 	}
 	switch status {
+	case enum.OrdStatus_PENDING_NEW, enum.OrdStatus_PENDING_REPLACE, enum.OrdStatus_PENDING_CANCEL:
+		// The request is answered by the report following the pending one
+		return nil
+	}
+	// Executions and cancels of an acknowledged order don't answer a request
+	if m.getAckedOrderId(order) != clOrdId {
+		latency := time.Since(order.GetTimestamp())
+		metricOrderRoundtrip.WithLabelValues(order.GetMessageType(), app.Session()).Observe(latency.Seconds())
+		stats.recordLatency(order.GetMessageType(), order.GetSymbol(), latency)
+		if status == enum.OrdStatus_REJECTED {
+			stats.recordReject(order.GetMessageType(), order.GetSymbol())
+		} else {
+			stats.recordAck(order.GetMessageType(), order.GetSymbol())
+		}
+	}
+	switch status {
 	case enum.OrdStatus_NEW:
 		fallthrough
 	case enum.OrdStatus_REPLACED:
 		fallthrough
 	case enum.OrdStatus_PARTIALLY_FILLED:
+		m.setAckedOrderId(order, clOrdId)
+		if !order.Rests() {
+			// The venue ends the order after its executions
+			return nil
+		}
 		return m.sendMessage(order, m.sendOrderRequest)
-	case enum.OrdStatus_FILLED:
+	case enum.OrdStatus_FILLED, enum.OrdStatus_CANCELED, enum.OrdStatus_EXPIRED:
+		// The order is over, a new one takes its place, right away when the
+		// cancel was requested by the handler
+		cancelled := status == enum.OrdStatus_CANCELED && order.GetMessageType() == msgTypeOrderCancelRequest && m.getLastOrderId(order) == clOrdId
+		m.setAckedOrderId(order, "")
 		m.updateClientOrderId("", order)
+		if cancelled {
+			if m.park(order) {
+				return nil
+			}
+			return m.sendOrderRequest(order)
+		}
+		return m.sendMessage(order, m.sendOrderRequest)
+	case enum.OrdStatus_REJECTED:
+		// A rejected new order frees the handler, a rejected amend leaves the
+		// acknowledged order live and the handler takes another action on it
+		m.updateClientOrderId(m.getAckedOrderId(order), order)
 		return m.sendMessage(order, m.sendOrderRequest)
 	default:
 		return fmt.Errorf("order status not handled: %v", status)
 	}
 }
 
-func (m *Manager) processQuoteStatusReport(qsReport quotestatusreport.QuoteStatusReport) error {
+// processOrderCancelReject creates a new order when the order to amend or cancel
+// is unknown to the venue, e.g. when it was lost while the session was logged out
+// or filled while the request was in flight. A reject without reason is taken as
+// an unknown order. Otherwise the order is still live: the handler keeps it and
+// takes another action on it.
+func (m *Manager) processOrderCancelReject(app *SenderApp, reject ordercancelreject.OrderCancelReject) error {
+	clOrdId, err := reject.GetClOrdID()
+	if err != nil {
+		return errors.New("missing ClOrdID in OrderCancelReject")
+	}
+	order, found := m.getOrder(clOrdId)
+	if !found {
+		app.Logger.Trace().Str("clOrdId", clOrdId).Msg("Order not found")
+		return nil
+	}
+	reason, err := reject.GetCxlRejReason()
+	switch {
+	case err != nil, reason == enum.CxlRejReason_UNKNOWN_ORDER, reason == enum.CxlRejReason_TOO_LATE_TO_CANCEL:
+		m.setAckedOrderId(order, "")
+		m.updateClientOrderId("", order)
+	default:
+		app.Logger.Debug().Str("clOrdId", clOrdId).Str("reason", string(reason)).Msg("Request rejected, order still live")
+		m.updateClientOrderId(m.getAckedOrderId(order), order)
+	}
+	return m.sendMessage(order, m.sendOrderRequest)
+}
+
+func (m *Manager) processQuoteStatusReport(app *SenderApp, qsReport quotestatusreport.QuoteStatusReport) error {
 	quoteId, err := qsReport.GetQuoteID()
 	if err != nil {
 		return errors.New("missing QuoteID in QuoteStatusReport")
 	}
 	order, found := m.getOrder(quoteId)
 	if !found {
-		m.app.Logger.Trace().Str("quoteId", quoteId).Msg("Quote not found")
+		app.Logger.Trace().Str("quoteId", quoteId).Msg("Quote not found")
 		return nil
 	}
-	metricOrderRoundtrip.WithLabelValues(order.GetMessageType()).Observe(time.Since(order.GetTimestamp()).Seconds())
+	latency := time.Since(order.GetTimestamp())
+	metricOrderRoundtrip.WithLabelValues(order.GetMessageType(), app.Session()).Observe(latency.Seconds())
+	stats.recordLatency(order.GetMessageType(), order.GetSymbol(), latency)
 	status, err := qsReport.GetQuoteStatus()
 	if err != nil {
 		return errors.New("missing QuoteStatus in QuoteStatusReport")
 	}
+	if status == enum.QuoteStatus_ACCEPTED {
+		stats.recordAck(order.GetMessageType(), order.GetSymbol())
+	} else {
+		stats.recordReject(order.GetMessageType(), order.GetSymbol())
+	}
 	switch status {
 	case enum.QuoteStatus_ACCEPTED:
 		_, err := qsReport.GetBidQuoteID()
@@ -224,6 +466,7 @@ func (m *Manager) processQuoteStatusReport(qsReport quotestatusreport.QuoteStatu
 		if err != nil {
 			return errors.New("missing OfferQuoteID")
 		}
+		m.setAckedOrderId(order, quoteId)
 		return m.sendMessage(order, m.sendOrderRequest)
 	case enum.QuoteStatus_REJECTED:
 		fallthrough
@@ -233,3 +476,32 @@ func (m *Manager) processQuoteStatusReport(qsReport quotestatusreport.QuoteStatu
 		return fmt.Errorf("quote status not handled: %v", status)
 	}
 }
+
+// resumeSession restarts the handlers of a session which logged on again. Their
+// in-flight requests are forgotten: handlers either amend their last acknowledged
+// order again, or have their orders mass cancelled and created again.
+func (m *Manager) resumeSession(app *SenderApp) {
+	restart := m.pool.restartOnReconnect()
+	app.Logger.Warn().Str("session", app.Session()).Bool("restart", restart).Msg("Session logged on again, resuming orders")
+	for _, order := range m.orders {
+		if m.sessions[order] != app {
+			continue
+		}
+		if restart {
+			massCancel := order.BuildMassCancelRequest()
+			if err := quickfix.SendToTarget(massCancel, app.sessionId); err != nil {
+				app.Logger.Err(err).Str("account", order.GetAccount()).Str("symbol", order.GetSymbol()).Any("side", order.GetSide()).Msg("Cannot send mass cancel request")
+			} else {
+				stats.recordSent(messageTypeOf(massCancel), order.GetSymbol())
+			}
+			m.setAckedOrderId(order, "")
+		}
+		m.updateClientOrderId(m.getAckedOrderId(order), order)
+		if m.park(order) {
+			continue
+		}
+		if err := m.sendOrderRequest(order); err != nil {
+			app.Logger.Err(err).Str("account", order.GetAccount()).Str("symbol", order.GetSymbol()).Msg("Cannot resume order")
+		}
+	}
+}
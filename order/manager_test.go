@@ -0,0 +1,163 @@
+package order
+
+import (
+	"context"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/fix50sp2/ordercancelreject"
+	"github.com/quickfixgo/quickfix"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"sylr.dev/fix/config"
+	fixutils "sylr.dev/fix/pkg/utils"
+)
+
+// countingHandler counts the mass cancels of its orders.
+type countingHandler struct {
+	*OrderHandler
+	massCancels int
+}
+
+func (h *countingHandler) BuildMassCancelRequest() quickfix.Messagable {
+	h.massCancels++
+	return h.OrderHandler.BuildMassCancelRequest()
+}
+
+// newTestManager creates an amend workflow with a single handler on a logged on
+// session. The session is not registered in quickfix: sends fail once the
+// requests are built and checked.
+func newTestManager(t *testing.T) (*Manager, *SenderApp, *countingHandler) {
+	t.Helper()
+	logger := zerolog.Nop()
+	app := &SenderApp{
+		QuickFixAppMessageLogger: fixutils.QuickFixAppMessageLogger{Logger: &logger},
+		sessionConfig:            &config.Session{Name: "test"},
+	}
+	app.isConnectionUp.Store(true)
+	pool, err := NewSessionPool([]*SenderApp{app}, DispatchAccount, ReconnectResume)
+	if err != nil {
+		t.Fatalf("NewSessionPool: %v", err)
+	}
+	prices := NewUniformPrice(100, 0)
+	handler := &countingHandler{OrderHandler: NewOrderHandler(DefaultInstrument("XXX"), prices, -1, DefaultOrderMix(), enum.Side_BUY, "A1", nil)}
+	m := &Manager{
+		context:   context.Background(),
+		pool:      pool,
+		prices:    map[string]PriceModel{"XXX": prices},
+		orders:    []Handler{handler},
+		sessions:  map[Handler]*SenderApp{handler: app},
+		acked:     make(map[Handler]string),
+		ordersMap: make(map[string]Handler),
+		parked:    make(map[Handler]bool),
+		Closed:    make(chan bool),
+	}
+	return m, app, handler
+}
+
+func testExecutionReport(clOrdId string, status enum.OrdStatus) executionreport.ExecutionReport {
+	execType := enum.ExecType(status)
+	if status == enum.OrdStatus_PARTIALLY_FILLED || status == enum.OrdStatus_FILLED {
+		execType = enum.ExecType_TRADE
+	}
+	er := executionreport.New(
+		field.NewOrderID("O1"),
+		field.NewExecID(clOrdId+"/"+string(status)),
+		field.NewExecType(execType),
+		field.NewOrdStatus(status),
+		field.NewSide(enum.Side_BUY),
+		field.NewLeavesQty(decimal.Zero, 0),
+		field.NewCumQty(decimal.Zero, 0),
+	)
+	er.SetClOrdID(clOrdId)
+	return er
+}
+
+func TestManagerExecutionReports(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  enum.OrdStatus
+		acked   bool
+		request string
+	}{
+		{"pending new is ignored", enum.OrdStatus_PENDING_NEW, false, ""},
+		{"new order acknowledged then amended", enum.OrdStatus_NEW, true, msgTypeOrderCancelReplaceRequest},
+		{"rejected order replaced by a new one", enum.OrdStatus_REJECTED, false, msgTypeNewOrderSingle},
+		{"filled order replaced by a new one", enum.OrdStatus_FILLED, false, msgTypeNewOrderSingle},
+		{"expired order replaced by a new one", enum.OrdStatus_EXPIRED, false, msgTypeNewOrderSingle},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetRun()
+			m, app, handler := newTestManager(t)
+			_ = m.sendOrderRequest(handler)
+			sent := handler.GetLastOrderId()
+			// The request following the report fails on the unregistered session
+			err := m.processExecutionReport(app, testExecutionReport(sent, test.status))
+			if len(test.request) == 0 && err != nil {
+				t.Fatalf("processExecutionReport: %v", err)
+			}
+			if acked := m.getAckedOrderId(handler); (acked == sent) != test.acked {
+				t.Fatalf("acknowledged order %q after %s", acked, test.status)
+			}
+			if len(test.request) == 0 {
+				if handler.GetLastOrderId() != sent {
+					t.Fatalf("request sent after %s", test.status)
+				}
+				return
+			}
+			if handler.GetLastOrderId() == sent || handler.GetMessageType() != test.request {
+				t.Fatalf("%s sent after %s, expected %s", handler.GetMessageType(), test.status, test.request)
+			}
+		})
+	}
+}
+
+func TestManagerOrderCancelReject(t *testing.T) {
+	tests := []struct {
+		name   string
+		reason enum.CxlRejReason
+		live   bool
+	}{
+		{"unknown order", enum.CxlRejReason_UNKNOWN_ORDER, false},
+		{"too late to cancel", enum.CxlRejReason_TOO_LATE_TO_CANCEL, false},
+		{"no reason", "", false},
+		{"price out of band", enum.CxlRejReason_PRICE_EXCEEDS_CURRENT_PRICE_BAND, true},
+		{"pending replace", enum.CxlRejReason_ORDER_ALREADY_IN_PENDING_CANCEL_OR_PENDING_REPLACE_STATUS, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetRun()
+			m, app, handler := newTestManager(t)
+			_ = m.sendOrderRequest(handler)
+			order := handler.GetLastOrderId()
+			_ = m.processExecutionReport(app, testExecutionReport(order, enum.OrdStatus_NEW))
+			replace := handler.GetLastOrderId()
+
+			reject := ordercancelreject.New(
+				field.NewOrderID("O1"),
+				field.NewClOrdID(replace),
+				field.NewOrdStatus(enum.OrdStatus_NEW),
+				field.NewCxlRejResponseTo(enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST),
+			)
+			reject.SetOrigClOrdID(order)
+			if len(test.reason) > 0 {
+				reject.SetCxlRejReason(test.reason)
+			}
+			_ = m.processOrderCancelReject(app, reject)
+
+			if handler.GetLastOrderId() == replace {
+				t.Fatal("no request sent after the reject")
+			}
+			if test.live {
+				if m.getAckedOrderId(handler) != order || handler.GetMessageType() != msgTypeOrderCancelReplaceRequest {
+					t.Fatalf("live order %s not amended again, %s sent", order, handler.GetMessageType())
+				}
+			} else if m.getAckedOrderId(handler) != "" || handler.GetMessageType() != msgTypeNewOrderSingle {
+				t.Fatalf("unknown order not replaced by a new one, %s sent", handler.GetMessageType())
+			}
+		})
+	}
+}
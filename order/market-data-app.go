@@ -0,0 +1,302 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/marketdataincrementalrefresh"
+	"github.com/quickfixgo/fix50sp2/marketdatarequest"
+	"github.com/quickfixgo/fix50sp2/marketdatarequestreject"
+	"github.com/quickfixgo/fix50sp2/marketdatasnapshotfullrefresh"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/rs/zerolog"
+	"sylr.dev/fix/config"
+	fixerrors "sylr.dev/fix/pkg/errors"
+	"sylr.dev/fix/pkg/initiator"
+	fixutils "sylr.dev/fix/pkg/utils"
+)
+
+// MarketDataApp subscribes to the market data of the symbols on its own session
+// and keeps their books up to date. The subscription is sent again after each logon.
+type MarketDataApp struct {
+	// Logger.
+	fixutils.QuickFixAppMessageLogger
+
+	// Quickfix settings of the market data session.
+	settings *quickfix.Settings
+
+	// Quickfix initiator.
+	initiator *quickfix.Initiator
+
+	// Message router.
+	*quickfix.MessageRouter
+
+	// logonStatusChan is a chan raising connection status event.
+	logonStatusChan chan bool
+
+	// sessionId is the session connected to the market data feed.
+	sessionId quickfix.SessionID
+
+	isConnectionUp atomic.Bool
+
+	// market is the book of each subscribed symbol.
+	market *MarketData
+
+	// Closed is a chan to notify when application is closed properly.
+	Closed chan bool
+
+	isStopping atomic.Bool
+}
+
+var _ quickfix.Application = (*MarketDataApp)(nil)
+
+// NewMarketDataApp creates an Application subscribing to the market data of the symbols.
+func NewMarketDataApp(
+	ctx context.Context,
+	quickFixAppMessageLogger fixutils.QuickFixAppMessageLogger,
+	settings *quickfix.Settings,
+	symbols []string) *MarketDataApp {
+	app := MarketDataApp{
+		QuickFixAppMessageLogger: quickFixAppMessageLogger,
+		MessageRouter:            quickfix.NewMessageRouter(),
+		settings:                 settings,
+		logonStatusChan:          make(chan bool),
+		market:                   NewMarketData(symbols),
+		Closed:                   make(chan bool),
+	}
+
+	app.MessageRouter.AddRoute(marketdatasnapshotfullrefresh.Route(app.onSnapshotFullRefresh))
+	app.MessageRouter.AddRoute(marketdataincrementalrefresh.Route(app.onIncrementalRefresh))
+	app.MessageRouter.AddRoute(marketdatarequestreject.Route(app.onMarketDataRequestReject))
+
+	go app.handleContextDone(ctx)
+
+	return &app
+}
+
+func (a *MarketDataApp) handleContextDone(ctx context.Context) {
+	<-ctx.Done()
+	a.isStopping.Store(true)
+	if a.initiator != nil {
+		a.initiator.Stop()
+	}
+	close(a.logonStatusChan)
+	a.Closed <- true
+}
+
+// Market returns the books kept up to date by the market data feed.
+func (a *MarketDataApp) Market() *MarketData {
+	return a.market
+}
+
+// OnCreate is called when a session is created. Note that sessions are created
+// upon initiator/acceptor start and not when a connection is established.
+func (a *MarketDataApp) OnCreate(sessionID quickfix.SessionID) {
+	a.Logger.Debug().Str("session", sessionID.String()).Msg("Created")
+}
+
+// OnLogon is called when a FIX logon occurs.
+func (a *MarketDataApp) OnLogon(sessionID quickfix.SessionID) {
+	a.Logger.Info().Str("session", sessionID.String()).Msg("Successful Logon")
+	a.sessionId = sessionID
+	a.logonStatusChan <- true
+}
+
+// OnLogout is called when a FIX logout occurs.
+func (a *MarketDataApp) OnLogout(sessionID quickfix.SessionID) {
+	a.Logger.Info().Str("session", sessionID.String()).Msg("Successful Logout")
+	if !a.isStopping.Load() {
+		a.logonStatusChan <- false
+	}
+}
+
+// ToAdmin is called when sending a FIX message regarding the FIX protocol, e.g.:
+// LOGIN, LOGOUT, HEARTBEAT, TEST ... etc.
+func (a *MarketDataApp) ToAdmin(message *quickfix.Message, sessionID quickfix.SessionID) {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, true)
+
+	typ, err := message.MsgType()
+	if err != nil || typ != string(enum.MsgType_LOGON) {
+		return
+	}
+	if session, ok := a.settings.SessionSettings()[sessionID]; ok {
+		for _, credential := range []struct {
+			setting string
+			tag     quickfix.Tag
+		}{
+			{"Username", tag.Username},
+			{"Password", tag.Password},
+		} {
+			if value, err := session.Setting(credential.setting); err == nil && len(value) > 0 {
+				message.Header.SetField(credential.tag, quickfix.FIXString(value))
+			}
+		}
+	}
+}
+
+// FromAdmin is called when receiving a FIX message regarding the FIX protocol, e.g.:
+// LOGIN, LOGOUT, HEARTBEAT, TEST ... etc.
+func (a *MarketDataApp) FromAdmin(message *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, false)
+	return nil
+}
+
+// ToApp is called when sending a FIX message that is not considered "Admin".
+func (a *MarketDataApp) ToApp(message *quickfix.Message, sessionID quickfix.SessionID) error {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, true)
+	return nil
+}
+
+// FromApp is called when receiving a FIX message that is not considered "Admin".
+func (a *MarketDataApp) FromApp(message *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, false)
+	return a.MessageRouter.Route(message, sessionID)
+}
+
+func (a *MarketDataApp) Connect() error {
+	opt := config.GetOptions()
+	var quickfixLogger *zerolog.Logger
+	if opt.QuickFixLogging {
+		quickfixLogger = a.Logger
+	}
+	var err error
+	a.initiator, err = initiator.Initiate(a, a.settings, quickfixLogger)
+	if err != nil {
+		return fmt.Errorf("unable to create market data initiator: %s", err)
+	}
+
+	err = a.initiator.Start()
+	if err != nil {
+		return fmt.Errorf("unable to start market data initiator: %s", err)
+	}
+
+	// Wait for session connection
+	select {
+	case <-time.After(30 * time.Second):
+		return errors.New("cannot connect to FIX market data acceptor")
+	case status, ok := <-a.logonStatusChan:
+		if !ok {
+			return fixerrors.FixLogout
+		}
+		a.isConnectionUp.Store(status)
+	}
+	if err := a.subscribe(); err != nil {
+		return err
+	}
+	go func() {
+		for status := range a.logonStatusChan {
+			wasUp := a.isConnectionUp.Swap(status)
+			if status && !wasUp {
+				if err := a.subscribe(); err != nil {
+					a.Logger.Error().Err(err).Msg("Cannot subscribe to market data")
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// subscribe requests a snapshot of the bids and offers of the symbols followed by incremental updates.
+func (a *MarketDataApp) subscribe() error {
+	request := marketdatarequest.New(
+		field.NewMDReqID(uuid.New().String()),
+		field.NewSubscriptionRequestType(enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES),
+		field.NewMarketDepth(0),
+	)
+	request.SetMDUpdateType(enum.MDUpdateType_INCREMENTAL_REFRESH)
+	entryTypes := marketdatarequest.NewNoMDEntryTypesRepeatingGroup()
+	entryTypes.Add().SetMDEntryType(enum.MDEntryType_BID)
+	entryTypes.Add().SetMDEntryType(enum.MDEntryType_OFFER)
+	request.SetNoMDEntryTypes(entryTypes)
+	symbols := marketdatarequest.NewNoRelatedSymRepeatingGroup()
+	for _, symbol := range a.market.Symbols() {
+		symbols.Add().SetSymbol(symbol)
+	}
+	request.SetNoRelatedSym(symbols)
+
+	if err := quickfix.SendToTarget(request, a.sessionId); err != nil {
+		return err
+	}
+	a.Logger.Info().Strs("symbols", a.market.Symbols()).Msg("MarketDataRequest sent")
+	return nil
+}
+
+func (a *MarketDataApp) onSnapshotFullRefresh(msg marketdatasnapshotfullrefresh.MarketDataSnapshotFullRefresh, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	symbol, err := msg.GetSymbol()
+	if err != nil {
+		return err
+	}
+	entries, err := msg.GetNoMDEntries()
+	if err != nil {
+		return err
+	}
+	updates := make([]marketUpdate, 0, entries.Len())
+	for i := 0; i < entries.Len(); i++ {
+		entry := entries.Get(i)
+		entryType, err := entry.GetMDEntryType()
+		if err != nil {
+			return err
+		}
+		price, _ := entry.GetMDEntryPx()
+		size, _ := entry.GetMDEntrySize()
+		updates = append(updates, marketUpdate{
+			action:    enum.MDUpdateAction_NEW,
+			entryType: entryType,
+			price:     price,
+			size:      size,
+		})
+	}
+	a.market.apply(symbol, updates, true, msg.Message.ReceiveTime)
+	return nil
+}
+
+func (a *MarketDataApp) onIncrementalRefresh(msg marketdataincrementalrefresh.MarketDataIncrementalRefresh, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	entries, err := msg.GetNoMDEntries()
+	if err != nil {
+		return err
+	}
+	bySymbol := make(map[string][]marketUpdate)
+	for i := 0; i < entries.Len(); i++ {
+		entry := entries.Get(i)
+		symbol, err := entry.GetSymbol()
+		if err != nil {
+			// Entries of a symbol which was not subscribed are of no use
+			continue
+		}
+		action, err := entry.GetMDUpdateAction()
+		if err != nil {
+			return err
+		}
+		entryType, _ := entry.GetMDEntryType()
+		price, _ := entry.GetMDEntryPx()
+		size, _ := entry.GetMDEntrySize()
+		bySymbol[symbol] = append(bySymbol[symbol], marketUpdate{
+			action:    action,
+			entryType: entryType,
+			price:     price,
+			size:      size,
+		})
+	}
+	for symbol, updates := range bySymbol {
+		a.market.apply(symbol, updates, false, msg.Message.ReceiveTime)
+	}
+	return nil
+}
+
+func (a *MarketDataApp) onMarketDataRequestReject(msg marketdatarequestreject.MarketDataRequestReject, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	reqId, _ := msg.GetMDReqID()
+	reason, err := msg.GetText()
+	if err != nil {
+		reason = "no reason"
+	}
+	a.Logger.Error().Str("mdReqId", reqId).Str("reason", reason).Msg("MarketDataRequest rejected, reference prices are not following the market")
+	return nil
+}
@@ -0,0 +1,253 @@
+package order
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FollowMid = "mid"
+	FollowBBO = "bbo"
+)
+
+// MarketData keeps the book of each subscribed symbol up to date from the market
+// data feed of the venue.
+type MarketData struct {
+	books map[string]*marketBook
+
+	// feed measures the latency between the requests of the gatling and their publication.
+	feed *feedTracker
+	// own holds the orders and quotes of the gatling shown in the books.
+	own *ownOrders
+}
+
+// marketBook holds the bid and offer price levels of a symbol, indexed by price.
+// The best bid and offer are computed on each update.
+type marketBook struct {
+	bids     map[string]marketEntry
+	offers   map[string]marketEntry
+	bestBid  float64
+	bestAsk  float64
+	hasBid   bool
+	hasOffer bool
+	lock     sync.RWMutex
+}
+
+type marketEntry struct {
+	price decimal.Decimal
+	size  decimal.Decimal
+}
+
+func NewMarketData(symbols []string) *MarketData {
+	books := make(map[string]*marketBook, len(symbols))
+	for _, symbol := range symbols {
+		books[symbol] = &marketBook{
+			bids:   make(map[string]marketEntry),
+			offers: make(map[string]marketEntry),
+		}
+	}
+	return &MarketData{books: books, feed: newFeedTracker(symbols), own: newOwnOrders()}
+}
+
+// Symbols returns the subscribed symbols.
+func (m *MarketData) Symbols() []string {
+	symbols := make([]string, 0, len(m.books))
+	for symbol := range m.books {
+		symbols = append(symbols, symbol)
+	}
+	return symbols
+}
+
+// BestBidOffer returns the best bid and offer of a symbol, a side being missing
+// when it has no entry.
+func (m *MarketData) BestBidOffer(symbol string) (bid float64, hasBid bool, offer float64, hasOffer bool) {
+	book, found := m.books[symbol]
+	if !found {
+		return 0, false, 0, false
+	}
+	book.lock.RLock()
+	defer book.lock.RUnlock()
+	return book.bestBid, book.hasBid, book.bestAsk, book.hasOffer
+}
+
+// othersBestBidOffer returns the best bid and offer of a symbol leaving out the
+// quantity shown by the gatling, levels only made of its orders being skipped.
+func (m *MarketData) othersBestBidOffer(symbol string) (bid float64, hasBid bool, offer float64, hasOffer bool) {
+	book, found := m.books[symbol]
+	if !found {
+		return 0, false, 0, false
+	}
+	book.lock.RLock()
+	defer book.lock.RUnlock()
+	others := func(side enum.Side, entries map[string]marketEntry) map[string]marketEntry {
+		levels := make(map[string]marketEntry, len(entries))
+		for key, entry := range entries {
+			if entry.size.GreaterThan(m.own.shown(feedKey{symbol: symbol, side: side, price: key})) {
+				levels[key] = entry
+			}
+		}
+		return levels
+	}
+	bid, hasBid = bestPrice(others(enum.Side_BUY, book.bids), decimal.Decimal.GreaterThan)
+	offer, hasOffer = bestPrice(others(enum.Side_SELL, book.offers), decimal.Decimal.LessThan)
+	return bid, hasBid, offer, hasOffer
+}
+
+// marketUpdate is an entry of a snapshot or incremental refresh.
+type marketUpdate struct {
+	action    enum.MDUpdateAction
+	entryType enum.MDEntryType
+	price     decimal.Decimal
+	size      decimal.Decimal
+}
+
+// apply updates the book of a symbol, the book being cleared first for a snapshot.
+// Price levels updated by an incremental refresh publish the pending requests of
+// the gatling on these levels.
+func (m *MarketData) apply(symbol string, updates []marketUpdate, snapshot bool, received time.Time) {
+	book, found := m.books[symbol]
+	if !found {
+		return
+	}
+	changed := book.update(updates, snapshot)
+	for _, key := range changed {
+		key.symbol = symbol
+		m.feed.published(key, received)
+	}
+}
+
+func (book *marketBook) update(updates []marketUpdate, snapshot bool) []feedKey {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+	changed := make([]feedKey, 0)
+	if snapshot {
+		clear(book.bids)
+		clear(book.offers)
+	}
+	for _, update := range updates {
+		var entries map[string]marketEntry
+		var side enum.Side
+		switch update.entryType {
+		case enum.MDEntryType_BID:
+			entries, side = book.bids, enum.Side_BUY
+		case enum.MDEntryType_OFFER:
+			entries, side = book.offers, enum.Side_SELL
+		default:
+			continue
+		}
+		key := update.price.String()
+		if !snapshot {
+			changed = append(changed, feedKey{side: side, price: key})
+		}
+		switch update.action {
+		case enum.MDUpdateAction_NEW, enum.MDUpdateAction_CHANGE:
+			entries[key] = marketEntry{price: update.price, size: update.size}
+		case enum.MDUpdateAction_DELETE:
+			delete(entries, key)
+		}
+	}
+	book.bestBid, book.hasBid = bestPrice(book.bids, decimal.Decimal.GreaterThan)
+	book.bestAsk, book.hasOffer = bestPrice(book.offers, decimal.Decimal.LessThan)
+	return changed
+}
+
+func bestPrice(entries map[string]marketEntry, better func(decimal.Decimal, decimal.Decimal) bool) (float64, bool) {
+	var best decimal.Decimal
+	found := false
+	for _, entry := range entries {
+		if !found || better(entry.price, best) {
+			best = entry.price
+			found = true
+		}
+	}
+	return best.InexactFloat64(), found
+}
+
+// MarketPrice places orders around the market: at an offset from the mid price, or
+// from the best bid for bids and the best offer for offers. The best bid and offer
+// leave out the orders of the gatling, which would otherwise pull the prices along.
+// The fallback model gives the prices as long as the book of the symbol is empty.
+type MarketPrice struct {
+	market   *MarketData
+	symbol   string
+	follow   string
+	band     float64
+	fallback PriceModel
+}
+
+func NewMarketPrice(market *MarketData, symbol string, follow string, fallback PriceModel) *MarketPrice {
+	return &MarketPrice{
+		market:   market,
+		symbol:   symbol,
+		follow:   follow,
+		band:     defaultPriceBand,
+		fallback: fallback,
+	}
+}
+
+// Reference returns the mid price, or the price of the only side of the book.
+func (m *MarketPrice) Reference() float64 {
+	bid, hasBid, offer, hasOffer := m.bestBidOffer()
+	switch {
+	case hasBid && hasOffer:
+		return (bid + offer) / 2
+	case hasBid:
+		return bid
+	case hasOffer:
+		return offer
+	default:
+		return m.fallback.Reference()
+	}
+}
+
+// Advance moves the fallback model, when it gave the price of the order.
+func (m *MarketPrice) Advance() {
+	m.fallback.Advance()
+}
+
+func (m *MarketPrice) Price(offset float64) float64 {
+	bid, hasBid, offer, hasOffer := m.bestBidOffer()
+	var ref float64
+	switch {
+	case hasBid && hasOffer && m.follow == FollowBBO && offset < 0:
+		ref = bid
+	case hasBid && hasOffer && m.follow == FollowBBO && offset > 0:
+		ref = offer
+	case hasBid && hasOffer:
+		ref = (bid + offer) / 2
+	case hasBid:
+		ref = bid
+	case hasOffer:
+		ref = offer
+	default:
+		return m.fallback.Price(offset)
+	}
+	return ref + offset + jitter(m.band)
+}
+
+func (m *MarketPrice) bestBidOffer() (bid float64, hasBid bool, offer float64, hasOffer bool) {
+	if m.follow == FollowBBO {
+		return m.market.othersBestBidOffer(m.symbol)
+	}
+	return m.market.BestBidOffer(m.symbol)
+}
+
+// FollowMarket makes the prices of the subscribed symbols follow the market, the
+// given models being used until the market data of a symbol is received.
+func FollowMarket(models map[string]PriceModel, market *MarketData, follow string) (map[string]PriceModel, error) {
+	if follow != FollowMid && follow != FollowBBO {
+		return nil, fmt.Errorf("unknown market reference %q, must be %s or %s", follow, FollowMid, FollowBBO)
+	}
+	followed := make(map[string]PriceModel, len(models))
+	for symbol, model := range models {
+		if _, found := market.books[symbol]; found {
+			model = NewMarketPrice(market, symbol, follow, model)
+		}
+		followed[symbol] = model
+	}
+	return followed, nil
+}
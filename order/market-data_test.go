@@ -0,0 +1,118 @@
+package order
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/fix50sp2/quotestatusreport"
+	"github.com/shopspring/decimal"
+)
+
+// testRestingOrder acknowledges an order of the gatling resting on the book.
+func testRestingOrder(clOrdId string, side enum.Side, price float64, qty int64) executionreport.ExecutionReport {
+	er := executionreport.New(
+		field.NewOrderID(clOrdId),
+		field.NewExecID(clOrdId+"/new"),
+		field.NewExecType(enum.ExecType_NEW),
+		field.NewOrdStatus(enum.OrdStatus_NEW),
+		field.NewSide(side),
+		field.NewLeavesQty(decimal.NewFromInt(qty), 0),
+		field.NewCumQty(decimal.Zero, 0),
+	)
+	er.SetClOrdID(clOrdId)
+	er.SetSymbol("XXX")
+	er.SetPrice(decimal.NewFromFloat(price), 2)
+	return er
+}
+
+// testLevel is a price level of the book published by the venue.
+func testLevel(entryType enum.MDEntryType, price float64, size int64) marketUpdate {
+	return marketUpdate{
+		action:    enum.MDUpdateAction_NEW,
+		entryType: entryType,
+		price:     decimal.NewFromFloat(price),
+		size:      decimal.NewFromInt(size),
+	}
+}
+
+func newTestMarketPrice() (*MarketData, *MarketPrice) {
+	market := NewMarketData([]string{"XXX"})
+	prices := NewMarketPrice(market, "XXX", FollowBBO, NewUniformPrice(100, 0))
+	prices.band = 0
+	return market, prices
+}
+
+func TestFollowBBOLeavesOutOwnOrders(t *testing.T) {
+	market, prices := newTestMarketPrice()
+
+	// The book is only made of the orders of the gatling, each round replacing
+	// the previous ones at the price they reference
+	bid, offer := 0.0, 0.0
+	for round := 0; round < 10; round++ {
+		bid, offer = prices.Price(-1), prices.Price(1)
+		prices.Advance()
+		market.own.recordExecutionReport(testRestingOrder(fmt.Sprintf("B%d", round), enum.Side_BUY, bid, 10))
+		market.own.recordExecutionReport(testRestingOrder(fmt.Sprintf("S%d", round), enum.Side_SELL, offer, 10))
+		market.apply("XXX", []marketUpdate{testLevel(enum.MDEntryType_BID, bid, 10*int64(round+1)), testLevel(enum.MDEntryType_OFFER, offer, 10*int64(round+1))}, true, time.Now())
+	}
+	if bid != 99 || offer != 101 {
+		t.Fatalf("prices drifted to %v/%v on a book made of the orders of the gatling", bid, offer)
+	}
+
+	// Other participants join the book, below the bids and on the level of the offers
+	market.apply("XXX", []marketUpdate{
+		testLevel(enum.MDEntryType_BID, 99, 100),
+		testLevel(enum.MDEntryType_BID, 98, 5),
+		testLevel(enum.MDEntryType_OFFER, 101, 105),
+	}, true, time.Now())
+	if bid, offer := prices.Price(-1), prices.Price(1); bid != 97 || offer != 102 {
+		t.Fatalf("prices %v/%v, expected 97/102 from the orders of the others", bid, offer)
+	}
+	if reference := prices.Reference(); reference != 99.5 {
+		t.Fatalf("reference %v, expected the mid of the others 99.5", reference)
+	}
+	// Mid prices follow the whole book
+	mid := NewMarketPrice(market, "XXX", FollowMid, NewUniformPrice(100, 0))
+	mid.band = 0
+	if reference := mid.Reference(); reference != 100 {
+		t.Fatalf("mid reference %v, expected 100", reference)
+	}
+}
+
+func TestFollowBBOLeavesOutOwnQuotes(t *testing.T) {
+	market, prices := newTestMarketPrice()
+	report := quotestatusreport.New()
+	report.SetQuoteID("Q1")
+	report.SetQuoteStatus(enum.QuoteStatus_ACCEPTED)
+	report.SetSymbol("XXX")
+	report.SetBidPx(decimal.NewFromFloat(99.5), 2)
+	report.SetBidSize(decimal.NewFromInt(10), 0)
+	report.SetOfferPx(decimal.NewFromFloat(100.5), 2)
+	report.SetOfferSize(decimal.NewFromInt(10), 0)
+	market.own.recordQuoteStatusReport(report, "S1")
+	market.apply("XXX", []marketUpdate{
+		testLevel(enum.MDEntryType_BID, 99.5, 10),
+		testLevel(enum.MDEntryType_BID, 99, 10),
+		testLevel(enum.MDEntryType_OFFER, 100.5, 10),
+		testLevel(enum.MDEntryType_OFFER, 101, 10),
+	}, true, time.Now())
+	if reference := prices.Reference(); reference != 100 {
+		t.Fatalf("reference %v, expected 100 without the quote", reference)
+	}
+
+	// Once cancelled, the quote levels are the ones of other participants
+	cancel := quotestatusreport.New()
+	cancel.SetQuoteID("C1")
+	cancel.SetQuoteStatus(enum.QuoteStatus_CANCELED)
+	market.own.recordQuoteStatusReport(cancel, "S1")
+	if reference := prices.Reference(); reference != 100 {
+		t.Fatalf("reference %v, expected 100 with the levels of others", reference)
+	}
+	if bid := prices.Price(-1); bid != 98.5 {
+		t.Fatalf("bid %v, expected 98.5 from the best bid", bid)
+	}
+}
@@ -0,0 +1,69 @@
+package order
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ActionReplace = "replace"
+	ActionCancel  = "cancel"
+	ActionNew     = "new"
+)
+
+var orderActions = map[string]string{
+	ActionReplace: ActionReplace,
+	ActionCancel:  ActionCancel,
+	ActionNew:     ActionNew,
+}
+
+// defaultMaxLeftOrders is the number of orders a handler leaves on the book with
+// the new action until they are mass cancelled.
+const defaultMaxLeftOrders = 20
+
+// SetActions sets the weights of the actions taken on an acknowledged order by
+// the amend workflow from a description such as "replace=70,cancel=20,new=10:max-left=5":
+// replacing the order, cancelling it then sending a new order once the cancel is
+// acknowledged, or sending a new order and leaving the current one on the book,
+// up to max-left orders per handler (20 by default). An empty description only
+// replaces orders.
+func (m *OrderMix) SetActions(description string) error {
+	m.maxLeft = defaultMaxLeftOrders
+	if len(strings.TrimSpace(description)) == 0 {
+		m.actions = []weightedChoice[string]{{value: ActionReplace, weight: 1}}
+		return nil
+	}
+	items := strings.Split(description, ",")
+	for i, item := range items {
+		weight, params, err := parseSpec(item)
+		if err != nil {
+			return fmt.Errorf("invalid order action: %w", err)
+		}
+		if len(params.values) == 0 {
+			continue
+		}
+		if name, _, _ := strings.Cut(weight, "="); name != ActionNew {
+			return fmt.Errorf("order action %s takes no parameter", name)
+		}
+		if err := params.only("max-left"); err != nil {
+			return fmt.Errorf("invalid order action %s: %w", ActionNew, err)
+		}
+		maxLeft, err := strconv.Atoi(params.values["max-left"])
+		if err != nil || maxLeft < 0 {
+			return fmt.Errorf("order action %s: max-left must be a number of orders, got %q", ActionNew, params.values["max-left"])
+		}
+		m.maxLeft = maxLeft
+		items[i] = weight
+	}
+	actions, err := parseWeights(strings.Join(items, ","), "order action", orderActions)
+	if err != nil {
+		return err
+	}
+	m.actions = actions
+	return nil
+}
+
+func (m *OrderMix) drawAction() string {
+	return drawChoice(m.actions)
+}
@@ -7,25 +7,38 @@ import (
 	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/field"
 	"github.com/quickfixgo/fix50sp2/ordercancelreplacerequest"
+	"github.com/quickfixgo/fix50sp2/ordercancelrequest"
 	"github.com/quickfixgo/quickfix"
 )
 
 type OrderHandler struct {
 	symbol      string
-	refPrice    float64
+	instrument  *Instrument
+	prices      PriceModel
+	offset      float64
+	mix         *OrderMix
+	kind        orderKind
 	side        enum.Side
 	lastClOrdId string
 	account     string
+	parties     []Party
 	timestamp   time.Time
 	messageType string
+	// left counts the orders left on the book since the last mass cancel.
+	left int
 }
 
-func NewOrderHandler(symbol string, price float64, side enum.Side, account string) *OrderHandler {
+// NewOrderHandler creates a handler placing its orders at an offset from the reference price of the model.
+func NewOrderHandler(instrument *Instrument, prices PriceModel, offset float64, mix *OrderMix, side enum.Side, account string, parties []Party) *OrderHandler {
 	return &OrderHandler{
-		symbol:   symbol,
-		refPrice: price,
-		side:     side,
-		account:  account,
+		symbol:     instrument.Symbol,
+		instrument: instrument,
+		prices:     prices,
+		offset:     offset,
+		mix:        mix,
+		side:       side,
+		account:    account,
+		parties:    parties,
 	}
 }
 
@@ -38,19 +51,52 @@ func (o *OrderHandler) BuildAllCancelRequest(symbols []string) quickfix.Messagab
 }
 
 func (o *OrderHandler) BuildMassCancelRequest() quickfix.Messagable {
-	return BuildMassCancelRequest(o.side, o.symbol, o.account)
+	o.left = 0
+	return BuildMassCancelRequest(o.side, o.symbol, o.account, o.parties)
 }
 
+// BuildOrderRequest sends a new order, or takes the action drawn from the mix on
+// the current order. Once the handler left the maximum number of orders of the mix
+// on the book, the new action replaces the current order until the next mass cancel.
 func (o *OrderHandler) BuildOrderRequest() (quickfix.Messagable, string) {
-	if len(o.lastClOrdId) > 0 {
-		return o.buildOrderCancelReplaceRequest()
-	} else {
+	if len(o.lastClOrdId) == 0 {
 		return o.buildNewOrderSingle()
 	}
+	switch o.mix.drawAction() {
+	case ActionCancel:
+		return o.buildOrderCancelRequest()
+	case ActionNew:
+		// The current order stays on the book until it is mass cancelled
+		if o.left < o.mix.maxLeft {
+			o.left++
+			return o.buildNewOrderSingle()
+		}
+		stats.recordSubstitution()
+		return o.buildOrderCancelReplaceRequest()
+	default:
+		return o.buildOrderCancelReplaceRequest()
+	}
 }
 
+// buildNewOrderSingle draws the kind of the new order, which its replaces keep.
 func (o *OrderHandler) buildNewOrderSingle() (quickfix.Messagable, string) {
-	return buildNewOrderSingle(o.side, o.refPrice, o.symbol, o.account)
+	o.kind = o.mix.draw()
+	o.messageType = msgTypeNewOrderSingle
+	return buildNewOrderSingle(o.side, o.instrument, o.kind, o.prices, o.offset, o.account, o.parties)
+}
+
+func (o *OrderHandler) buildOrderCancelRequest() (quickfix.Messagable, string) {
+	clOrdId := uuid.New().String()
+	cancel := ordercancelrequest.New(
+		field.NewClOrdID(clOrdId),
+		field.NewSide(o.side),
+		field.NewTransactTime(time.Now()),
+	)
+	cancel.Set(field.NewOrigClOrdID(o.lastClOrdId))
+	cancel.Set(field.NewSymbol(o.symbol))
+	cancel.SetGroup(newPartiesGroup(o.account, o.parties))
+	o.messageType = msgTypeOrderCancelRequest
+	return cancel, clOrdId
 }
 
 func (o *OrderHandler) buildOrderCancelReplaceRequest() (quickfix.Messagable, string) {
@@ -59,25 +105,21 @@ func (o *OrderHandler) buildOrderCancelReplaceRequest() (quickfix.Messagable, st
 		field.NewClOrdID(clOrdId),
 		field.NewSide(o.side),
 		field.NewTransactTime(time.Now()),
-		field.NewOrdType(enum.OrdType_LIMIT),
+		field.NewOrdType(o.kind.ordType),
 	)
 	order.Set(field.NewOrigClOrdID(o.lastClOrdId))
-	order.Set(field.NewOrderQty(generateOrderQuantity(), 0))
-	order.Set(field.NewPrice(generatePrice(o.refPrice), 2))
+	setOrderQty(order.Body, o.kind, o.instrument)
 	order.Set(field.NewSymbol(o.symbol))
-	order.Set(field.NewTimeInForce(enum.TimeInForce_DAY))
-	partyIdsGroup := ordercancelreplacerequest.NewNoPartyIDsRepeatingGroup()
-	partyIds := partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID(o.account))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_CUSTOMER_ACCOUNT))
-	partyIds = partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID("ATH"))
-	partyIds.Set(field.NewPartyIDSource(enum.PartyIDSource_CHINESE_INVESTOR_ID))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_INVESTMENT_DECISION_MAKER))
-	order.SetNoPartyIDs(partyIdsGroup)
+	setOrderKind(order.Body, o.kind, o.instrument, o.prices, o.offset)
+	order.SetGroup(newPartiesGroup(o.account, o.parties))
+	o.messageType = msgTypeOrderCancelReplaceRequest
 	return order, clOrdId
 }
 
+func (o *OrderHandler) Rests() bool {
+	return o.kind.rests()
+}
+
 func (o *OrderHandler) GetSymbol() string {
 	return o.symbol
 }
@@ -103,11 +145,6 @@ func (o *OrderHandler) GetMessageType() string {
 }
 
 func (o *OrderHandler) UpdateClientOrderId(newId string) {
-	if len(o.lastClOrdId) > 0 {
-		o.messageType = "OrderCancelReplaceRequest"
-	} else {
-		o.messageType = "NewOrderSingle"
-	}
 	o.timestamp = time.Now()
 	o.lastClOrdId = newId
 }
@@ -0,0 +1,77 @@
+package order
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+)
+
+func TestOrderHandlerLeftOrders(t *testing.T) {
+	resetRun()
+	mix := DefaultOrderMix()
+	if err := mix.SetActions("new:max-left=5"); err != nil {
+		t.Fatalf("SetActions: %v", err)
+	}
+	handler := NewOrderHandler(DefaultInstrument("XXX"), NewUniformPrice(100, 0), -1, mix, enum.Side_BUY, "A1", nil)
+
+	// request builds the next request and acknowledges it
+	request := func() string {
+		_, clOrdId := handler.BuildOrderRequest()
+		handler.UpdateClientOrderId(clOrdId)
+		return handler.GetMessageType()
+	}
+	if messageType := request(); messageType != msgTypeNewOrderSingle {
+		t.Fatalf("first request is a %s", messageType)
+	}
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 5; i++ {
+			if messageType := request(); messageType != msgTypeNewOrderSingle {
+				t.Fatalf("round %d: %s sent after %d orders left", round, messageType, i)
+			}
+		}
+		if messageType := request(); messageType != msgTypeOrderCancelReplaceRequest {
+			t.Fatalf("round %d: %s sent after 5 orders left, expected a replace", round, messageType)
+		}
+		// The mass cancel removes the orders left on the book
+		handler.BuildMassCancelRequest()
+	}
+	if report := BuildReport(); report.Substitutions != 2 {
+		t.Fatalf("%d new orders sent as replaces, expected 2", report.Substitutions)
+	}
+}
+
+func TestSetActions(t *testing.T) {
+	tests := []struct {
+		description string
+		err         string
+		maxLeft     int
+	}{
+		{description: "", maxLeft: 20},
+		{description: "replace=70,cancel=20,new=10", maxLeft: 20},
+		{description: "replace=70,new=10:max-left=50", maxLeft: 50},
+		{description: "new:max-left=0", maxLeft: 0},
+		{description: "replace:max-left=5", err: "order action replace takes no parameter"},
+		{description: "new=10:max=5", err: `unknown parameter "max"`},
+		{description: "new=10:max-left=-1", err: "max-left must be a number of orders"},
+		{description: "new=10:max-left", err: `invalid parameter "max-left"`},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			mix := DefaultOrderMix()
+			err := mix.SetActions(test.description)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mix.maxLeft != test.maxLeft {
+				t.Fatalf("max-left %d, expected %d", mix.maxLeft, test.maxLeft)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultVisibleLots = 10
+	defaultHiddenLots  = 90
+	defaultMinQtyRatio = 0.5
+)
+
+// Fields of iceberg and minimum quantity orders, which are not part of the
+// generated messages. DisplayQty belongs to the DisplayInstruction component.
+// The simulator reads them as well.
+const (
+	TagMinQty     quickfix.Tag = 110
+	TagDisplayQty quickfix.Tag = 1138
+)
+
+// Iceberg sends a share of the resting limit orders with only part of their
+// quantity displayed, each order showing its visible lots and hiding the others.
+type Iceberg struct {
+	share       float64
+	visibleLots int64
+	hiddenLots  int64
+}
+
+// ParseIceberg builds icebergs from a description such as "20:visible=10,hidden=90",
+// i.e. 20% of the orders showing 10 lots and hiding 90 lots.
+func ParseIceberg(description string) (*Iceberg, error) {
+	rawShare, params, err := parseSpec(description)
+	if err != nil {
+		return nil, fmt.Errorf("invalid iceberg: %w", err)
+	}
+	share, err := parseShare(rawShare)
+	if err != nil {
+		return nil, fmt.Errorf("invalid iceberg: %w", err)
+	}
+	iceberg := &Iceberg{
+		share:       share,
+		visibleLots: params.lots("visible", defaultVisibleLots),
+		hiddenLots:  params.lots("hidden", defaultHiddenLots),
+	}
+	if err := params.only("visible", "hidden"); err != nil {
+		return nil, fmt.Errorf("invalid iceberg: %w", err)
+	}
+	if params.err != nil {
+		return nil, fmt.Errorf("invalid iceberg: %w", params.err)
+	}
+	if iceberg.visibleLots < 1 || iceberg.hiddenLots < 1 {
+		return nil, errors.New("iceberg needs at least one visible and one hidden lot")
+	}
+	return iceberg, nil
+}
+
+// fits checks that the quantity range of an instrument leaves room for the
+// visible lots of the iceberg and at least one hidden lot.
+func (i *Iceberg) fits(instrument *Instrument) error {
+	if i.visibleLots >= instrument.maxLots() {
+		return fmt.Errorf("iceberg showing %d lots doesn't fit in the maximum quantity %s of %s", i.visibleLots, instrument.MaxQty, instrument.Symbol)
+	}
+	return nil
+}
+
+// totalLots returns the visible and hidden lots of the iceberg within the
+// quantity range of an instrument.
+func (i *Iceberg) totalLots(instrument *Instrument) int64 {
+	lots := i.visibleLots + i.hiddenLots
+	if lots < instrument.minLots() {
+		lots = instrument.minLots()
+	}
+	if lots > instrument.maxLots() {
+		lots = instrument.maxLots()
+	}
+	return lots
+}
+
+// MinQty sends a share of the orders with a minimum quantity to execute, given
+// as a ratio of their quantity.
+type MinQty struct {
+	share float64
+	ratio float64
+}
+
+// ParseMinQty builds minimum quantities from a description such as "10:ratio=0.5",
+// i.e. 10% of the orders only executing if half of their quantity can be.
+func ParseMinQty(description string) (*MinQty, error) {
+	rawShare, params, err := parseSpec(description)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum quantity: %w", err)
+	}
+	share, err := parseShare(rawShare)
+	if err != nil {
+		return nil, fmt.Errorf("invalid minimum quantity: %w", err)
+	}
+	minQty := &MinQty{share: share, ratio: defaultMinQtyRatio}
+	if _, found := params.values["ratio"]; found {
+		minQty.ratio = params.float("ratio")
+	}
+	if err := params.only("ratio"); err != nil {
+		return nil, fmt.Errorf("invalid minimum quantity: %w", err)
+	}
+	if params.err != nil {
+		return nil, fmt.Errorf("invalid minimum quantity: %w", params.err)
+	}
+	if minQty.ratio <= 0 || minQty.ratio > 1 {
+		return nil, errors.New("minimum quantity ratio must be greater than 0 and at most 1")
+	}
+	return minQty, nil
+}
+
+// parseShare reads a percentage of the flow such as "20" or "20%".
+func parseShare(value string) (float64, error) {
+	share, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil || share < 0 || share > 100 {
+		return 0, fmt.Errorf("share of the orders must be a percentage, got %q", value)
+	}
+	return share, nil
+}
+
+func drawShare(share float64) bool {
+	return share > 0 && rand.Float64()*100 < share
+}
+
+func (p *specParams) lots(key string, defaultValue int64) int64 {
+	value, found := p.values[key]
+	if !found {
+		return defaultValue
+	}
+	lots, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		p.setError(fmt.Errorf("%s: invalid number of lots %q", key, value))
+	}
+	return lots
+}
+
+// only fails on the parameters which are not among the given keys.
+func (p *specParams) only(keys ...string) error {
+	for key := range p.values {
+		known := false
+		for _, k := range keys {
+			known = known || key == k
+		}
+		if !known {
+			return fmt.Errorf("unknown parameter %q", key)
+		}
+	}
+	return nil
+}
+
+// setOrderQty sets the quantity of an order. Icebergs are made of their visible
+// and hidden lots, kept within the quantity range of the instrument, other orders
+// drawing their quantity from the instrument. The minimum quantity is rounded up
+// to a whole lot.
+func setOrderQty(body *quickfix.Body, kind orderKind, instrument *Instrument) {
+	qty := instrument.GenerateQuantity()
+	if kind.iceberg != nil {
+		visible := instrument.LotSize.Mul(decimal.NewFromInt(kind.iceberg.visibleLots))
+		qty = instrument.LotSize.Mul(decimal.NewFromInt(kind.iceberg.totalLots(instrument)))
+		body.SetField(TagDisplayQty, quickfix.FIXDecimal{Decimal: visible, Scale: instrument.QtyPrecision})
+	}
+	body.Set(field.NewOrderQty(qty, instrument.QtyPrecision))
+	if kind.minQty != nil {
+		lots := qty.Div(instrument.LotSize).Mul(decimal.NewFromFloat(kind.minQty.ratio)).Ceil()
+		body.SetField(TagMinQty, quickfix.FIXDecimal{Decimal: lots.Mul(instrument.LotSize), Scale: instrument.QtyPrecision})
+	}
+}
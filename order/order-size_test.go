@@ -0,0 +1,69 @@
+package order
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+)
+
+func TestIcebergQuantity(t *testing.T) {
+	tests := []struct {
+		iceberg    string
+		instrument string
+		err        string
+		// expected order and displayed quantities
+		qty, display string
+	}{
+		{iceberg: "100:visible=10,hidden=90", instrument: "XXX:min-qty=90,max-qty=109", qty: "100", display: "10"},
+		{iceberg: "100:visible=10,hidden=90", instrument: "XXX:min-qty=10,max-qty=50", qty: "50", display: "10"},
+		{iceberg: "100:visible=2,hidden=3", instrument: "XXX:min-qty=20,max-qty=40", qty: "20", display: "2"},
+		{iceberg: "100:visible=1,hidden=1", instrument: "XXX:lot=100,min-qty=100,max-qty=300", qty: "200", display: "100"},
+		{iceberg: "100:visible=10,hidden=90", instrument: "XXX:min-qty=5,max-qty=10", err: "iceberg showing 10 lots doesn't fit"},
+		{iceberg: "100:visible=20,hidden=90", instrument: "XXX:min-qty=5,max-qty=15", err: "iceberg showing 20 lots doesn't fit"},
+	}
+	for _, test := range tests {
+		t.Run(test.iceberg+" "+test.instrument, func(t *testing.T) {
+			instrument, err := ParseInstrument(test.instrument)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mix, err := ParseOrderMix("limit", "day", DefaultExpireAfter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := mix.SetIceberg(test.iceberg); err != nil {
+				t.Fatal(err)
+			}
+			err = mix.CheckInstruments(map[string]*Instrument{instrument.Symbol: instrument})
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			body := quickfix.Body{}
+			body.Init()
+			setOrderQty(&body, orderKind{iceberg: mix.iceberg}, instrument)
+			var qty field.OrderQtyField
+			if err := body.Get(&qty); err != nil {
+				t.Fatal(err)
+			}
+			if qty.String() != test.qty {
+				t.Errorf("expected quantity %s, got %s", test.qty, qty.String())
+			}
+			display, err := body.GetString(TagDisplayQty)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if display != test.display {
+				t.Errorf("expected displayed quantity %s, got %s", test.display, display)
+			}
+		})
+	}
+}
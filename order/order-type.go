@@ -0,0 +1,268 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+)
+
+const (
+	OrderLimit     = "limit"
+	OrderMarket    = "market"
+	OrderStop      = "stop"
+	OrderStopLimit = "stop-limit"
+	OrderPegged    = "pegged"
+
+	TimeInForceDay = "day"
+	TimeInForceIOC = "ioc"
+	TimeInForceFOK = "fok"
+	TimeInForceGTC = "gtc"
+	TimeInForceGTD = "gtd"
+	TimeInForceGFA = "gfa"
+
+	DefaultExpireAfter = time.Hour
+)
+
+// Fields of stop, good till date and pegged orders, which are not part of the
+// generated messages. The simulator reads them as well.
+const (
+	TagStopPx         quickfix.Tag = 99
+	TagExpireTime     quickfix.Tag = 126
+	TagPegOffsetValue quickfix.Tag = 211
+	TagPegOffsetType  quickfix.Tag = 836
+	TagPegPriceType   quickfix.Tag = 1094
+
+	pegPriceTypeMid    = "2"
+	pegOffsetTypePrice = "0"
+)
+
+var orderTypes = map[string]enum.OrdType{
+	OrderLimit:     enum.OrdType_LIMIT,
+	OrderMarket:    enum.OrdType_MARKET,
+	OrderStop:      enum.OrdType_STOP_STOP_LOSS,
+	OrderStopLimit: enum.OrdType_STOP_LIMIT,
+	OrderPegged:    enum.OrdType_PEGGED,
+}
+
+var timesInForce = map[string]enum.TimeInForce{
+	TimeInForceDay: enum.TimeInForce_DAY,
+	TimeInForceIOC: enum.TimeInForce_IMMEDIATE_OR_CANCEL,
+	TimeInForceFOK: enum.TimeInForce_FILL_OR_KILL,
+	TimeInForceGTC: enum.TimeInForce_GOOD_TILL_CANCEL,
+	TimeInForceGTD: enum.TimeInForce_GOOD_TILL_DATE,
+	TimeInForceGFA: enum.TimeInForce_GOOD_FOR_AUCTION,
+}
+
+// orderKind is the type, time in force and size instructions of an order, which
+// its replaces keep.
+type orderKind struct {
+	ordType     enum.OrdType
+	timeInForce enum.TimeInForce
+	expireTime  time.Time
+	iceberg     *Iceberg
+	minQty      *MinQty
+}
+
+// rests tells whether the order stays on the venue once acknowledged. Market,
+// immediate or cancel and fill or kill orders are over after their executions.
+func (k orderKind) rests() bool {
+	return k.ordType != enum.OrdType_MARKET &&
+		k.timeInForce != enum.TimeInForce_IMMEDIATE_OR_CANCEL &&
+		k.timeInForce != enum.TimeInForce_FILL_OR_KILL
+}
+
+type weightedChoice[T any] struct {
+	value  T
+	weight float64
+}
+
+func drawChoice[T any](choices []weightedChoice[T]) T {
+	total := 0.0
+	for _, choice := range choices {
+		total += choice.weight
+	}
+	draw := rand.Float64() * total
+	for _, choice := range choices {
+		if draw < choice.weight {
+			return choice.value
+		}
+		draw -= choice.weight
+	}
+	return choices[len(choices)-1].value
+}
+
+// OrderMix draws the type and time in force of each new order according to
+// their weights, good till date orders expiring after a fixed duration. Shares
+// of the orders are sent as icebergs or with a minimum quantity, and acknowledged
+// orders are replaced, cancelled or left on the book according to the weights of
+// the actions, up to maxLeft orders left per handler.
+type OrderMix struct {
+	types        []weightedChoice[enum.OrdType]
+	timesInForce []weightedChoice[enum.TimeInForce]
+	expireAfter  time.Duration
+	iceberg      *Iceberg
+	minQty       *MinQty
+	actions      []weightedChoice[string]
+	maxLeft      int
+}
+
+// DefaultOrderMix only sends day limit orders, which are replaced once acknowledged.
+func DefaultOrderMix() *OrderMix {
+	return &OrderMix{
+		types:        []weightedChoice[enum.OrdType]{{value: enum.OrdType_LIMIT, weight: 1}},
+		timesInForce: []weightedChoice[enum.TimeInForce]{{value: enum.TimeInForce_DAY, weight: 1}},
+		expireAfter:  DefaultExpireAfter,
+		actions:      []weightedChoice[string]{{value: ActionReplace, weight: 1}},
+		maxLeft:      defaultMaxLeftOrders,
+	}
+}
+
+// ParseOrderMix builds a mix from descriptions such as "limit=80,market=10,stop=10"
+// and "day=90,ioc=10", a name without weight having a weight of 1. Empty
+// descriptions send limit orders and day orders.
+func ParseOrderMix(types string, tifs string, expireAfter time.Duration) (*OrderMix, error) {
+	mix := DefaultOrderMix()
+	var err error
+	if len(strings.TrimSpace(types)) > 0 {
+		if mix.types, err = parseWeights(types, "order type", orderTypes); err != nil {
+			return nil, err
+		}
+	}
+	if len(strings.TrimSpace(tifs)) > 0 {
+		if mix.timesInForce, err = parseWeights(tifs, "time in force", timesInForce); err != nil {
+			return nil, err
+		}
+	}
+	if expireAfter <= 0 {
+		return nil, errors.New("expiry of good till date orders must be positive")
+	}
+	mix.expireAfter = expireAfter
+	return mix, nil
+}
+
+// SetIceberg sends icebergs as described by ParseIceberg, an empty description
+// sending none.
+func (m *OrderMix) SetIceberg(description string) error {
+	if len(strings.TrimSpace(description)) == 0 {
+		m.iceberg = nil
+		return nil
+	}
+	iceberg, err := ParseIceberg(description)
+	if err != nil {
+		return err
+	}
+	m.iceberg = iceberg
+	return nil
+}
+
+// CheckInstruments fails when the icebergs of the mix can't be sent within the
+// quantity range of one of the instruments.
+func (m *OrderMix) CheckInstruments(instruments map[string]*Instrument) error {
+	if m.iceberg == nil {
+		return nil
+	}
+	for _, instrument := range instruments {
+		if err := m.iceberg.fits(instrument); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetMinQty sends minimum quantities as described by ParseMinQty, an empty
+// description sending none.
+func (m *OrderMix) SetMinQty(description string) error {
+	if len(strings.TrimSpace(description)) == 0 {
+		m.minQty = nil
+		return nil
+	}
+	minQty, err := ParseMinQty(description)
+	if err != nil {
+		return err
+	}
+	m.minQty = minQty
+	return nil
+}
+
+func parseWeights[T any](description string, kind string, values map[string]T) ([]weightedChoice[T], error) {
+	choices := make([]weightedChoice[T], 0)
+	for _, item := range strings.Split(description, ",") {
+		name, rawWeight, found := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		value, known := values[name]
+		if !known {
+			return nil, fmt.Errorf("unknown %s %q", kind, name)
+		}
+		weight := 1.0
+		if found {
+			var err error
+			weight, err = strconv.ParseFloat(strings.TrimSpace(rawWeight), 64)
+			if err != nil || weight < 0 {
+				return nil, fmt.Errorf("%s %s: invalid weight %q", kind, name, rawWeight)
+			}
+		}
+		choices = append(choices, weightedChoice[T]{value: value, weight: weight})
+	}
+	total := 0.0
+	for _, choice := range choices {
+		total += choice.weight
+	}
+	if total <= 0 {
+		return nil, fmt.Errorf("%s weights must not all be zero", kind)
+	}
+	return choices, nil
+}
+
+func (m *OrderMix) draw() orderKind {
+	kind := orderKind{
+		ordType:     drawChoice(m.types),
+		timeInForce: drawChoice(m.timesInForce),
+	}
+	if kind.timeInForce == enum.TimeInForce_GOOD_TILL_DATE {
+		kind.expireTime = time.Now().Add(m.expireAfter)
+	}
+	// Only limit orders resting on the book have a hidden quantity to refill
+	if m.iceberg != nil && kind.ordType == enum.OrdType_LIMIT && kind.rests() && drawShare(m.iceberg.share) {
+		kind.iceberg = m.iceberg
+	}
+	if m.minQty != nil && drawShare(m.minQty.share) {
+		kind.minQty = m.minQty
+	}
+	return kind
+}
+
+// setOrderKind sets the time in force and prices of an order placed at an
+// offset from the reference price. Stops are placed on the other side of the
+// reference price so they don't trigger right away, stop limits being limited
+// at their stop price. Pegged orders follow the mid price at the offset, capped
+// at the price they would have as limit orders.
+func setOrderKind(body *quickfix.Body, kind orderKind, instrument *Instrument, prices PriceModel, offset float64) {
+	body.Set(field.NewTimeInForce(kind.timeInForce))
+	if kind.timeInForce == enum.TimeInForce_GOOD_TILL_DATE {
+		body.SetField(TagExpireTime, quickfix.FIXUTCTimestamp{Time: kind.expireTime})
+	}
+
+	switch kind.ordType {
+	case enum.OrdType_MARKET:
+	case enum.OrdType_STOP_STOP_LOSS, enum.OrdType_STOP_LIMIT:
+		stopPx := generatePrice(prices, instrument, -offset)
+		body.SetField(TagStopPx, quickfix.FIXDecimal{Decimal: stopPx, Scale: instrument.PricePrecision})
+		if kind.ordType == enum.OrdType_STOP_LIMIT {
+			body.Set(field.NewPrice(stopPx, instrument.PricePrecision))
+		}
+	case enum.OrdType_PEGGED:
+		body.Set(field.NewPrice(generatePrice(prices, instrument, offset), instrument.PricePrecision))
+		body.SetField(TagPegPriceType, quickfix.FIXString(pegPriceTypeMid))
+		body.SetField(TagPegOffsetType, quickfix.FIXString(pegOffsetTypePrice))
+		body.SetField(TagPegOffsetValue, quickfix.FIXDecimal{Decimal: instrument.RoundOffset(offset), Scale: instrument.PricePrecision})
+	default:
+		body.Set(field.NewPrice(generatePrice(prices, instrument, offset), instrument.PricePrecision))
+	}
+}
@@ -0,0 +1,155 @@
+package order
+
+import (
+	"sync"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/fix50sp2/quotestatusreport"
+	"github.com/quickfixgo/quickfix"
+	"github.com/shopspring/decimal"
+)
+
+// ownOrders holds the quantity the orders and quotes of the gatling show on each
+// price level of the book, as acknowledged by the venue, so that the prices can
+// follow the best bid and offer of the other participants.
+type ownOrders struct {
+	// orders holds the shown quantity of the resting orders, by ClOrdID.
+	orders map[string]ownOrder
+	// quotes holds the sides of the last accepted quote, by session and symbol.
+	quotes map[ownQuoteKey][]ownOrder
+	levels map[feedKey]decimal.Decimal
+	lock   sync.Mutex
+}
+
+type ownOrder struct {
+	level feedKey
+	qty   decimal.Decimal
+}
+
+type ownQuoteKey struct {
+	session string
+	symbol  string
+}
+
+func newOwnOrders() *ownOrders {
+	return &ownOrders{
+		orders: make(map[string]ownOrder),
+		quotes: make(map[ownQuoteKey][]ownOrder),
+		levels: make(map[feedKey]decimal.Decimal),
+	}
+}
+
+// recordExecutionReport follows the orders resting on the book. Replaced and
+// cancelled orders leave their level along with the request closing them, and
+// icebergs only show their DisplayQty.
+func (o *ownOrders) recordExecutionReport(execReport executionreport.ExecutionReport) {
+	clOrdId, err := execReport.GetClOrdID()
+	if err != nil {
+		return
+	}
+	execType, _ := execReport.GetExecType()
+	origClOrdId, _ := execReport.GetOrigClOrdID()
+	status, _ := execReport.GetOrdStatus()
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	if len(origClOrdId) > 0 && (execType == enum.ExecType_REPLACED || execType == enum.ExecType_CANCELED) {
+		o.remove(origClOrdId)
+	}
+	o.remove(clOrdId)
+	switch status {
+	case enum.OrdStatus_NEW, enum.OrdStatus_PARTIALLY_FILLED, enum.OrdStatus_REPLACED,
+		enum.OrdStatus_PENDING_REPLACE, enum.OrdStatus_PENDING_CANCEL:
+	default:
+		return
+	}
+	symbol, _ := execReport.GetSymbol()
+	side, _ := execReport.GetSide()
+	price, err := execReport.GetPrice()
+	if err != nil {
+		// Orders without a price, e.g. market orders, don't rest on a level
+		return
+	}
+	qty, _ := execReport.GetLeavesQty()
+	var displayQty quickfix.FIXDecimal
+	if err := execReport.Body.GetField(TagDisplayQty, &displayQty); err == nil && displayQty.Decimal.LessThan(qty) {
+		qty = displayQty.Decimal
+	}
+	if !qty.IsPositive() {
+		return
+	}
+	order := ownOrder{level: feedKey{symbol: symbol, side: side, price: price.String()}, qty: qty}
+	o.orders[clOrdId] = order
+	o.add(order)
+}
+
+// recordQuoteStatusReport follows the quotes of a session, each accepted quote
+// replacing the previous one of its symbol and a cancel removing the quotes of
+// its symbol, or all of them.
+func (o *ownOrders) recordQuoteStatusReport(report quotestatusreport.QuoteStatusReport, session string) {
+	status, err := report.GetQuoteStatus()
+	if err != nil {
+		return
+	}
+	symbol, _ := report.GetSymbol()
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	for key, sides := range o.quotes {
+		if key.session == session && (key.symbol == symbol || (status == enum.QuoteStatus_CANCELED && len(symbol) == 0)) {
+			for _, side := range sides {
+				o.subtract(side)
+			}
+			delete(o.quotes, key)
+		}
+	}
+	if status != enum.QuoteStatus_ACCEPTED {
+		return
+	}
+	sides := make([]ownOrder, 0, 2)
+	bidPx, bidPxErr := report.GetBidPx()
+	bidSize, bidSizeErr := report.GetBidSize()
+	if bidPxErr == nil && bidSizeErr == nil && bidSize.IsPositive() {
+		sides = append(sides, ownOrder{level: feedKey{symbol: symbol, side: enum.Side_BUY, price: bidPx.String()}, qty: bidSize})
+	}
+	offerPx, offerPxErr := report.GetOfferPx()
+	offerSize, offerSizeErr := report.GetOfferSize()
+	if offerPxErr == nil && offerSizeErr == nil && offerSize.IsPositive() {
+		sides = append(sides, ownOrder{level: feedKey{symbol: symbol, side: enum.Side_SELL, price: offerPx.String()}, qty: offerSize})
+	}
+	for _, side := range sides {
+		o.add(side)
+	}
+	o.quotes[ownQuoteKey{session: session, symbol: symbol}] = sides
+}
+
+// shown returns the quantity of the gatling on a price level.
+func (o *ownOrders) shown(level feedKey) decimal.Decimal {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.levels[level]
+}
+
+// Must be called with the lock held.
+func (o *ownOrders) remove(clOrdId string) {
+	if order, found := o.orders[clOrdId]; found {
+		o.subtract(order)
+		delete(o.orders, clOrdId)
+	}
+}
+
+// Must be called with the lock held.
+func (o *ownOrders) add(order ownOrder) {
+	o.levels[order.level] = o.levels[order.level].Add(order.qty)
+}
+
+// Must be called with the lock held.
+func (o *ownOrders) subtract(order ownOrder) {
+	qty := o.levels[order.level].Sub(order.qty)
+	if !qty.IsPositive() {
+		delete(o.levels, order.level)
+		return
+	}
+	o.levels[order.level] = qty
+}
@@ -0,0 +1,181 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+)
+
+// PartyNone removes the default party, only the customer account being sent.
+const PartyNone = "none"
+
+// defaultParty is sent along the customer account of accounts without configured party.
+var defaultParty = Party{
+	ID:     "ATH",
+	Source: enum.PartyIDSource_CHINESE_INVESTOR_ID,
+	Role:   enum.PartyRole_INVESTMENT_DECISION_MAKER,
+}
+
+// Party is an entry of the Parties block sent after the customer account, e.g. an
+// investment or execution decision maker. Source, role and qualifier are FIX codes.
+type Party struct {
+	ID        string
+	Source    enum.PartyIDSource
+	Role      enum.PartyRole
+	Qualifier enum.PartyRoleQualifier
+	SubIDs    []PartySubID
+}
+
+type PartySubID struct {
+	ID   string
+	Type enum.PartySubIDType
+}
+
+// ParseParty builds a party from a description such as
+// "12345:source=P,role=12,qualifier=24,sub=DESK1/4+EU/27", sub IDs being given
+// as <id>/<type> and separated by "+".
+func ParseParty(description string) (Party, error) {
+	id, params, err := parseSpec(description)
+	if err != nil {
+		return Party{}, fmt.Errorf("invalid party: %w", err)
+	}
+	if len(id) == 0 {
+		return Party{}, errors.New("party needs an ID")
+	}
+	party := Party{
+		ID:        id,
+		Source:    enum.PartyIDSource(params.values["source"]),
+		Role:      enum.PartyRole(params.values["role"]),
+		Qualifier: enum.PartyRoleQualifier(params.values["qualifier"]),
+	}
+	if len(party.Role) == 0 {
+		return Party{}, fmt.Errorf("party %s needs a role", id)
+	}
+	if subIds, found := params.values["sub"]; found {
+		for _, subId := range strings.Split(subIds, "+") {
+			value, typ, found := strings.Cut(subId, "/")
+			if !found || len(value) == 0 || len(typ) == 0 {
+				return Party{}, fmt.Errorf("party %s: invalid sub ID %q, must be <id>/<type>", id, subId)
+			}
+			party.SubIDs = append(party.SubIDs, PartySubID{ID: value, Type: enum.PartySubIDType(typ)})
+		}
+	}
+	for key := range params.values {
+		switch key {
+		case "source", "role", "qualifier", "sub":
+		default:
+			return Party{}, fmt.Errorf("party %s: unknown parameter %q", id, key)
+		}
+	}
+	return party, nil
+}
+
+// NewParties builds the parties of each account. Descriptions are either "<party>"
+// for every account or "<account>=<party>" for one of them, several descriptions
+// adding several parties. Accounts without party send the default one, unless the
+// description is "none".
+func NewParties(accounts []string, descriptions []string) (map[string][]Party, error) {
+	common := make([]Party, 0)
+	byAccount := make(map[string][]Party)
+	none := false
+	for _, description := range descriptions {
+		if strings.TrimSpace(description) == PartyNone {
+			none = true
+			continue
+		}
+		account, partyDescription, found := strings.Cut(description, "=")
+		if !found || strings.Contains(account, ":") {
+			party, err := ParseParty(description)
+			if err != nil {
+				return nil, err
+			}
+			common = append(common, party)
+			continue
+		}
+		party, err := ParseParty(partyDescription)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", account, err)
+		}
+		account = strings.TrimSpace(account)
+		byAccount[account] = append(byAccount[account], party)
+	}
+
+	parties := make(map[string][]Party, len(accounts))
+	for _, account := range accounts {
+		accountParties := append(append([]Party{}, common...), byAccount[account]...)
+		if len(accountParties) == 0 && !none {
+			accountParties = []Party{defaultParty}
+		}
+		parties[account] = accountParties
+	}
+	for account := range byAccount {
+		if _, found := parties[account]; !found {
+			return nil, fmt.Errorf("party given for unknown account %s", account)
+		}
+	}
+	return parties, nil
+}
+
+// newPartiesGroup returns the Parties block of a request: the customer account
+// followed by the parties of the account. Every request sending parties shares
+// this block layout.
+func newPartiesGroup(account string, parties []Party) *quickfix.RepeatingGroup {
+	group := quickfix.NewRepeatingGroup(tag.NoPartyIDs, quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.PartyID),
+		quickfix.GroupElement(tag.PartyIDSource),
+		quickfix.GroupElement(tag.PartyRole),
+		quickfix.NewRepeatingGroup(tag.NoPartySubIDs, quickfix.GroupTemplate{
+			quickfix.GroupElement(tag.PartySubID),
+			quickfix.GroupElement(tag.PartySubIDType),
+		}),
+		quickfix.GroupElement(tag.PartyRoleQualifier),
+	})
+	entry := group.Add()
+	entry.Set(field.NewPartyID(account))
+	entry.Set(field.NewPartyRole(enum.PartyRole_CUSTOMER_ACCOUNT))
+	for _, party := range parties {
+		entry = group.Add()
+		entry.Set(field.NewPartyID(party.ID))
+		if len(party.Source) > 0 {
+			entry.Set(field.NewPartyIDSource(party.Source))
+		}
+		entry.Set(field.NewPartyRole(party.Role))
+		if len(party.SubIDs) > 0 {
+			subIds := quickfix.NewRepeatingGroup(tag.NoPartySubIDs, quickfix.GroupTemplate{
+				quickfix.GroupElement(tag.PartySubID),
+				quickfix.GroupElement(tag.PartySubIDType),
+			})
+			for _, subId := range party.SubIDs {
+				subEntry := subIds.Add()
+				subEntry.Set(field.NewPartySubID(subId.ID))
+				subEntry.Set(field.NewPartySubIDType(subId.Type))
+			}
+			entry.SetGroup(subIds)
+		}
+		if len(party.Qualifier) > 0 {
+			entry.Set(field.NewPartyRoleQualifier(party.Qualifier))
+		}
+	}
+	return group
+}
+
+// customerAccount returns the customer account of a Parties block, empty when
+// the block has none.
+func customerAccount(parties *quickfix.RepeatingGroup) string {
+	for i := 0; i < parties.Len(); i++ {
+		party := parties.Get(i)
+		role, err := party.GetString(tag.PartyRole)
+		if err != nil || enum.PartyRole(role) != enum.PartyRole_CUSTOMER_ACCOUNT {
+			continue
+		}
+		if account, err := party.GetString(tag.PartyID); err == nil {
+			return account
+		}
+	}
+	return ""
+}
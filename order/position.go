@@ -0,0 +1,242 @@
+package order
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/shopspring/decimal"
+)
+
+var (
+	metricFills = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "order_gatling",
+			Name:      "fills_total",
+			Help:      "Number of fills received in execution reports",
+		},
+		[]string{"symbol", "session"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricFills)
+}
+
+// positions accumulates the fills of the execution reports received by the gatling.
+var positions = newPositionBook()
+
+// positionKey is the position of an account in a symbol.
+type positionKey struct {
+	account string
+	symbol  string
+}
+
+// position sums the fills of an account in a symbol.
+type position struct {
+	fills          uint64
+	boughtQty      decimal.Decimal
+	soldQty        decimal.Decimal
+	boughtNotional decimal.Decimal
+	soldNotional   decimal.Decimal
+}
+
+func (p *position) add(side enum.Side, qty decimal.Decimal, price decimal.Decimal) {
+	p.fills++
+	if side == enum.Side_BUY {
+		p.boughtQty = p.boughtQty.Add(qty)
+		p.boughtNotional = p.boughtNotional.Add(qty.Mul(price))
+	} else {
+		p.soldQty = p.soldQty.Add(qty)
+		p.soldNotional = p.soldNotional.Add(qty.Mul(price))
+	}
+}
+
+// positionBook holds the positions of every account and symbol, built either from
+// the execution reports of the gatling or from the trades reported by the venue.
+type positionBook struct {
+	positions map[positionKey]*position
+	lock      sync.Mutex
+}
+
+func newPositionBook() *positionBook {
+	return &positionBook{positions: make(map[positionKey]*position)}
+}
+
+func (b *positionBook) add(account string, symbol string, side enum.Side, qty decimal.Decimal, price decimal.Decimal) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	key := positionKey{account: account, symbol: symbol}
+	p, found := b.positions[key]
+	if !found {
+		p = &position{}
+		b.positions[key] = p
+	}
+	p.add(side, qty, price)
+}
+
+// get returns a copy of the position of an account in a symbol.
+func (b *positionBook) get(account string, symbol string) position {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if p, found := b.positions[positionKey{account: account, symbol: symbol}]; found {
+		return *p
+	}
+	return position{}
+}
+
+// recordFill adds the fill of a trade execution report to the position of its
+// account, read from the Account field or the customer account party.
+func (b *positionBook) recordFill(execReport executionreport.ExecutionReport, session string) {
+	execType, err := execReport.GetExecType()
+	if err != nil || execType != enum.ExecType_TRADE {
+		return
+	}
+	symbol, _ := execReport.GetSymbol()
+	side, err := execReport.GetSide()
+	if err != nil {
+		return
+	}
+	qty, err := execReport.GetLastQty()
+	if err != nil {
+		return
+	}
+	price, err := execReport.GetLastPx()
+	if err != nil {
+		return
+	}
+	account, err := execReport.GetAccount()
+	if err != nil {
+		if parties, err := execReport.GetNoPartyIDs(); err == nil {
+			account = customerAccount(parties.RepeatingGroup)
+		}
+	}
+	b.add(account, symbol, side, qty, price)
+	metricFills.WithLabelValues(symbol, session).Inc()
+}
+
+// PositionReport gives the fills of an account in a symbol. The VWAP of a side is
+// its traded notional divided by its traded quantity.
+type PositionReport struct {
+	Account   string          `json:"account"`
+	Symbol    string          `json:"symbol"`
+	Fills     uint64          `json:"fills"`
+	BoughtQty decimal.Decimal `json:"bought_qty"`
+	SoldQty   decimal.Decimal `json:"sold_qty"`
+	NetQty    decimal.Decimal `json:"net_qty"`
+	Notional  decimal.Decimal `json:"notional"`
+	BuyVWAP   decimal.Decimal `json:"buy_vwap"`
+	SellVWAP  decimal.Decimal `json:"sell_vwap"`
+}
+
+// vwapPrecision is the number of decimals of the VWAP in reports.
+const vwapPrecision = 6
+
+// snapshot returns a copy of the positions.
+func (b *positionBook) snapshot() map[positionKey]position {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	positions := make(map[positionKey]position, len(b.positions))
+	for key, p := range b.positions {
+		positions[key] = *p
+	}
+	return positions
+}
+
+// report returns the positions sorted by account and symbol.
+func (b *positionBook) report() []PositionReport {
+	snapshot := b.snapshot()
+	reports := make([]PositionReport, 0, len(snapshot))
+	for _, key := range sortedPositionKeys(snapshot) {
+		p := snapshot[key]
+		report := PositionReport{
+			Account:   key.account,
+			Symbol:    key.symbol,
+			Fills:     p.fills,
+			BoughtQty: p.boughtQty,
+			SoldQty:   p.soldQty,
+			NetQty:    p.boughtQty.Sub(p.soldQty),
+			Notional:  p.boughtNotional.Add(p.soldNotional),
+		}
+		if p.boughtQty.IsPositive() {
+			report.BuyVWAP = p.boughtNotional.DivRound(p.boughtQty, vwapPrecision)
+		}
+		if p.soldQty.IsPositive() {
+			report.SellVWAP = p.soldNotional.DivRound(p.soldQty, vwapPrecision)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+func sortedPositionKeys(positions map[positionKey]position) []positionKey {
+	keys := make([]positionKey, 0, len(positions))
+	for key := range positions {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].account != keys[j].account {
+			return keys[i].account < keys[j].account
+		}
+		return keys[i].symbol < keys[j].symbol
+	})
+	return keys
+}
+
+// PositionBreak is a position of the gatling which differs from the one of the venue.
+type PositionBreak struct {
+	Account        string          `json:"account"`
+	Symbol         string          `json:"symbol"`
+	Fills          uint64          `json:"fills"`
+	VenueFills     uint64          `json:"venue_fills"`
+	BoughtQty      decimal.Decimal `json:"bought_qty"`
+	VenueBoughtQty decimal.Decimal `json:"venue_bought_qty"`
+	SoldQty        decimal.Decimal `json:"sold_qty"`
+	VenueSoldQty   decimal.Decimal `json:"venue_sold_qty"`
+	Notional       decimal.Decimal `json:"notional"`
+	VenueNotional  decimal.Decimal `json:"venue_notional"`
+}
+
+// ReconciliationReport compares the positions of the gatling with the ones built
+// from the trades reported by the venue.
+type ReconciliationReport struct {
+	Source string          `json:"source"`
+	Trades uint64          `json:"trades"`
+	Breaks []PositionBreak `json:"breaks"`
+}
+
+// reconcile compares the positions of the gatling with the ones of the venue. Both
+// sides of a position must have the same number of fills, quantities and notional.
+func reconcile(source string, ours *positionBook, venue *positionBook) *ReconciliationReport {
+	reconciliation := &ReconciliationReport{Source: source, Breaks: make([]PositionBreak, 0)}
+	all := ours.snapshot()
+	theirs := venue.snapshot()
+	for key, p := range theirs {
+		reconciliation.Trades += p.fills
+		if _, found := all[key]; !found {
+			all[key] = position{}
+		}
+	}
+	for _, key := range sortedPositionKeys(all) {
+		p, v := all[key], theirs[key]
+		if p.fills == v.fills && p.boughtQty.Equal(v.boughtQty) && p.soldQty.Equal(v.soldQty) &&
+			p.boughtNotional.Equal(v.boughtNotional) && p.soldNotional.Equal(v.soldNotional) {
+			continue
+		}
+		reconciliation.Breaks = append(reconciliation.Breaks, PositionBreak{
+			Account:        key.account,
+			Symbol:         key.symbol,
+			Fills:          p.fills,
+			VenueFills:     v.fills,
+			BoughtQty:      p.boughtQty,
+			VenueBoughtQty: v.boughtQty,
+			SoldQty:        p.soldQty,
+			VenueSoldQty:   v.soldQty,
+			Notional:       p.boughtNotional.Add(p.soldNotional),
+			VenueNotional:  v.boughtNotional.Add(v.soldNotional),
+		})
+	}
+	return reconciliation
+}
@@ -0,0 +1,356 @@
+package order
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	PriceUniform       = "uniform"
+	PriceWalk          = "walk"
+	PriceGBM           = "gbm"
+	PriceMeanReverting = "mean-reverting"
+	PriceReplay        = "replay"
+
+	defaultPriceBand = 0.10
+)
+
+// priceModelKeys lists the parameters of each price model.
+var priceModelKeys = map[string][]string{
+	PriceUniform:       {"band"},
+	PriceWalk:          {"volatility", "band"},
+	PriceGBM:           {"drift", "volatility", "band"},
+	PriceMeanReverting: {"mean", "speed", "volatility", "band"},
+	PriceReplay:        {"file", "interval", "band"},
+}
+
+// PriceModel gives the prices of the orders of a symbol. Orders are placed at an
+// offset from the reference price of the model, e.g. below it for bids. Drawing
+// prices doesn't move the model: it only moves once an order priced by it is sent,
+// so that orders which are skipped or refused leave the price path unchanged.
+type PriceModel interface {
+	Price(offset float64) float64
+	// Reference returns the reference price, without moving the model.
+	Reference() float64
+	// Advance moves the model after an order was sent, if a price was drawn since its previous move.
+	Advance()
+}
+
+// generatePrice returns the price of an order placed at an offset from the reference
+// price, rounded to the tick size of the instrument.
+func generatePrice(model PriceModel, instrument *Instrument, offset float64) decimal.Decimal {
+	return instrument.RoundPrice(model.Price(offset))
+}
+
+// jitter spreads prices uniformly over a band centered on zero.
+func jitter(band float64) float64 {
+	if band <= 0 {
+		return 0
+	}
+	return -band/2 + rand.Float64()*band
+}
+
+// UniformPrice draws prices in a fixed band around the reference price.
+type UniformPrice struct {
+	refPrice float64
+	band     float64
+}
+
+func NewUniformPrice(refPrice float64, band float64) *UniformPrice {
+	return &UniformPrice{refPrice: refPrice, band: band}
+}
+
+func (m *UniformPrice) Price(offset float64) float64 {
+	return m.refPrice + offset + jitter(m.band)
+}
+
+func (m *UniformPrice) Reference() float64 {
+	return m.refPrice
+}
+
+func (m *UniformPrice) Advance() {}
+
+// diffusion moves a price with the time elapsed since its previous move.
+type diffusion struct {
+	price float64
+	last  time.Time
+	drawn bool
+	band  float64
+	step  func(price float64, elapsed float64) float64
+	lock  sync.Mutex
+}
+
+func (d *diffusion) Price(offset float64) float64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.drawn = true
+	return d.price + offset + jitter(d.band)
+}
+
+func (d *diffusion) Advance() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if !d.drawn {
+		return
+	}
+	d.drawn = false
+	now := time.Now()
+	if !d.last.IsZero() {
+		d.price = d.step(d.price, now.Sub(d.last).Seconds())
+	}
+	d.last = now
+}
+
+func (d *diffusion) Reference() float64 {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.price
+}
+
+// NewRandomWalkPrice moves the price by a normal step whose standard deviation
+// is volatility per square root of second. The price is reflected at zero so it
+// never becomes negative.
+func NewRandomWalkPrice(refPrice float64, volatility float64, band float64) PriceModel {
+	return &diffusion{
+		price: refPrice,
+		band:  band,
+		step: func(price float64, elapsed float64) float64 {
+			return math.Abs(price + volatility*math.Sqrt(elapsed)*rand.NormFloat64())
+		},
+	}
+}
+
+// NewGBMPrice follows a geometric Brownian motion, drift and volatility being
+// relative and per second. The price never becomes negative.
+func NewGBMPrice(refPrice float64, drift float64, volatility float64, band float64) PriceModel {
+	return &diffusion{
+		price: refPrice,
+		band:  band,
+		step: func(price float64, elapsed float64) float64 {
+			return price * math.Exp((drift-volatility*volatility/2)*elapsed+volatility*math.Sqrt(elapsed)*rand.NormFloat64())
+		},
+	}
+}
+
+// NewMeanRevertingPrice follows an Ornstein-Uhlenbeck process pulled back to the
+// mean at the given speed (per second), volatility being per square root of second.
+// Moves use the exact transition of the process, whatever the time elapsed.
+func NewMeanRevertingPrice(refPrice float64, mean float64, speed float64, volatility float64, band float64) PriceModel {
+	return &diffusion{
+		price: refPrice,
+		band:  band,
+		step: func(price float64, elapsed float64) float64 {
+			decay := math.Exp(-speed * elapsed)
+			stddev := volatility * math.Sqrt((1-decay*decay)/(2*speed))
+			return mean + (price-mean)*decay + stddev*rand.NormFloat64()
+		},
+	}
+}
+
+// ReplayPrice plays a price series again, one price per order or, when an interval
+// is given, one price per interval from the first order. The series starts over
+// once it is over.
+type ReplayPrice struct {
+	prices   []float64
+	interval time.Duration
+	band     float64
+	start    time.Time
+	next     int
+	drawn    bool
+	lock     sync.Mutex
+}
+
+func NewReplayPrice(prices []float64, interval time.Duration, band float64) (*ReplayPrice, error) {
+	if len(prices) == 0 {
+		return nil, errors.New("empty price series")
+	}
+	return &ReplayPrice{prices: prices, interval: interval, band: band}, nil
+}
+
+func (m *ReplayPrice) Price(offset float64) float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.drawn = true
+	return m.current() + offset + jitter(m.band)
+}
+
+func (m *ReplayPrice) Reference() float64 {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.current()
+}
+
+func (m *ReplayPrice) Advance() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if !m.drawn {
+		return
+	}
+	m.drawn = false
+	if m.interval <= 0 {
+		m.next++
+	} else if m.start.IsZero() {
+		m.start = time.Now()
+	}
+}
+
+// current returns the price of the series to play. Must be called with the lock held.
+func (m *ReplayPrice) current() float64 {
+	idx := m.next
+	if m.interval > 0 {
+		idx = 0
+		if !m.start.IsZero() {
+			idx = int(time.Since(m.start) / m.interval)
+		}
+	}
+	return m.prices[idx%len(m.prices)]
+}
+
+// LoadPriceSeries reads the prices of a CSV file, taken from the last column of
+// each row so files holding a timestamp before the price can be used as is.
+// Rows whose price is not a number, such as a header, are ignored.
+func LoadPriceSeries(path string) ([]float64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
+	reader.Comment = '#'
+	prices := make([]float64, 0)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(record[len(record)-1]), 64)
+		if err != nil {
+			continue
+		}
+		prices = append(prices, price)
+	}
+	if len(prices) == 0 {
+		return nil, fmt.Errorf("no price in %s", path)
+	}
+	return prices, nil
+}
+
+// ParsePriceModel builds a model from a description such as "gbm:volatility=0.001".
+// The reference price of the symbol is the starting point of the model.
+func ParsePriceModel(description string, refPrice float64) (PriceModel, error) {
+	kind, params, err := parseSpec(description)
+	if err != nil {
+		return nil, fmt.Errorf("invalid price model: %w", err)
+	}
+	keys, found := priceModelKeys[kind]
+	if !found {
+		return nil, fmt.Errorf("unknown price model %q", kind)
+	}
+	if err := params.only(keys...); err != nil {
+		return nil, fmt.Errorf("invalid %s price model: %w", kind, err)
+	}
+
+	band := 0.0
+	if _, found := params.values["band"]; found {
+		band = params.float("band")
+	}
+	var model PriceModel
+	switch kind {
+	case PriceUniform:
+		if _, found := params.values["band"]; !found {
+			band = defaultPriceBand
+		}
+		model = NewUniformPrice(refPrice, band)
+	case PriceWalk:
+		model = NewRandomWalkPrice(refPrice, params.float("volatility"), band)
+	case PriceGBM:
+		model = NewGBMPrice(refPrice, params.float("drift"), params.float("volatility"), band)
+	case PriceMeanReverting:
+		mean := refPrice
+		if _, found := params.values["mean"]; found {
+			mean = params.float("mean")
+		}
+		if params.float("speed") <= 0 {
+			return nil, errors.New("mean-reverting price model needs a positive speed")
+		}
+		model = NewMeanRevertingPrice(refPrice, mean, params.float("speed"), params.float("volatility"), band)
+	case PriceReplay:
+		path := params.values["file"]
+		if len(path) == 0 {
+			return nil, errors.New("replay price model needs a file")
+		}
+		interval := params.duration("interval")
+		if params.err != nil {
+			break
+		}
+		prices, err := LoadPriceSeries(path)
+		if err != nil {
+			return nil, err
+		}
+		model, err = NewReplayPrice(prices, interval, band)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if params.err != nil {
+		return nil, fmt.Errorf("invalid %s price model: %w", kind, params.err)
+	}
+	if params.float("volatility") < 0 || band < 0 {
+		return nil, fmt.Errorf("%s price model needs a positive volatility and band", kind)
+	}
+	return model, nil
+}
+
+// NewPriceModels builds the model of each symbol. Descriptions are either
+// "<model>" for every symbol or "<symbol>=<model>" for one of them, the uniform
+// band model being used by default.
+func NewPriceModels(symbols []string, refPrices []float64, descriptions []string) (map[string]PriceModel, error) {
+	if len(symbols) != len(refPrices) {
+		return nil, errors.New("number of symbols must match number of reference prices")
+	}
+	defaultDescription := PriceUniform
+	bySymbol := make(map[string]string)
+	for _, description := range descriptions {
+		symbol, model, found := strings.Cut(description, "=")
+		if !found || strings.Contains(symbol, ":") {
+			defaultDescription = description
+			continue
+		}
+		bySymbol[strings.TrimSpace(symbol)] = model
+	}
+
+	models := make(map[string]PriceModel, len(symbols))
+	for i, symbol := range symbols {
+		description, found := bySymbol[symbol]
+		if !found {
+			description = defaultDescription
+		}
+		model, err := ParsePriceModel(description, refPrices[i])
+		if err != nil {
+			return nil, fmt.Errorf("symbol %s: %w", symbol, err)
+		}
+		models[symbol] = model
+	}
+	for symbol := range bySymbol {
+		if _, found := models[symbol]; !found {
+			return nil, fmt.Errorf("price model given for unknown symbol %s", symbol)
+		}
+	}
+	return models, nil
+}
@@ -0,0 +1,135 @@
+package order
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestMeanRevertingStep(t *testing.T) {
+	tests := []struct {
+		name    string
+		price   float64
+		speed   float64
+		elapsed float64
+		want    float64
+	}{
+		{"no time elapsed", 110, 0.5, 0, 110},
+		{"one half-life", 110, math.Ln2, 1, 105},
+		{"long gap reaches the mean", 110, 0.5, 100, 100},
+		{"below the mean", 90, math.Ln2, 2, 97.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// Without volatility the process decays exactly towards the mean
+			model := NewMeanRevertingPrice(test.price, 100, test.speed, 0, 0).(*diffusion)
+			if got := model.step(test.price, test.elapsed); math.Abs(got-test.want) > 1e-9 {
+				t.Fatalf("price %v, expected %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMeanRevertingVariance(t *testing.T) {
+	// A long gap draws from the stationary distribution, of variance volatility²/(2 speed)
+	model := NewMeanRevertingPrice(100, 100, 0.5, 2, 0).(*diffusion)
+	var sum, squares float64
+	const draws = 20000
+	for i := 0; i < draws; i++ {
+		price := model.step(100, 1000)
+		sum += price
+		squares += price * price
+	}
+	mean := sum / draws
+	variance := squares/draws - mean*mean
+	if math.Abs(mean-100) > 0.1 || math.Abs(variance-4) > 0.3 {
+		t.Fatalf("mean %v and variance %v, expected 100 and 4", mean, variance)
+	}
+}
+
+func TestRandomWalkNeverNegative(t *testing.T) {
+	model := NewRandomWalkPrice(1, 10, 0).(*diffusion)
+	price := 1.0
+	for i := 0; i < 10000; i++ {
+		if price = model.step(price, 1); price < 0 {
+			t.Fatalf("negative price %v after %d steps", price, i)
+		}
+	}
+}
+
+func TestPriceModelAdvance(t *testing.T) {
+	replay, _ := NewReplayPrice([]float64{100, 200, 300}, 0, 0)
+	walk := NewRandomWalkPrice(100, 1, 0)
+	tests := []struct {
+		name  string
+		model PriceModel
+		// sends tells, for each order, whether it is sent after its price is drawn
+		sends []bool
+		// prices of the orders
+		want []float64
+	}{
+		{"uniform", NewUniformPrice(100, 0), []bool{true, false, true}, []float64{100, 100, 100}},
+		{"replay moves on sent orders", replay, []bool{true, false, false, true, true}, []float64{100, 200, 200, 200, 300}},
+		{"walk doesn't move without sent orders", walk, []bool{false, false, false}, []float64{100, 100, 100}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, sent := range test.sends {
+				if got := test.model.Price(0); got != test.want[i] {
+					t.Fatalf("order %d priced %v, expected %v", i, got, test.want[i])
+				}
+				if got := test.model.Reference(); got != test.want[i] {
+					t.Fatalf("order %d: reference %v, expected %v", i, got, test.want[i])
+				}
+				if sent {
+					test.model.Advance()
+				}
+			}
+		})
+	}
+}
+
+func TestPriceModelAdvanceOncePerDraw(t *testing.T) {
+	replay, _ := NewReplayPrice([]float64{100, 200, 300}, 0, 0)
+	// Both sides of a quote are drawn before it is sent
+	bid, offer := replay.Price(-1), replay.Price(1)
+	replay.Advance()
+	replay.Advance()
+	if bid != 99 || offer != 101 || replay.Reference() != 200 {
+		t.Fatalf("bid %v, offer %v then reference %v", bid, offer, replay.Reference())
+	}
+}
+
+func TestParsePriceModel(t *testing.T) {
+	tests := []struct {
+		description string
+		err         string
+	}{
+		{description: "uniform:band=0.05"},
+		{description: "walk:volatility=0.01,band=0.2"},
+		{description: "gbm:drift=0.001,volatility=0.01,band=0.2"},
+		{description: "mean-reverting:mean=100,speed=0.1,volatility=0.01,band=0.2"},
+		{description: "brownian", err: `unknown price model "brownian"`},
+		{description: "uniform:volatility=0.01", err: `invalid uniform price model: unknown parameter "volatility"`},
+		{description: "walk:drift=0.001", err: `invalid walk price model: unknown parameter "drift"`},
+		{description: "gbm:volatilty=0.01", err: `invalid gbm price model: unknown parameter "volatilty"`},
+		{description: "mean-reverting:speed=0.1,interval=1s", err: `invalid mean-reverting price model: unknown parameter "interval"`},
+		{description: "replay:file=prices.csv,speed=2", err: `invalid replay price model: unknown parameter "speed"`},
+		{description: "mean-reverting:speed=0", err: "needs a positive speed"},
+		{description: "walk:volatility=-0.1", err: "needs a positive volatility and band"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			_, err := ParsePriceModel(test.description, 100)
+			if len(test.err) == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
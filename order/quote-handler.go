@@ -12,23 +12,28 @@ import (
 )
 
 type QuoteHandler struct {
-	symbol        string
-	bidRefPrice   float64
-	offerRefPrice float64
-	side          enum.Side
-	lastClOrdId   string
-	account       string
-	timestamp     time.Time
-	messageType   string
+	symbol      string
+	instrument  *Instrument
+	prices      PriceModel
+	spread      float64
+	side        enum.Side
+	lastClOrdId string
+	account     string
+	parties     []Party
+	timestamp   time.Time
+	messageType string
 }
 
-func NewQuoteHandler(symbol string, bidPrice, offerPrice float64, account string) *QuoteHandler {
+// NewQuoteHandler creates a handler quoting on both sides, each one spread away from the reference price of the model.
+func NewQuoteHandler(instrument *Instrument, prices PriceModel, spread float64, account string, parties []Party) *QuoteHandler {
 	return &QuoteHandler{
-		symbol:        symbol,
-		bidRefPrice:   bidPrice,
-		offerRefPrice: offerPrice,
-		side:          enum.Side_AS_DEFINED,
-		account:       account,
+		symbol:     instrument.Symbol,
+		instrument: instrument,
+		prices:     prices,
+		spread:     spread,
+		side:       enum.Side_AS_DEFINED,
+		account:    account,
+		parties:    parties,
 	}
 }
 
@@ -50,15 +55,7 @@ func (q *QuoteHandler) BuildAllCancelRequest(symbols []string) quickfix.Messagab
 		quoteCancel.SetNoQuoteEntries(quoteEntriesGroup)
 	}
 	quoteCancel.Set(field.NewQuoteID("cancel_all"))
-	partyIdsGroup := quotecancel.NewNoPartyIDsRepeatingGroup()
-	partyIds := partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID(q.account))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_CUSTOMER_ACCOUNT))
-	partyIds = partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID("ATH"))
-	partyIds.Set(field.NewPartyIDSource(enum.PartyIDSource_CHINESE_INVESTOR_ID))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_INVESTMENT_DECISION_MAKER))
-	quoteCancel.SetNoPartyIDs(partyIdsGroup)
+	quoteCancel.SetGroup(newPartiesGroup(q.account, q.parties))
 	return quoteCancel
 }
 
@@ -72,22 +69,18 @@ func (q *QuoteHandler) BuildOrderRequest() (quickfix.Messagable, string) {
 		field.NewQuoteID(clOrdId),
 	)
 	quoteMsg.Set(field.NewSymbol(q.symbol))
-	quoteMsg.Set(field.NewBidPx(generatePrice(q.bidRefPrice), 2))
-	quoteMsg.Set(field.NewBidSize(generateOrderQuantity(), 0))
-	quoteMsg.Set(field.NewOfferPx(generatePrice(q.offerRefPrice), 2))
-	quoteMsg.Set(field.NewOfferSize(generateOrderQuantity(), 0))
-	partyIdsGroup := quote.NewNoPartyIDsRepeatingGroup()
-	partyIds := partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID(q.account))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_CUSTOMER_ACCOUNT))
-	partyIds = partyIdsGroup.Add()
-	partyIds.Set(field.NewPartyID("ATH"))
-	partyIds.Set(field.NewPartyIDSource(enum.PartyIDSource_CHINESE_INVESTOR_ID))
-	partyIds.Set(field.NewPartyRole(enum.PartyRole_INVESTMENT_DECISION_MAKER))
-	quoteMsg.SetNoPartyIDs(partyIdsGroup)
+	quoteMsg.Set(field.NewBidPx(generatePrice(q.prices, q.instrument, -q.spread), q.instrument.PricePrecision))
+	quoteMsg.Set(field.NewBidSize(q.instrument.GenerateQuantity(), q.instrument.QtyPrecision))
+	quoteMsg.Set(field.NewOfferPx(generatePrice(q.prices, q.instrument, q.spread), q.instrument.PricePrecision))
+	quoteMsg.Set(field.NewOfferSize(q.instrument.GenerateQuantity(), q.instrument.QtyPrecision))
+	quoteMsg.SetGroup(newPartiesGroup(q.account, q.parties))
 	return quoteMsg, clOrdId
 }
 
+func (q *QuoteHandler) Rests() bool {
+	return true
+}
+
 func (q *QuoteHandler) GetSymbol() string {
 	return q.symbol
 }
@@ -109,7 +102,7 @@ func (q *QuoteHandler) GetTimestamp() time.Time {
 }
 
 func (q *QuoteHandler) GetMessageType() string {
-	return "Quote"
+	return msgTypeQuote
 }
 
 func (q *QuoteHandler) UpdateClientOrderId(newId string) {
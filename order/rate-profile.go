@@ -0,0 +1,300 @@
+package order
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateProfile gives the number of orders per second to send at a point of the run.
+type RateProfile interface {
+	Rate(elapsed time.Duration) float64
+}
+
+type ConstantRate struct {
+	rate float64
+}
+
+func NewConstantRate(rate float64) *ConstantRate {
+	return &ConstantRate{rate: rate}
+}
+
+func (r *ConstantRate) Rate(elapsed time.Duration) float64 {
+	return r.rate
+}
+
+// RampRate increases (or decreases) linearly from one rate to another and then stays flat.
+type RampRate struct {
+	from     float64
+	to       float64
+	duration time.Duration
+}
+
+func (r *RampRate) Rate(elapsed time.Duration) float64 {
+	if elapsed >= r.duration {
+		return r.to
+	}
+	return r.from + (r.to-r.from)*float64(elapsed)/float64(r.duration)
+}
+
+// StepRate is a staircase adding a fixed increment every period until a maximum is reached.
+type StepRate struct {
+	from  float64
+	step  float64
+	every time.Duration
+	to    float64
+}
+
+func (r *StepRate) Rate(elapsed time.Duration) float64 {
+	rate := r.from + r.step*math.Floor(float64(elapsed)/float64(r.every))
+	if r.to > 0 && rate > r.to {
+		return r.to
+	}
+	return rate
+}
+
+// SineRate oscillates around a mean rate, e.g. to mimic the shape of a trading day.
+type SineRate struct {
+	mean      float64
+	amplitude float64
+	period    time.Duration
+}
+
+func (r *SineRate) Rate(elapsed time.Duration) float64 {
+	rate := r.mean + r.amplitude*math.Sin(2*math.Pi*float64(elapsed)/float64(r.period))
+	return math.Max(rate, 0)
+}
+
+// BurstRate sends at a base rate and periodically switches to a peak rate for a short time.
+type BurstRate struct {
+	base   float64
+	peak   float64
+	every  time.Duration
+	length time.Duration
+}
+
+func (r *BurstRate) Rate(elapsed time.Duration) float64 {
+	if elapsed%r.every < r.length {
+		return r.peak
+	}
+	return r.base
+}
+
+type rateSegment struct {
+	profile  RateProfile
+	duration time.Duration
+}
+
+// SequenceRate chains profiles, each one running for its own duration. The last
+// profile runs until the end of the test.
+type SequenceRate struct {
+	segments []rateSegment
+}
+
+func (r *SequenceRate) Rate(elapsed time.Duration) float64 {
+	for i, segment := range r.segments {
+		if elapsed < segment.duration || i == len(r.segments)-1 {
+			return segment.profile.Rate(elapsed)
+		}
+		elapsed -= segment.duration
+	}
+	return 0
+}
+
+// ParseRateProfile builds a profile from a description such as
+// "ramp:from=10,to=1000,duration=5m". Profiles chained with ";" run one after the other,
+// each one for the time given by its "duration" parameter, which only the last one
+// can omit.
+func ParseRateProfile(description string) (RateProfile, error) {
+	sequence := &SequenceRate{}
+	for _, part := range strings.Split(description, ";") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		segment, err := parseRateSegment(part)
+		if err != nil {
+			return nil, err
+		}
+		sequence.segments = append(sequence.segments, segment)
+	}
+	for i, segment := range sequence.segments[:max(len(sequence.segments)-1, 0)] {
+		if segment.duration <= 0 {
+			return nil, fmt.Errorf("rate profile %d of the sequence needs a positive duration, only the last one runs until the end", i+1)
+		}
+	}
+	switch len(sequence.segments) {
+	case 0:
+		return nil, errors.New("empty rate profile")
+	case 1:
+		return sequence.segments[0].profile, nil
+	default:
+		return sequence, nil
+	}
+}
+
+// LoadRateProfile reads a rate profile from a file holding one profile per line.
+// Empty lines and lines starting with "#" are ignored.
+func LoadRateProfile(path string) (RateProfile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ParseRateProfile(strings.Join(lines, ";"))
+}
+
+func parseRateSegment(description string) (rateSegment, error) {
+	kind, params, err := parseSpec(description)
+	if err != nil {
+		return rateSegment{}, fmt.Errorf("invalid rate profile: %w", err)
+	}
+
+	var profile RateProfile
+	var keys []string
+	switch kind {
+	case "constant":
+		profile = &ConstantRate{rate: params.float("rate")}
+		keys = []string{"rate"}
+	case "ramp":
+		profile = &RampRate{from: params.float("from"), to: params.float("to"), duration: params.duration("duration")}
+		keys = []string{"from", "to"}
+	case "step":
+		profile = &StepRate{from: params.float("from"), step: params.float("step"), every: params.duration("every"), to: params.float("to")}
+		keys = []string{"from", "step", "every", "to"}
+	case "sine":
+		profile = &SineRate{mean: params.float("mean"), amplitude: params.float("amplitude"), period: params.duration("period")}
+		keys = []string{"mean", "amplitude", "period"}
+	case "burst":
+		profile = &BurstRate{base: params.float("base"), peak: params.float("peak"), every: params.duration("every"), length: params.duration("length")}
+		keys = []string{"base", "peak", "every", "length"}
+	default:
+		return rateSegment{}, fmt.Errorf("unknown rate profile %q", kind)
+	}
+	// Every profile accepts the duration of its segment in a sequence
+	if err := params.only(append(keys, "duration")...); err != nil {
+		return rateSegment{}, fmt.Errorf("invalid %s rate profile: %w", kind, err)
+	}
+	if params.err != nil {
+		return rateSegment{}, fmt.Errorf("invalid %s rate profile: %w", kind, params.err)
+	}
+	if err := validateRateProfile(profile); err != nil {
+		return rateSegment{}, err
+	}
+
+	segment := rateSegment{profile: profile}
+	if _, found := params.values["duration"]; found {
+		segment.duration = params.duration("duration")
+	}
+	return segment, nil
+}
+
+func validateRateProfile(profile RateProfile) error {
+	switch p := profile.(type) {
+	case *ConstantRate:
+		if p.rate < 0 {
+			return errors.New("constant rate profile can't have a negative rate")
+		}
+	case *RampRate:
+		if p.from < 0 || p.to < 0 {
+			return errors.New("ramp rate profile can't have negative rates")
+		}
+		if p.duration <= 0 {
+			return errors.New("ramp rate profile needs a positive duration")
+		}
+	case *StepRate:
+		if p.from < 0 || p.step < 0 || p.to < 0 {
+			return errors.New("step rate profile can't have negative rates or steps")
+		}
+		if p.every <= 0 {
+			return errors.New("step rate profile needs a positive period")
+		}
+	case *SineRate:
+		if p.mean < 0 || p.amplitude < 0 {
+			return errors.New("sine rate profile can't have a negative mean or amplitude")
+		}
+		if p.period <= 0 {
+			return errors.New("sine rate profile needs a positive period")
+		}
+	case *BurstRate:
+		if p.base < 0 || p.peak < 0 {
+			return errors.New("burst rate profile can't have negative rates")
+		}
+		if p.every <= 0 || p.length > p.every {
+			return errors.New("burst rate profile needs a positive period longer than the burst")
+		}
+	}
+	return nil
+}
+
+// specParams holds the parameters of a description such as "ramp:from=10,to=1000".
+// Conversion errors are kept until the whole description is read.
+type specParams struct {
+	values map[string]string
+	err    error
+}
+
+// parseSpec splits a description into its kind and its parameters.
+func parseSpec(description string) (string, *specParams, error) {
+	kind, rawParams, _ := strings.Cut(description, ":")
+	params := &specParams{values: make(map[string]string)}
+	for _, param := range strings.Split(rawParams, ",") {
+		if len(strings.TrimSpace(param)) == 0 {
+			continue
+		}
+		key, value, found := strings.Cut(param, "=")
+		if !found {
+			return "", nil, fmt.Errorf("invalid parameter %q", param)
+		}
+		params.values[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(kind), params, nil
+}
+
+func (p *specParams) float(key string) float64 {
+	value, found := p.values[key]
+	if !found {
+		return 0
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		p.setError(fmt.Errorf("%s: %w", key, err))
+	}
+	return f
+}
+
+func (p *specParams) duration(key string) time.Duration {
+	value, found := p.values[key]
+	if !found {
+		return 0
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		p.setError(fmt.Errorf("%s: %w", key, err))
+	}
+	return d
+}
+
+func (p *specParams) setError(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
@@ -0,0 +1,61 @@
+package order
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateProfile(t *testing.T) {
+	tests := []struct {
+		description string
+		err         string
+		// rates expected at the elapsed times
+		at    []time.Duration
+		rates []float64
+	}{
+		{description: "constant:rate=100", at: []time.Duration{0, time.Hour}, rates: []float64{100, 100}},
+		{description: "ramp:from=10,to=110,duration=10s", at: []time.Duration{0, 5 * time.Second, time.Minute}, rates: []float64{10, 60, 110}},
+		{description: "step:from=100,step=50,every=10s,to=180", at: []time.Duration{9 * time.Second, 10 * time.Second, time.Minute}, rates: []float64{100, 150, 180}},
+		{description: "sine:mean=100,amplitude=200,period=4s", at: []time.Duration{0, time.Second, 3 * time.Second}, rates: []float64{100, 300, 0}},
+		{description: "burst:base=10,peak=100,every=10s,length=2s", at: []time.Duration{time.Second, 5 * time.Second, 11 * time.Second}, rates: []float64{100, 10, 100}},
+		{
+			description: "ramp:from=0,to=100,duration=10s;constant:rate=100,duration=10s;constant:rate=5",
+			at:          []time.Duration{5 * time.Second, 15 * time.Second, time.Hour},
+			rates:       []float64{50, 100, 5},
+		},
+		{description: "", err: "empty rate profile"},
+		{description: "linear:rate=10", err: "unknown rate profile"},
+		{description: "constant:rate=ten", err: "invalid constant rate profile"},
+		{description: "constant:rate=10,peak=20", err: `unknown parameter "peak"`},
+		{description: "ramp:from=10,to=100,duraton=1m", err: `unknown parameter "duraton"`},
+		{description: "constant:rate=-1", err: "negative rate"},
+		{description: "ramp:from=-10,to=100,duration=1m", err: "negative rates"},
+		{description: "step:from=100,step=-10,every=1s", err: "negative rates or steps"},
+		{description: "sine:mean=100,amplitude=-50,period=1m", err: "negative mean or amplitude"},
+		{description: "burst:base=-1,peak=10,every=1m,length=1s", err: "negative rates"},
+		{description: "ramp:from=10,to=100", err: "positive duration"},
+		{description: "burst:base=1,peak=10,every=1s,length=2s", err: "longer than the burst"},
+		{description: "constant:rate=10;constant:rate=20", err: "rate profile 1 of the sequence needs a positive duration"},
+		{description: "constant:rate=10,duration=1m;constant:rate=20;constant:rate=5", err: "rate profile 2 of the sequence needs a positive duration"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			profile, err := ParseRateProfile(test.description)
+			if len(test.err) > 0 {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			for i, elapsed := range test.at {
+				if rate := profile.Rate(elapsed); rate < test.rates[i]-1e-9 || rate > test.rates[i]+1e-9 {
+					t.Fatalf("rate %v after %s, expected %v", rate, elapsed, test.rates[i])
+				}
+			}
+		})
+	}
+}
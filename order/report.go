@@ -0,0 +1,518 @@
+package order
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// Latencies are recorded in microseconds, from 1µs up to one minute with 3 significant digits.
+const (
+	lowestLatency            = 1
+	highestLatency           = int64(time.Minute / time.Microsecond)
+	latencySignificantDigits = 3
+)
+
+var stats = newRunStats()
+
+type messageStats struct {
+	sent      uint64
+	acked     uint64
+	rejected  uint64
+	latencies *hdrhistogram.Histogram
+	interval  *hdrhistogram.Histogram
+	recent    *hdrhistogram.Histogram
+}
+
+func newLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(lowestLatency, highestLatency, latencySignificantDigits)
+}
+
+type runStats struct {
+	lock            sync.Mutex
+	start           time.Time
+	stop            time.Time
+	scheduled       uint64
+	disconnects     uint64
+	substitutions   uint64
+	messages        map[string]*messageStats
+	symbols         map[string]*messageStats
+	feed            map[string]*feedStats
+	violations      map[string]uint64
+	reconciliations []*ReconciliationReport
+	dropCopy        *DropCopyReport
+	riskBreach      *RiskBreach
+}
+
+// feedStats follows the publication in the market data feed of the requests of a message type.
+type feedStats struct {
+	published   uint64
+	unpublished uint64
+	latencies   *hdrhistogram.Histogram
+}
+
+func newRunStats() *runStats {
+	return &runStats{
+		messages:   make(map[string]*messageStats),
+		symbols:    make(map[string]*messageStats),
+		feed:       make(map[string]*feedStats),
+		violations: make(map[string]uint64),
+	}
+}
+
+// markStart records the beginning of the load, the first call wins.
+func (s *runStats) markStart() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.start.IsZero() {
+		s.start = time.Now()
+	}
+}
+
+// MarkStop records the end of the load, the first call wins. The report measures
+// the run up to that point, leaving out the cancels and reconciliation of the shutdown.
+func MarkStop() {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+	if stats.stop.IsZero() {
+		stats.stop = time.Now()
+	}
+}
+
+func newMessageStats() *messageStats {
+	return &messageStats{latencies: newLatencyHistogram(), interval: newLatencyHistogram(), recent: newLatencyHistogram()}
+}
+
+func (s *runStats) get(messageType string) *messageStats {
+	m, found := s.messages[messageType]
+	if !found {
+		m = newMessageStats()
+		s.messages[messageType] = m
+	}
+	return m
+}
+
+// update applies a change to the stats of the message type and, when known, to the ones of the symbol.
+func (s *runStats) update(messageType string, symbol string, change func(m *messageStats)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	change(s.get(messageType))
+	if len(symbol) == 0 {
+		return
+	}
+	m, found := s.symbols[symbol]
+	if !found {
+		m = newMessageStats()
+		s.symbols[symbol] = m
+	}
+	change(m)
+}
+
+func (s *runStats) recordSent(messageType string, symbol string) {
+	s.update(messageType, symbol, func(m *messageStats) { m.sent++ })
+}
+
+func (s *runStats) recordScheduled() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.scheduled++
+}
+
+func (s *runStats) recordDisconnect() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.disconnects++
+}
+
+// recordSubstitution counts a new order replacing the current one instead, its
+// handler having left as many orders on the book as allowed.
+func (s *runStats) recordSubstitution() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.substitutions++
+}
+
+func (s *runStats) recordAck(messageType string, symbol string) {
+	s.update(messageType, symbol, func(m *messageStats) { m.acked++ })
+}
+
+func (s *runStats) recordReject(messageType string, symbol string) {
+	s.update(messageType, symbol, func(m *messageStats) { m.rejected++ })
+}
+
+func (s *runStats) recordLatency(messageType string, symbol string, latency time.Duration) {
+	s.update(messageType, symbol, func(m *messageStats) { m.observe(latency) })
+}
+
+func (s *runStats) getFeed(messageType string) *feedStats {
+	f, found := s.feed[messageType]
+	if !found {
+		f = &feedStats{latencies: newLatencyHistogram()}
+		s.feed[messageType] = f
+	}
+	return f
+}
+
+// recordFeedLatency records the latency between a request and the market data update publishing it.
+func (s *runStats) recordFeedLatency(messageType string, latency time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f := s.getFeed(messageType)
+	f.published++
+	_ = f.latencies.RecordValue(clampLatency(latency))
+}
+
+func (s *runStats) recordFeedMissed(messageType string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.getFeed(messageType).unpublished++
+}
+
+// recordViolation counts an execution report breaking the order lifecycle.
+func (s *runStats) recordViolation(kind string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.violations[kind]++
+}
+
+// recordReconciliation keeps a comparison of the positions with the ones of the venue.
+func (s *runStats) recordReconciliation(reconciliation *ReconciliationReport) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reconciliations = append(s.reconciliations, reconciliation)
+}
+
+// recordDropCopy keeps the matching of the execution reports with their drop copies.
+func (s *runStats) recordDropCopy(dropCopy *DropCopyReport) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.dropCopy = dropCopy
+}
+
+// recordRiskBreach keeps the risk limit which halted the generation.
+func (s *runStats) recordRiskBreach(breach *RiskBreach) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.riskBreach = breach
+}
+
+// answers returns the number of requests acknowledged and rejected since the start.
+func (s *runStats) answers() (uint64, uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var acked, rejected uint64
+	for _, m := range s.messages {
+		acked += m.acked
+		rejected += m.rejected
+	}
+	return acked, rejected
+}
+
+// observe records a latency in the histogram of the whole run, in the one of the
+// current log interval and in the one read by the dashboard. Latencies above the
+// highest trackable value are clamped.
+func (m *messageStats) observe(latency time.Duration) {
+	value := clampLatency(latency)
+	_ = m.latencies.RecordValue(value)
+	_ = m.interval.RecordValue(value)
+	_ = m.recent.RecordValue(value)
+}
+
+// clampLatency converts a latency to microseconds within the trackable range.
+func clampLatency(latency time.Duration) int64 {
+	value := latency.Microseconds()
+	if value < lowestLatency {
+		return lowestLatency
+	} else if value > highestLatency {
+		return highestLatency
+	}
+	return value
+}
+
+// liveCounters is a copy of the counters of a message type or a symbol, with the
+// latencies recorded since the previous copy.
+type liveCounters struct {
+	sent     uint64
+	acked    uint64
+	rejected uint64
+	recent   *hdrhistogram.Histogram
+}
+
+// rotateRecent copies the counters of each message type and each symbol.
+func (s *runStats) rotateRecent() (map[string]liveCounters, map[string]liveCounters) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rotate := func(all map[string]*messageStats) map[string]liveCounters {
+		counters := make(map[string]liveCounters, len(all))
+		for name, m := range all {
+			counters[name] = liveCounters{sent: m.sent, acked: m.acked, rejected: m.rejected, recent: m.recent}
+			m.recent = newLatencyHistogram()
+		}
+		return counters
+	}
+	return rotate(s.messages), rotate(s.symbols)
+}
+
+// rotateIntervals returns the histograms recorded since the previous call, keyed by message type.
+func (s *runStats) rotateIntervals() map[string]*hdrhistogram.Histogram {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	intervals := make(map[string]*hdrhistogram.Histogram, len(s.messages))
+	for messageType, m := range s.messages {
+		if m.interval.TotalCount() == 0 {
+			continue
+		}
+		intervals[messageType] = m.interval
+		m.interval = newLatencyHistogram()
+	}
+	return intervals
+}
+
+// snapshotLatencies returns a copy of the histograms of the whole run, keyed by message type.
+func (s *runStats) snapshotLatencies() map[string]*hdrhistogram.Histogram {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	latencies := make(map[string]*hdrhistogram.Histogram, len(s.messages))
+	for messageType, m := range s.messages {
+		if m.latencies.TotalCount() == 0 {
+			continue
+		}
+		latencies[messageType] = hdrhistogram.Import(m.latencies.Export())
+	}
+	return latencies
+}
+
+type LatencyReport struct {
+	P50  float64 `json:"p50_ms"`
+	P90  float64 `json:"p90_ms"`
+	P99  float64 `json:"p99_ms"`
+	P999 float64 `json:"p99_9_ms"`
+	Max  float64 `json:"max_ms"`
+}
+
+type MessageReport struct {
+	Type     string         `json:"type"`
+	Sent     uint64         `json:"sent"`
+	Acked    uint64         `json:"acked"`
+	Rejected uint64         `json:"rejected"`
+	Latency  *LatencyReport `json:"latency,omitempty"`
+}
+
+// FeedReport gives the latency between the requests of a message type and their
+// publication in the market data feed.
+type FeedReport struct {
+	Type        string         `json:"type"`
+	Published   uint64         `json:"published"`
+	Unpublished uint64         `json:"unpublished"`
+	Latency     *LatencyReport `json:"latency,omitempty"`
+}
+
+// Report summarizes a run of the gatling.
+type Report struct {
+	Start           time.Time               `json:"start"`
+	End             time.Time               `json:"end"`
+	Duration        float64                 `json:"duration_s"`
+	TargetRate      float64                 `json:"target_rate,omitempty"`
+	AchievedRate    float64                 `json:"achieved_rate,omitempty"`
+	Disconnects     uint64                  `json:"disconnects"`
+	Substitutions   uint64                  `json:"substitutions,omitempty"`
+	Messages        []MessageReport         `json:"messages"`
+	Feed            []FeedReport            `json:"feed,omitempty"`
+	Violations      map[string]uint64       `json:"violations,omitempty"`
+	Positions       []PositionReport        `json:"positions,omitempty"`
+	Reconciliations []*ReconciliationReport `json:"reconciliations,omitempty"`
+	DropCopy        *DropCopyReport         `json:"drop_copy,omitempty"`
+	RiskBreach      *RiskBreach             `json:"risk_breach,omitempty"`
+}
+
+// BuildReport summarizes everything recorded since the load started. Rates are
+// computed over the load, up to its end when it is marked.
+func BuildReport() *Report {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	end := stats.stop
+	if end.IsZero() {
+		end = time.Now()
+	}
+	start := stats.start
+	if start.IsZero() {
+		start = end
+	}
+	report := &Report{
+		Start:         start,
+		End:           end,
+		Duration:      end.Sub(start).Seconds(),
+		Disconnects:   stats.disconnects,
+		Substitutions: stats.substitutions,
+		Messages:      make([]MessageReport, 0, len(stats.messages)),
+	}
+
+	for messageType, m := range stats.messages {
+		msgReport := MessageReport{
+			Type:     messageType,
+			Sent:     m.sent,
+			Acked:    m.acked,
+			Rejected: m.rejected,
+		}
+		msgReport.Latency = newLatencyReport(m.latencies)
+		report.Messages = append(report.Messages, msgReport)
+	}
+	sort.Slice(report.Messages, func(i, j int) bool { return report.Messages[i].Type < report.Messages[j].Type })
+
+	for messageType, f := range stats.feed {
+		report.Feed = append(report.Feed, FeedReport{
+			Type:        messageType,
+			Published:   f.published,
+			Unpublished: f.unpublished,
+			Latency:     newLatencyReport(f.latencies),
+		})
+	}
+	sort.Slice(report.Feed, func(i, j int) bool { return report.Feed[i].Type < report.Feed[j].Type })
+
+	if len(stats.violations) > 0 {
+		report.Violations = make(map[string]uint64, len(stats.violations))
+		for kind, count := range stats.violations {
+			report.Violations[kind] = count
+		}
+	}
+
+	if p := positions.report(); len(p) > 0 {
+		report.Positions = p
+	}
+	report.Reconciliations = stats.reconciliations
+	report.DropCopy = stats.dropCopy
+	report.RiskBreach = stats.riskBreach
+
+	if stats.scheduled > 0 && report.Duration > 0 {
+		report.TargetRate = float64(stats.scheduled) / report.Duration
+		report.AchievedRate = float64(stats.get(msgTypeNewOrderSingle).sent) / report.Duration
+	}
+	return report
+}
+
+// newLatencyReport summarizes a histogram, nil when it is empty.
+func newLatencyReport(latencies *hdrhistogram.Histogram) *LatencyReport {
+	if latencies.TotalCount() == 0 {
+		return nil
+	}
+	return &LatencyReport{
+		P50:  toMilliseconds(latencies.ValueAtPercentile(50)),
+		P90:  toMilliseconds(latencies.ValueAtPercentile(90)),
+		P99:  toMilliseconds(latencies.ValueAtPercentile(99)),
+		P999: toMilliseconds(latencies.ValueAtPercentile(99.9)),
+		Max:  toMilliseconds(latencies.Max()),
+	}
+}
+
+// toMilliseconds converts a recorded latency to milliseconds. The histogram returns
+// the highest value equivalent to the recorded one, which can't exceed the trackable range.
+func toMilliseconds(value int64) float64 {
+	return float64(value) / 1000
+}
+
+func (r *Report) WriteJSON(path string) error {
+	content, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, content, 0644)
+}
+
+func (r *Report) WriteMarkdown(path string) error {
+	return os.WriteFile(path, []byte(r.Markdown()), 0644)
+}
+
+func (r *Report) Print(w io.Writer) {
+	fmt.Fprint(w, r.Markdown())
+}
+
+func (r *Report) Markdown() string {
+	b := strings.Builder{}
+	b.WriteString("# Order gatling report\n\n")
+	fmt.Fprintf(&b, "- Start: %s\n", r.Start.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- End: %s\n", r.End.Format(time.RFC3339))
+	fmt.Fprintf(&b, "- Duration: %.1fs\n", r.Duration)
+	if r.TargetRate > 0 {
+		fmt.Fprintf(&b, "- Target rate: %.1f msg/s\n", r.TargetRate)
+		fmt.Fprintf(&b, "- Achieved rate: %.1f msg/s\n", r.AchievedRate)
+	}
+	fmt.Fprintf(&b, "- Disconnects: %d\n", r.Disconnects)
+	if r.Substitutions > 0 {
+		fmt.Fprintf(&b, "- New orders sent as replaces: %d\n", r.Substitutions)
+	}
+	if r.RiskBreach != nil {
+		fmt.Fprintf(&b, "- Halted at %s: %s\n", r.RiskBreach.Time.Format(time.RFC3339), r.RiskBreach.Error())
+	}
+	b.WriteString("\n| Type | Sent | Acked | Rejected | p50 (ms) | p90 (ms) | p99 (ms) | p99.9 (ms) | max (ms) |\n")
+	b.WriteString("|------|-----:|------:|---------:|---------:|---------:|---------:|-----------:|---------:|\n")
+	for _, m := range r.Messages {
+		if m.Latency != nil {
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | %.3f | %.3f | %.3f | %.3f | %.3f |\n",
+				m.Type, m.Sent, m.Acked, m.Rejected, m.Latency.P50, m.Latency.P90, m.Latency.P99, m.Latency.P999, m.Latency.Max)
+		} else {
+			fmt.Fprintf(&b, "| %s | %d | %d | %d | - | - | - | - | - |\n", m.Type, m.Sent, m.Acked, m.Rejected)
+		}
+	}
+	if len(r.Feed) > 0 {
+		b.WriteString("\n## Order to market data latency\n\n")
+		b.WriteString("| Type | Published | Unpublished | p50 (ms) | p90 (ms) | p99 (ms) | p99.9 (ms) | max (ms) |\n")
+		b.WriteString("|------|----------:|------------:|---------:|---------:|---------:|-----------:|---------:|\n")
+		for _, f := range r.Feed {
+			if f.Latency != nil {
+				fmt.Fprintf(&b, "| %s | %d | %d | %.3f | %.3f | %.3f | %.3f | %.3f |\n",
+					f.Type, f.Published, f.Unpublished, f.Latency.P50, f.Latency.P90, f.Latency.P99, f.Latency.P999, f.Latency.Max)
+			} else {
+				fmt.Fprintf(&b, "| %s | %d | %d | - | - | - | - | - |\n", f.Type, f.Published, f.Unpublished)
+			}
+		}
+	}
+	if len(r.Violations) > 0 {
+		b.WriteString("\n## Execution report violations\n\n")
+		b.WriteString("| Violation | Count |\n")
+		b.WriteString("|-----------|------:|\n")
+		kinds := make([]string, 0, len(r.Violations))
+		for kind := range r.Violations {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		for _, kind := range kinds {
+			fmt.Fprintf(&b, "| %s | %d |\n", kind, r.Violations[kind])
+		}
+	}
+	if len(r.Positions) > 0 {
+		b.WriteString("\n## Positions\n\n")
+		b.WriteString("| Account | Symbol | Fills | Bought | Sold | Net | Notional | Buy VWAP | Sell VWAP |\n")
+		b.WriteString("|---------|--------|------:|-------:|-----:|----:|---------:|---------:|----------:|\n")
+		for _, p := range r.Positions {
+			fmt.Fprintf(&b, "| %s | %s | %d | %s | %s | %s | %s | %s | %s |\n",
+				p.Account, p.Symbol, p.Fills, p.BoughtQty, p.SoldQty, p.NetQty, p.Notional, p.BuyVWAP, p.SellVWAP)
+		}
+	}
+	if r.DropCopy != nil {
+		d := r.DropCopy
+		fmt.Fprintf(&b, "\n## Drop copy\n\n- Matched: %d\n- Late: %d\n- Missing: %d\n- Extra: %d\n", d.Matched, d.Late, d.Missing, d.Extra)
+		if d.Lag != nil {
+			fmt.Fprintf(&b, "- Lag (ms): p50 %.3f, p90 %.3f, p99 %.3f, p99.9 %.3f, max %.3f\n", d.Lag.P50, d.Lag.P90, d.Lag.P99, d.Lag.P999, d.Lag.Max)
+		}
+	}
+	for _, reconciliation := range r.Reconciliations {
+		fmt.Fprintf(&b, "\n## Reconciliation with %s\n\n- Venue fills: %d\n- Breaks: %d\n", reconciliation.Source, reconciliation.Trades, len(reconciliation.Breaks))
+		if len(reconciliation.Breaks) > 0 {
+			b.WriteString("\n| Account | Symbol | Fills | Venue fills | Bought | Venue bought | Sold | Venue sold | Notional | Venue notional |\n")
+			b.WriteString("|---------|--------|------:|------------:|-------:|-------------:|-----:|-----------:|---------:|---------------:|\n")
+			for _, p := range reconciliation.Breaks {
+				fmt.Fprintf(&b, "| %s | %s | %d | %d | %s | %s | %s | %s | %s | %s |\n",
+					p.Account, p.Symbol, p.Fills, p.VenueFills, p.BoughtQty, p.VenueBoughtQty, p.SoldQty, p.VenueSoldQty, p.Notional, p.VenueNotional)
+			}
+		}
+	}
+	return b.String()
+}
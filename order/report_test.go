@@ -0,0 +1,32 @@
+package order
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuildReportStopsWithGeneration(t *testing.T) {
+	resetRun()
+	stats.markStart()
+	for i := 0; i < 10; i++ {
+		stats.recordScheduled()
+		stats.recordSent(msgTypeNewOrderSingle, "XXX")
+	}
+	time.Sleep(20 * time.Millisecond)
+	MarkStop()
+	stop := stats.stop
+
+	// Shutdown cancels and reconciliation take time without moving the end of the run
+	time.Sleep(50 * time.Millisecond)
+	MarkStop()
+	report := BuildReport()
+	if !report.End.Equal(stop) {
+		t.Fatalf("report ends at %s, generation stopped at %s", report.End, stop)
+	}
+	if duration := stop.Sub(stats.start).Seconds(); report.Duration != duration {
+		t.Fatalf("duration %vs, expected %vs", report.Duration, duration)
+	}
+	if rate := 10 / report.Duration; report.TargetRate != rate || report.AchievedRate != rate {
+		t.Fatalf("target rate %v and achieved rate %v, expected %v", report.TargetRate, report.AchievedRate, rate)
+	}
+}
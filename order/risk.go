@@ -0,0 +1,316 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// Limits checked by the risk guard before each request.
+const (
+	riskOpenOrders  = "open_orders"
+	riskNotional    = "notional"
+	riskMessageRate = "message_rate"
+	riskPosition    = "net_position"
+	riskRejectRatio = "reject_ratio"
+)
+
+// riskMinAnswers is the number of answered requests below which the reject ratio
+// is not checked, so that the first rejects of a run don't halt it.
+const riskMinAnswers = 100
+
+// ErrRiskHalted is returned for the requests following a breach of the risk limits.
+var ErrRiskHalted = errors.New("generation is halted by the risk limits")
+
+var (
+	metricRiskBreaches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "order_gatling",
+			Name:      "risk_breaches_total",
+			Help:      "Number of risk limits breached, halting the generation",
+		},
+		[]string{"limit"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricRiskBreaches)
+}
+
+// RiskLimits are the safety rails of the run, a zero limit is not checked.
+type RiskLimits struct {
+	// MaxOpenOrders is the number of orders resting on the venue.
+	MaxOpenOrders int
+	// MaxNotional is the notional of an account in a symbol, traded or resting, including the order to send.
+	MaxNotional decimal.Decimal
+	// MaxMessageRate is the number of requests sent per second.
+	MaxMessageRate int
+	// MaxPosition is the net position of an account in a symbol, its resting orders on the
+	// side of the order to send and the order itself being filled.
+	MaxPosition decimal.Decimal
+	// MaxRejectRatio is the ratio of rejected requests among the answered ones.
+	MaxRejectRatio float64
+}
+
+func (l RiskLimits) Enabled() bool {
+	return l.MaxOpenOrders > 0 || l.MaxNotional.IsPositive() || l.MaxMessageRate > 0 || l.MaxPosition.IsPositive() || l.MaxRejectRatio > 0
+}
+
+func (l RiskLimits) Validate() error {
+	if l.MaxOpenOrders < 0 || l.MaxNotional.IsNegative() || l.MaxMessageRate < 0 || l.MaxPosition.IsNegative() || l.MaxRejectRatio < 0 {
+		return errors.New("risk limits can't be negative")
+	}
+	if l.MaxRejectRatio > 1 {
+		return errors.New("maximum reject ratio must be between 0 and 1")
+	}
+	return nil
+}
+
+// RiskBreach is the limit which halted the generation.
+type RiskBreach struct {
+	Limit   string          `json:"limit"`
+	Account string          `json:"account,omitempty"`
+	Symbol  string          `json:"symbol,omitempty"`
+	Value   decimal.Decimal `json:"value"`
+	Max     decimal.Decimal `json:"max"`
+	Time    time.Time       `json:"time"`
+}
+
+func (b *RiskBreach) Error() string {
+	if len(b.Account) > 0 {
+		return fmt.Sprintf("%s limit breached by %s in %s: %s above %s", b.Limit, b.Account, b.Symbol, b.Value, b.Max)
+	}
+	return fmt.Sprintf("%s limit breached: %s above %s", b.Limit, b.Value, b.Max)
+}
+
+// RiskGuard checks the requests of the workflows against the risk limits before
+// they are sent. The first breach halts the generation for the rest of the run:
+// the workflow mass cancels its orders and every later request is refused.
+type RiskGuard struct {
+	limits RiskLimits
+
+	// open holds the orders resting on the venue according to their execution reports.
+	open map[string]bool
+	// exposures holds the quantity left on the orders sent, by ClOrdID.
+	exposures map[string]exposure
+
+	// window is the beginning of the current second of the message rate.
+	window time.Time
+	sent   int
+
+	breach *RiskBreach
+	halted chan bool
+	lock   sync.Mutex
+}
+
+func NewRiskGuard(limits RiskLimits) (*RiskGuard, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	return &RiskGuard{
+		limits:    limits,
+		open:      make(map[string]bool),
+		exposures: make(map[string]exposure),
+		halted:    make(chan bool),
+	}, nil
+}
+
+// exposure is the quantity left on an order sent by the gatling. It counts in the
+// notional and position limits from the time the order is sent until it is filled,
+// cancelled, expired or rejected.
+type exposure struct {
+	key   positionKey
+	side  enum.Side
+	qty   decimal.Decimal
+	price decimal.Decimal
+}
+
+// Halted is closed once a limit is breached.
+func (g *RiskGuard) Halted() <-chan bool {
+	return g.halted
+}
+
+// Breach returns the limit which halted the generation, nil while none is breached.
+func (g *RiskGuard) Breach() *RiskBreach {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.breach
+}
+
+// recordExecutionReport follows the orders resting on the venue and the quantity
+// left on them. A replaced or cancelled order is closed along with the request
+// answered by the report.
+func (g *RiskGuard) recordExecutionReport(execReport executionreport.ExecutionReport) {
+	clOrdId, err := execReport.GetClOrdID()
+	if err != nil {
+		return
+	}
+	status, err := execReport.GetOrdStatus()
+	if err != nil {
+		return
+	}
+	execType, _ := execReport.GetExecType()
+	origClOrdId, _ := execReport.GetOrigClOrdID()
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if len(origClOrdId) > 0 && (execType == enum.ExecType_REPLACED || execType == enum.ExecType_CANCELED) {
+		delete(g.open, origClOrdId)
+		delete(g.exposures, origClOrdId)
+	}
+	switch status {
+	case enum.OrdStatus_PENDING_NEW, enum.OrdStatus_NEW, enum.OrdStatus_PARTIALLY_FILLED, enum.OrdStatus_REPLACED,
+		enum.OrdStatus_PENDING_REPLACE, enum.OrdStatus_PENDING_CANCEL:
+		g.open[clOrdId] = true
+		e, found := g.exposures[clOrdId]
+		if leavesQty, err := execReport.GetLeavesQty(); found && err == nil && (execType == enum.ExecType_TRADE || execType == enum.ExecType_REPLACED) {
+			e.qty = leavesQty
+			g.exposures[clOrdId] = e
+		}
+	default:
+		delete(g.open, clOrdId)
+		delete(g.exposures, clOrdId)
+	}
+}
+
+// forget releases the exposure of a request which was not sent or was answered
+// with an OrderCancelReject.
+func (g *RiskGuard) forget(clOrdId string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	delete(g.exposures, clOrdId)
+}
+
+// openExposure sums the notional and the bought and sold quantities left on the
+// orders of an account in a symbol, leaving out the order being replaced.
+// Must be called with the lock held.
+func (g *RiskGuard) openExposure(key positionKey, replaced string) (notional decimal.Decimal, bought decimal.Decimal, sold decimal.Decimal) {
+	for clOrdId, e := range g.exposures {
+		if e.key != key || clOrdId == replaced {
+			continue
+		}
+		notional = notional.Add(e.qty.Mul(e.price))
+		if e.side == enum.Side_BUY {
+			bought = bought.Add(e.qty)
+		} else {
+			sold = sold.Add(e.qty)
+		}
+	}
+	return notional, bought, sold
+}
+
+// check tells whether the request of the account in the symbol can be sent. Orders
+// without price, e.g. market orders, count in the notional at the reference price
+// of the model. Quotes and cancels are only checked for the message rate and
+// reject ratio. The breaching request gets the breach, the next ones ErrRiskHalted.
+// An order passing the checks counts in the exposure of the account until it is
+// answered, or forgotten when it can't be sent.
+func (g *RiskGuard) check(message quickfix.Messagable, account string, symbol string, prices PriceModel) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.breach != nil {
+		return ErrRiskHalted
+	}
+
+	now := time.Now()
+	if now.Sub(g.window) >= time.Second {
+		g.window = now
+		g.sent = 0
+	}
+	g.sent++
+	if g.limits.MaxMessageRate > 0 && g.sent > g.limits.MaxMessageRate {
+		return g.trip(&RiskBreach{Limit: riskMessageRate, Value: decimal.NewFromInt(int64(g.sent)), Max: decimal.NewFromInt(int64(g.limits.MaxMessageRate))})
+	}
+
+	if g.limits.MaxRejectRatio > 0 {
+		acked, rejected := stats.answers()
+		if answered := acked + rejected; answered >= riskMinAnswers {
+			ratio := float64(rejected) / float64(answered)
+			if ratio > g.limits.MaxRejectRatio {
+				return g.trip(&RiskBreach{Limit: riskRejectRatio, Value: decimal.NewFromFloat(ratio).Round(4), Max: decimal.NewFromFloat(g.limits.MaxRejectRatio)})
+			}
+		}
+	}
+
+	messageType := messageTypeOf(message)
+	if messageType != msgTypeNewOrderSingle && messageType != msgTypeOrderCancelReplaceRequest {
+		return nil
+	}
+	if messageType == msgTypeNewOrderSingle && g.limits.MaxOpenOrders > 0 && len(g.open) >= g.limits.MaxOpenOrders {
+		return g.trip(&RiskBreach{Limit: riskOpenOrders, Value: decimal.NewFromInt(int64(len(g.open) + 1)), Max: decimal.NewFromInt(int64(g.limits.MaxOpenOrders))})
+	}
+
+	if !g.limits.MaxNotional.IsPositive() && !g.limits.MaxPosition.IsPositive() {
+		return nil
+	}
+	body := &message.ToMessage().Body
+	var qty quickfix.FIXDecimal
+	if err := body.GetField(tag.OrderQty, &qty); err != nil {
+		return nil
+	}
+	side, err := body.GetString(tag.Side)
+	if err != nil {
+		return nil
+	}
+	clOrdId, _ := body.GetString(tag.ClOrdID)
+	origClOrdId, _ := body.GetString(tag.OrigClOrdID)
+	price := orderPrice(body, prices)
+	key := positionKey{account: account, symbol: symbol}
+	p := positions.get(account, symbol)
+	openNotional, openBought, openSold := g.openExposure(key, origClOrdId)
+	if g.limits.MaxNotional.IsPositive() {
+		notional := p.boughtNotional.Add(p.soldNotional).Add(openNotional).Add(qty.Decimal.Mul(price))
+		if notional.GreaterThan(g.limits.MaxNotional) {
+			return g.trip(&RiskBreach{Limit: riskNotional, Account: account, Symbol: symbol, Value: notional, Max: g.limits.MaxNotional})
+		}
+	}
+	if g.limits.MaxPosition.IsPositive() {
+		net := p.boughtQty.Sub(p.soldQty)
+		if enum.Side(side) == enum.Side_BUY {
+			net = net.Add(openBought).Add(qty.Decimal)
+		} else {
+			net = net.Sub(openSold).Sub(qty.Decimal)
+		}
+		if net.Abs().GreaterThan(g.limits.MaxPosition) {
+			return g.trip(&RiskBreach{Limit: riskPosition, Account: account, Symbol: symbol, Value: net, Max: g.limits.MaxPosition})
+		}
+	}
+	if len(clOrdId) > 0 {
+		g.exposures[clOrdId] = exposure{key: key, side: enum.Side(side), qty: qty.Decimal, price: price}
+	}
+	return nil
+}
+
+// trip halts the generation on a breach. Must be called with the lock held.
+func (g *RiskGuard) trip(breach *RiskBreach) error {
+	breach.Time = time.Now()
+	g.breach = breach
+	metricRiskBreaches.WithLabelValues(breach.Limit).Inc()
+	stats.recordRiskBreach(breach)
+	close(g.halted)
+	return breach
+}
+
+// orderPrice returns the limit price of an order, else its stop price, else the
+// reference price of the model rounded like the VWAPs. The model is not moved.
+func orderPrice(body *quickfix.Body, prices PriceModel) decimal.Decimal {
+	var price quickfix.FIXDecimal
+	if err := body.GetField(tag.Price, &price); err == nil {
+		return price.Decimal
+	}
+	if err := body.GetField(TagStopPx, &price); err == nil {
+		return price.Decimal
+	}
+	if prices == nil {
+		return decimal.Zero
+	}
+	return decimal.NewFromFloat(prices.Reference()).Round(vwapPrecision)
+}
@@ -0,0 +1,435 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/fix50sp2/newordersingle"
+	"github.com/quickfixgo/fix50sp2/ordercancelreplacerequest"
+	"github.com/quickfixgo/fix50sp2/ordercancelrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// resetRun forgets the statistics and positions recorded by previous tests.
+func resetRun() {
+	stats = newRunStats()
+	positions = newPositionBook()
+}
+
+// testOrders numbers the orders of the tests.
+var testOrders int
+
+func testOrder(side enum.Side, qty int64, price string) quickfix.Messagable {
+	testOrders++
+	order := newordersingle.New(
+		field.NewClOrdID(fmt.Sprintf("order-%d", testOrders)),
+		field.NewSide(side),
+		field.NewTransactTime(time.Now()),
+		field.NewOrdType(enum.OrdType_LIMIT),
+	)
+	order.Set(field.NewOrderQty(decimal.NewFromInt(qty), 0))
+	if len(price) > 0 {
+		order.Set(field.NewPrice(decimal.RequireFromString(price), 2))
+	} else {
+		order.Set(field.NewOrdType(enum.OrdType_MARKET))
+	}
+	return order
+}
+
+func testCancel() quickfix.Messagable {
+	return ordercancelrequest.New(
+		field.NewClOrdID("cancel"),
+		field.NewSide(enum.Side_BUY),
+		field.NewTransactTime(time.Now()),
+	)
+}
+
+func TestRiskGuardCheck(t *testing.T) {
+	buy := func(qty int64, price string) quickfix.Messagable { return testOrder(enum.Side_BUY, qty, price) }
+	sell := func(qty int64, price string) quickfix.Messagable { return testOrder(enum.Side_SELL, qty, price) }
+	answers := func(acked int, rejected int) func(g *RiskGuard) {
+		return func(g *RiskGuard) {
+			for i := 0; i < acked; i++ {
+				stats.recordAck(msgTypeNewOrderSingle, "XXX")
+			}
+			for i := 0; i < rejected; i++ {
+				stats.recordReject(msgTypeNewOrderSingle, "XXX")
+			}
+		}
+	}
+	filled := func(side enum.Side, qty int64, price int64) func(g *RiskGuard) {
+		return func(g *RiskGuard) {
+			positions.add("A1", "XXX", side, decimal.NewFromInt(qty), decimal.NewFromInt(price))
+		}
+	}
+
+	tests := []struct {
+		name     string
+		limits   RiskLimits
+		setup    func(g *RiskGuard)
+		messages []quickfix.Messagable
+		// breachAt is the index of the message breaching the limit, -1 when none does.
+		breachAt int
+		limit    string
+		value    string
+	}{
+		{
+			name:     "no limit",
+			messages: []quickfix.Messagable{buy(1000000, "100"), testCancel()},
+			breachAt: -1,
+		},
+		{
+			name:     "message rate within the limit",
+			limits:   RiskLimits{MaxMessageRate: 3},
+			messages: []quickfix.Messagable{buy(1, "100"), testCancel(), buy(1, "100")},
+			breachAt: -1,
+		},
+		{
+			name:     "message rate counts cancels",
+			limits:   RiskLimits{MaxMessageRate: 2},
+			messages: []quickfix.Messagable{buy(1, "100"), testCancel(), testCancel()},
+			breachAt: 2,
+			limit:    riskMessageRate,
+			value:    "3",
+		},
+		{
+			name:     "open orders on a new order",
+			limits:   RiskLimits{MaxOpenOrders: 2},
+			setup:    func(g *RiskGuard) { g.open["1"], g.open["2"] = true, true },
+			messages: []quickfix.Messagable{testCancel(), buy(1, "100")},
+			breachAt: 1,
+			limit:    riskOpenOrders,
+			value:    "3",
+		},
+		{
+			name:     "open orders below the limit",
+			limits:   RiskLimits{MaxOpenOrders: 2},
+			setup:    func(g *RiskGuard) { g.open["1"] = true },
+			messages: []quickfix.Messagable{buy(1, "100")},
+			breachAt: -1,
+		},
+		{
+			name:     "notional including the order",
+			limits:   RiskLimits{MaxNotional: decimal.NewFromInt(1500)},
+			setup:    filled(enum.Side_BUY, 10, 100),
+			messages: []quickfix.Messagable{buy(10, "60")},
+			breachAt: 0,
+			limit:    riskNotional,
+			value:    "1600",
+		},
+		{
+			name:     "notional including resting orders",
+			limits:   RiskLimits{MaxNotional: decimal.NewFromInt(1500)},
+			setup:    filled(enum.Side_BUY, 10, 100),
+			messages: []quickfix.Messagable{sell(10, "40"), buy(1, "60"), buy(1, "60")},
+			breachAt: 2,
+			limit:    riskNotional,
+			value:    "1520",
+		},
+		{
+			name:     "notional of a market order at the reference price",
+			limits:   RiskLimits{MaxNotional: decimal.NewFromInt(1500)},
+			setup:    filled(enum.Side_BUY, 10, 100),
+			messages: []quickfix.Messagable{buy(4, ""), buy(2, "")},
+			breachAt: 1,
+			limit:    riskNotional,
+			value:    "1600",
+		},
+		{
+			name:     "net position were the order filled",
+			limits:   RiskLimits{MaxPosition: decimal.NewFromInt(15)},
+			setup:    filled(enum.Side_BUY, 10, 100),
+			messages: []quickfix.Messagable{sell(25, "100"), buy(10, "100")},
+			breachAt: 1,
+			limit:    riskPosition,
+			value:    "20",
+		},
+		{
+			name:     "net position including resting orders on the side",
+			limits:   RiskLimits{MaxPosition: decimal.NewFromInt(15)},
+			messages: []quickfix.Messagable{buy(8, "100"), sell(15, "100"), buy(7, "100"), buy(1, "100")},
+			breachAt: 3,
+			limit:    riskPosition,
+			value:    "16",
+		},
+		{
+			name:     "net position short",
+			limits:   RiskLimits{MaxPosition: decimal.NewFromInt(15)},
+			setup:    filled(enum.Side_BUY, 10, 100),
+			messages: []quickfix.Messagable{sell(26, "100")},
+			breachAt: 0,
+			limit:    riskPosition,
+			value:    "-16",
+		},
+		{
+			name:     "reject ratio before enough answers",
+			limits:   RiskLimits{MaxRejectRatio: 0.2},
+			setup:    answers(49, 50),
+			messages: []quickfix.Messagable{buy(1, "100")},
+			breachAt: -1,
+		},
+		{
+			name:     "reject ratio checked on cancels",
+			limits:   RiskLimits{MaxRejectRatio: 0.2},
+			setup:    answers(70, 30),
+			messages: []quickfix.Messagable{testCancel()},
+			breachAt: 0,
+			limit:    riskRejectRatio,
+			value:    "0.3",
+		},
+		{
+			name:     "reject ratio at the limit",
+			limits:   RiskLimits{MaxRejectRatio: 0.2},
+			setup:    answers(80, 20),
+			messages: []quickfix.Messagable{buy(1, "100")},
+			breachAt: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resetRun()
+			guard, err := NewRiskGuard(test.limits)
+			if err != nil {
+				t.Fatalf("NewRiskGuard: %v", err)
+			}
+			if test.setup != nil {
+				test.setup(guard)
+			}
+			for i, message := range test.messages {
+				err := guard.check(message, "A1", "XXX", NewUniformPrice(100, 0))
+				switch {
+				case i < test.breachAt || test.breachAt < 0:
+					if err != nil {
+						t.Fatalf("message %d: unexpected error %v", i, err)
+					}
+				case i == test.breachAt:
+					var breach *RiskBreach
+					if !errors.As(err, &breach) {
+						t.Fatalf("message %d: expected a breach, got %v", i, err)
+					}
+					if breach.Limit != test.limit || breach.Value.String() != test.value {
+						t.Fatalf("message %d: breach of %s at %s, expected %s at %s", i, breach.Limit, breach.Value, test.limit, test.value)
+					}
+				default:
+					if !errors.Is(err, ErrRiskHalted) {
+						t.Fatalf("message %d: expected ErrRiskHalted, got %v", i, err)
+					}
+				}
+			}
+			select {
+			case <-guard.Halted():
+				if test.breachAt < 0 {
+					t.Fatal("guard halted without breach")
+				}
+				if !errors.Is(guard.check(testCancel(), "A1", "XXX", nil), ErrRiskHalted) {
+					t.Fatal("requests are not refused after the breach")
+				}
+			default:
+				if test.breachAt >= 0 {
+					t.Fatal("guard not halted after the breach")
+				}
+			}
+		})
+	}
+}
+
+func TestRiskGuardOpenOrders(t *testing.T) {
+	report := func(clOrdId string, origClOrdId string, execType enum.ExecType, status enum.OrdStatus) executionreport.ExecutionReport {
+		er := executionreport.New(
+			field.NewOrderID("O1"),
+			field.NewExecID(clOrdId+string(execType)),
+			field.NewExecType(execType),
+			field.NewOrdStatus(status),
+			field.NewSide(enum.Side_BUY),
+			field.NewLeavesQty(decimal.NewFromInt(1), 0),
+			field.NewCumQty(decimal.Zero, 0),
+		)
+		er.SetClOrdID(clOrdId)
+		if len(origClOrdId) > 0 {
+			er.SetOrigClOrdID(origClOrdId)
+		}
+		return er
+	}
+
+	guard, err := NewRiskGuard(RiskLimits{MaxOpenOrders: 10})
+	if err != nil {
+		t.Fatalf("NewRiskGuard: %v", err)
+	}
+	steps := []struct {
+		report executionreport.ExecutionReport
+		open   int
+	}{
+		{report("1", "", enum.ExecType_NEW, enum.OrdStatus_NEW), 1},
+		{report("2", "", enum.ExecType_PENDING_NEW, enum.OrdStatus_PENDING_NEW), 2},
+		{report("2", "", enum.ExecType_NEW, enum.OrdStatus_NEW), 2},
+		{report("3", "1", enum.ExecType_REPLACED, enum.OrdStatus_REPLACED), 2},
+		{report("3", "", enum.ExecType_TRADE, enum.OrdStatus_PARTIALLY_FILLED), 2},
+		{report("4", "3", enum.ExecType_CANCELED, enum.OrdStatus_CANCELED), 1},
+		{report("2", "", enum.ExecType_TRADE, enum.OrdStatus_FILLED), 0},
+		{report("5", "", enum.ExecType_REJECTED, enum.OrdStatus_REJECTED), 0},
+	}
+	for i, step := range steps {
+		guard.recordExecutionReport(step.report)
+		if len(guard.open) != step.open {
+			t.Fatalf("step %d: %d open orders, expected %d", i, len(guard.open), step.open)
+		}
+	}
+}
+
+// TestRejectsHaltManager sends new orders which are all rejected, each reject
+// freeing the handler to send another order until the reject ratio is breached.
+func TestRejectsHaltManager(t *testing.T) {
+	resetRun()
+	m, app, handler := newTestManager(t)
+	guard, err := NewRiskGuard(RiskLimits{MaxRejectRatio: 0.5})
+	if err != nil {
+		t.Fatalf("NewRiskGuard: %v", err)
+	}
+	m.pool.EnforceRiskLimits(guard)
+	// The session is not registered in quickfix, sends fail once the checks pass
+	_ = m.sendOrderRequest(handler)
+
+	var breach *RiskBreach
+	rejects := 0
+	for ; rejects < 2*riskMinAnswers && breach == nil; rejects++ {
+		clOrdId := handler.GetLastOrderId()
+		if len(clOrdId) == 0 {
+			t.Fatalf("handler stopped after %d rejects", rejects)
+		}
+		err := m.processExecutionReport(app, testExecutionReport(clOrdId, enum.OrdStatus_REJECTED))
+		errors.As(err, &breach)
+	}
+	if breach == nil || breach.Limit != riskRejectRatio {
+		t.Fatalf("reject ratio not breached after %d rejects: %v", rejects, breach)
+	}
+	if rejects != riskMinAnswers {
+		t.Fatalf("breached after %d rejects, expected %d", rejects, riskMinAnswers)
+	}
+	if handler.massCancels != 1 {
+		t.Fatalf("%d mass cancels sent, expected 1", handler.massCancels)
+	}
+	if !m.State().Paused {
+		t.Fatal("generation not halted")
+	}
+	if err := m.sendOrderRequest(handler); !errors.Is(err, ErrRiskHalted) {
+		t.Fatalf("expected ErrRiskHalted, got %v", err)
+	}
+	if handler.massCancels != 1 {
+		t.Fatalf("%d mass cancels sent after the breach, expected 1", handler.massCancels)
+	}
+}
+
+func TestRiskGuardKeepsPricePath(t *testing.T) {
+	resetRun()
+	guard, err := NewRiskGuard(RiskLimits{MaxNotional: decimal.NewFromInt(1000000)})
+	if err != nil {
+		t.Fatalf("NewRiskGuard: %v", err)
+	}
+	prices, err := NewReplayPrice([]float64{100, 200, 300}, 0, 0)
+	if err != nil {
+		t.Fatalf("NewReplayPrice: %v", err)
+	}
+	if price := prices.Price(0); price != 100 {
+		t.Fatalf("first price %v", price)
+	}
+	prices.Advance()
+	for i := 0; i < 5; i++ {
+		if err := guard.check(testOrder(enum.Side_BUY, 1, ""), "A1", "XXX", prices); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if price := prices.Price(0); price != 200 {
+		t.Fatalf("checks of market orders moved the replay to %v", price)
+	}
+}
+
+// TestRiskGuardExposure follows the quantity left on the orders through their
+// execution reports: resting orders count until they are filled, cancelled or
+// rejected, and a replace takes over the exposure of the order it replaces.
+func TestRiskGuardExposure(t *testing.T) {
+	report := func(clOrdId string, origClOrdId string, execType enum.ExecType, status enum.OrdStatus, leavesQty int64) executionreport.ExecutionReport {
+		er := executionreport.New(
+			field.NewOrderID("O1"),
+			field.NewExecID(clOrdId+string(execType)+string(status)),
+			field.NewExecType(execType),
+			field.NewOrdStatus(status),
+			field.NewSide(enum.Side_BUY),
+			field.NewLeavesQty(decimal.NewFromInt(leavesQty), 0),
+			field.NewCumQty(decimal.Zero, 0),
+		)
+		er.SetClOrdID(clOrdId)
+		if len(origClOrdId) > 0 {
+			er.SetOrigClOrdID(origClOrdId)
+		}
+		return er
+	}
+	replace := func(clOrdId string, origClOrdId string, qty int64) quickfix.Messagable {
+		replace := ordercancelreplacerequest.New(field.NewClOrdID(clOrdId), field.NewSide(enum.Side_BUY), field.NewTransactTime(time.Now()), field.NewOrdType(enum.OrdType_LIMIT))
+		replace.Set(field.NewOrigClOrdID(origClOrdId))
+		replace.Set(field.NewOrderQty(decimal.NewFromInt(qty), 0))
+		replace.Set(field.NewPrice(decimal.NewFromInt(100), 0))
+		return replace
+	}
+	clOrdIdOf := func(message quickfix.Messagable) string {
+		clOrdId, _ := message.ToMessage().Body.GetString(tag.ClOrdID)
+		return clOrdId
+	}
+
+	resetRun()
+	guard, err := NewRiskGuard(RiskLimits{MaxNotional: decimal.NewFromInt(1000)})
+	if err != nil {
+		t.Fatalf("NewRiskGuard: %v", err)
+	}
+	check := func(message quickfix.Messagable) error {
+		return guard.check(message, "A1", "XXX", NewUniformPrice(100, 0))
+	}
+	exposed := func(step string, want int64) {
+		notional, _, _ := guard.openExposure(positionKey{account: "A1", symbol: "XXX"}, "")
+		if !notional.Equal(decimal.NewFromInt(want)) {
+			t.Fatalf("%s: %s exposed, expected %d", step, notional, want)
+		}
+	}
+
+	first, second := testOrder(enum.Side_BUY, 4, "100"), testOrder(enum.Side_BUY, 4, "100")
+	for _, message := range []quickfix.Messagable{first, second} {
+		if err := check(message); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	exposed("two orders sent", 800)
+
+	guard.recordExecutionReport(report(clOrdIdOf(first), "", enum.ExecType_NEW, enum.OrdStatus_NEW, 4))
+	guard.recordExecutionReport(report(clOrdIdOf(first), "", enum.ExecType_TRADE, enum.OrdStatus_PARTIALLY_FILLED, 1))
+	exposed("first order partially filled", 500)
+
+	if err := check(replace("R1", clOrdIdOf(second), 6)); err != nil {
+		t.Fatalf("replace of a resting order: unexpected error %v", err)
+	}
+	guard.forget("R1")
+	exposed("replace rejected", 500)
+
+	if err := check(replace("R2", clOrdIdOf(second), 6)); err != nil {
+		t.Fatalf("replace of a resting order: unexpected error %v", err)
+	}
+	guard.recordExecutionReport(report("R2", clOrdIdOf(second), enum.ExecType_REPLACED, enum.OrdStatus_NEW, 6))
+	exposed("second order replaced", 700)
+
+	guard.recordExecutionReport(report("C1", clOrdIdOf(first), enum.ExecType_CANCELED, enum.OrdStatus_CANCELED, 0))
+	guard.recordExecutionReport(report("R2", "", enum.ExecType_EXPIRED, enum.OrdStatus_EXPIRED, 0))
+	exposed("orders cancelled and expired", 0)
+
+	rejected := testOrder(enum.Side_BUY, 10, "100")
+	if err := check(rejected); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	guard.recordExecutionReport(report(clOrdIdOf(rejected), "", enum.ExecType_REJECTED, enum.OrdStatus_REJECTED, 0))
+	exposed("order rejected", 0)
+}
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/quickfixgo/enum"
@@ -14,156 +16,323 @@ import (
 	"github.com/quickfixgo/quickfix"
 )
 
+type sampledOrder struct {
+	timestamp time.Time
+	symbol    string
+	app       *SenderApp
+}
+
 type SampledManager struct {
 	context            context.Context
-	app                *SenderApp
+	pool               *SessionPool
 	accounts           []string
+	parties            map[string][]Party
 	symbols            []string
-	refPrices          []float64
-	nbOrderPerSec      uint
-	ordersTimestampMap map[string]time.Time
+	instruments        map[string]*Instrument
+	prices             map[string]PriceModel
+	mix                *OrderMix
+	scheduler          *Scheduler
+	ordersTimestampMap map[string]sampledOrder
+	openOrders         map[string]bool
 	orderLock          sync.Mutex
+	paused             atomic.Bool
 	Closed             chan bool
 }
 
 func NewSampledManager(
 	context context.Context,
-	app *SenderApp,
+	pool *SessionPool,
 	accounts []string,
+	parties map[string][]Party,
 	symbols []string,
-	refPrices []float64,
-	nbOrderPerSec uint) *SampledManager {
+	instruments map[string]*Instrument,
+	prices map[string]PriceModel,
+	mix *OrderMix,
+	scheduler *Scheduler) *SampledManager {
 	mgr := &SampledManager{
 		context:            context,
-		app:                app,
+		pool:               pool,
 		accounts:           accounts,
+		parties:            parties,
 		symbols:            symbols,
-		refPrices:          refPrices,
-		nbOrderPerSec:      nbOrderPerSec,
-		ordersTimestampMap: make(map[string]time.Time),
+		instruments:        instruments,
+		prices:             prices,
+		mix:                mix,
+		scheduler:          scheduler,
+		ordersTimestampMap: make(map[string]sampledOrder),
+		openOrders:         make(map[string]bool),
 		orderLock:          sync.Mutex{},
 		Closed:             make(chan bool),
 	}
 
-	go mgr.processExecutionReports()
+	var wg sync.WaitGroup
+	for _, app := range pool.Apps() {
+		wg.Add(1)
+		go func(app *SenderApp) {
+			defer wg.Done()
+			mgr.processExecutionReports(app)
+		}(app)
+	}
+	go func() {
+		wg.Wait()
+		close(mgr.Closed)
+	}()
 	return mgr
 }
 
-func (m *SampledManager) getOrderTimestamp(id string) (time.Time, bool) {
+func (m *SampledManager) getOrder(id string) (sampledOrder, bool) {
 	m.orderLock.Lock()
 	defer m.orderLock.Unlock()
-	ts, found := m.ordersTimestampMap[id]
+	order, found := m.ordersTimestampMap[id]
 	if found {
 		delete(m.ordersTimestampMap, id)
 	}
-	return ts, found
+	return order, found
+}
+
+func (m *SampledManager) setOrder(id string, order sampledOrder) {
+	m.orderLock.Lock()
+	defer m.orderLock.Unlock()
+	m.ordersTimestampMap[id] = order
+}
+
+// trackOpenOrder keeps the orders resting on the venue according to their last status.
+func (m *SampledManager) trackOpenOrder(id string, status enum.OrdStatus) {
+	m.orderLock.Lock()
+	defer m.orderLock.Unlock()
+	switch status {
+	case enum.OrdStatus_NEW, enum.OrdStatus_PARTIALLY_FILLED:
+		m.openOrders[id] = true
+	default:
+		delete(m.openOrders, id)
+	}
 }
 
-func (m *SampledManager) setOrderTimestamp(id string) {
+// forgetSession drops the orders in flight on a session, their acknowledgements were lost.
+func (m *SampledManager) forgetSession(app *SenderApp) {
 	m.orderLock.Lock()
 	defer m.orderLock.Unlock()
-	m.ordersTimestampMap[id] = time.Now()
+	for id, order := range m.ordersTimestampMap {
+		if order.app == app {
+			delete(m.ordersTimestampMap, id)
+		}
+	}
 }
 
 func (m *SampledManager) CancelAllOrders() {
 	for _, account := range m.accounts {
-		for _, symbol := range m.symbols {
-			massCancel := BuildMassCancelRequest(enum.Side_BUY, symbol, account)
-			err := quickfix.SendToTarget(massCancel, m.app.sessionId)
-			if err != nil {
-				m.app.Logger.Err(err).Str("account", account).Str("symbol", symbol).Any("side", "buy").Msg("Cannot send mass cancel request")
-			}
-			massCancel = BuildMassCancelRequest(enum.Side_SELL, symbol, account)
-			err = quickfix.SendToTarget(massCancel, m.app.sessionId)
-			if err != nil {
-				m.app.Logger.Err(err).Str("account", account).Str("symbol", symbol).Any("side", "sell").Msg("Cannot send mass cancel request")
+		for _, app := range m.pool.SessionsOf(account) {
+			for _, symbol := range m.symbols {
+				massCancel := BuildMassCancelRequest(enum.Side_BUY, symbol, account, m.parties[account])
+				err := quickfix.SendToTarget(massCancel, app.sessionId)
+				if err != nil {
+					app.Logger.Err(err).Str("account", account).Str("symbol", symbol).Any("side", "buy").Msg("Cannot send mass cancel request")
+				} else {
+					stats.recordSent(msgTypeOrderMassCancelRequest, symbol)
+				}
+				massCancel = BuildMassCancelRequest(enum.Side_SELL, symbol, account, m.parties[account])
+				err = quickfix.SendToTarget(massCancel, app.sessionId)
+				if err != nil {
+					app.Logger.Err(err).Str("account", account).Str("symbol", symbol).Any("side", "sell").Msg("Cannot send mass cancel request")
+				} else {
+					stats.recordSent(msgTypeOrderMassCancelRequest, symbol)
+				}
 			}
 		}
 	}
 }
 
+func (m *SampledManager) Done() <-chan bool {
+	return m.Closed
+}
+
+// Pause stops sending new orders, the schedule goes on and orders due meanwhile are skipped.
+func (m *SampledManager) Pause() {
+	m.paused.Store(true)
+}
+
+func (m *SampledManager) Resume() {
+	m.paused.Store(false)
+}
+
+// SetRate replaces the rate profile by a constant rate.
+func (m *SampledManager) SetRate(rate float64) {
+	m.scheduler.SetProfile(NewConstantRate(rate))
+}
+
+func (m *SampledManager) State() WorkflowState {
+	m.orderLock.Lock()
+	defer m.orderLock.Unlock()
+	return WorkflowState{
+		Workflow:   WorkflowSampled,
+		Paused:     m.paused.Load(),
+		OpenOrders: len(m.openOrders),
+		InFlight:   len(m.ordersTimestampMap),
+		Rate:       m.scheduler.Rate(),
+	}
+}
+
 func (m *SampledManager) Start() {
-	interval := 1000000 / m.nbOrderPerSec
-	tick := time.After(time.Duration(interval) * time.Microsecond)
+	stats.markStart()
+	m.scheduler.Start(time.Now())
 	go func() {
 
 		for {
+			intended, due := m.scheduler.Next()
 			select {
-			case <-tick:
-				err := m.sendOrderRequest()
-				if err != nil {
-					m.app.Logger.Err(err).Msg("Stopping order sending routine")
+			case <-time.After(time.Until(intended)):
+				if !due || m.paused.Load() {
+					continue
+				}
+				stats.recordScheduled()
+				metricScheduleLag.Observe(time.Since(intended).Seconds())
+				// Send errors are logged by the request, only a risk breach stops the generation
+				var breach *RiskBreach
+				if err := m.sendOrderRequest(intended); errors.Is(err, ErrRiskHalted) || errors.As(err, &breach) {
+					m.pool.Logger().Err(err).Msg("Stopping order sending routine")
 					return
 				}
-				tick = time.After(time.Duration(interval) * time.Microsecond)
 
 			case <-m.context.Done():
-				m.app.Logger.Error().Err(m.context.Err()).Msg("Sampled order manager creation routine is stopping")
-				m.Closed <- true
+				m.pool.Logger().Error().Err(m.context.Err()).Msg("Sampled order manager creation routine is stopping")
 				return
 			}
 		}
 	}()
 }
 
-func (m *SampledManager) sendOrderRequest() error {
-	refPrice := m.refPrices[rand.Intn(len(m.refPrices))]
+// sendOrderRequest sends a new order single. Its roundtrip is measured from the
+// intended send time so that delays of the sender itself are accounted for.
+func (m *SampledManager) sendOrderRequest(intended time.Time) error {
 	symbol := m.symbols[rand.Intn(len(m.symbols))]
+	instrument := m.instruments[symbol]
 	account := m.accounts[rand.Intn(len(m.accounts))]
+	app := m.pool.Assign(account)
+	if !app.IsConnected() {
+		// Generation is paused while the session is logged out
+		app.Logger.Trace().Str("session", app.Session()).Msg("Session logged out, order skipped")
+		return nil
+	}
 	var order quickfix.Messagable
 	var clOrdId string
 	switch rand.Intn(2) {
 	case 0:
-		order, clOrdId = buildNewOrderSingle(enum.Side_BUY, refPrice-0.10, symbol, account)
+		order, clOrdId = buildNewOrderSingle(enum.Side_BUY, instrument, m.mix.draw(), m.prices[symbol], -instrument.Ticks(10), account, m.parties[account])
 	case 1:
-		order, clOrdId = buildNewOrderSingle(enum.Side_SELL, refPrice+0.10, symbol, account)
+		order, clOrdId = buildNewOrderSingle(enum.Side_SELL, instrument, m.mix.draw(), m.prices[symbol], instrument.Ticks(10), account, m.parties[account])
 	default:
 		return errors.New("invalid side")
 	}
-	m.setOrderTimestamp(clOrdId)
-	err := quickfix.SendToTarget(order, m.app.sessionId)
+	// The price model only moves once the order is sent
+	if err := m.pool.checkRisk(order, account, symbol, m.prices[symbol]); err != nil {
+		m.halt(err)
+		return err
+	}
+	m.setOrder(clOrdId, sampledOrder{timestamp: intended, symbol: symbol, app: app})
+	err := quickfix.SendToTarget(order, app.sessionId)
 	if err != nil {
-		m.app.Logger.Err(err).Str("account", account).Str("symbol", symbol).Msg("Cannot send new order single request")
+		m.getOrder(clOrdId)
+		m.pool.forgetRisk(clOrdId)
+		app.Logger.Err(err).Str("account", account).Str("symbol", symbol).Msg("Cannot send new order single request")
 		return errors.New("cannot send new order single request")
 	}
+	m.prices[symbol].Advance()
+	stats.recordSent(msgTypeNewOrderSingle, symbol)
 	return nil
 }
 
-func (m *SampledManager) processExecutionReports() {
+// halt mass cancels the orders and stops generation when a request breached a risk limit.
+func (m *SampledManager) halt(err error) {
+	var breach *RiskBreach
+	if !errors.As(err, &breach) {
+		return
+	}
+	m.pool.Logger().Error().Err(err).Msg("Risk limit breached, cancelling all orders and halting generation")
+	m.Pause()
+	m.CancelAllOrders()
+}
+
+func (m *SampledManager) processExecutionReports(app *SenderApp) {
 LOOP:
 	for {
 		select {
-		case msg, ok := <-m.app.ExecReportNotification:
+		case msg, ok := <-app.ExecReportNotification:
 			if !ok {
 				break LOOP
 			}
-			if err := m.processExecutionReport(msg); err != nil {
-				m.app.Logger.Error().Err(err).Any("msg", strings.Replace(msg.Message.String(), "\001", "|", -1)).Msg("Cannot process fix message")
+			if err := m.processExecutionReport(app, msg); err != nil {
+				app.Logger.Error().Err(err).Any("msg", strings.Replace(msg.Message.String(), "\001", "|", -1)).Msg("Cannot process fix message")
+			}
+
+		case _, ok := <-app.OrderCancelRejectNotification:
+			// New orders are never amended, there is nothing to do
+			if !ok {
+				break LOOP
 			}
 
+		case <-app.ReconnectNotification:
+			m.resumeSession(app)
+
 		case <-m.context.Done():
-			m.app.Logger.Error().Err(m.context.Err()).Msg("Sampled order manager is stopping")
-			m.Closed <- true
+			app.Logger.Error().Err(m.context.Err()).Str("session", app.Session()).Msg("Sampled order manager is stopping")
 			return
 		}
 	}
 }
 
-func (m *SampledManager) processExecutionReport(execReport executionreport.ExecutionReport) error {
+// resumeSession forgets the orders in flight on a session which logged on again,
+// and mass cancels the orders of its accounts when asked to restart.
+func (m *SampledManager) resumeSession(app *SenderApp) {
+	restart := m.pool.restartOnReconnect()
+	app.Logger.Warn().Str("session", app.Session()).Bool("restart", restart).Msg("Session logged on again, resuming orders")
+	m.forgetSession(app)
+	if !restart {
+		return
+	}
+	for _, account := range m.accounts {
+		if !slices.Contains(m.pool.SessionsOf(account), app) {
+			continue
+		}
+		for _, symbol := range m.symbols {
+			for _, side := range []enum.Side{enum.Side_BUY, enum.Side_SELL} {
+				massCancel := BuildMassCancelRequest(side, symbol, account, m.parties[account])
+				if err := quickfix.SendToTarget(massCancel, app.sessionId); err != nil {
+					app.Logger.Err(err).Str("account", account).Str("symbol", symbol).Any("side", side).Msg("Cannot send mass cancel request")
+				} else {
+					stats.recordSent(msgTypeOrderMassCancelRequest, symbol)
+				}
+			}
+		}
+	}
+}
+
+func (m *SampledManager) processExecutionReport(app *SenderApp, execReport executionreport.ExecutionReport) error {
 	clOrdId, err := execReport.GetClOrdID()
 	if err != nil {
 		return errors.New("missing ClOrdID in ExecutionReport")
 	}
-	ts, found := m.getOrderTimestamp(clOrdId)
-	if !found {
-		m.app.Logger.Trace().Str("clOrdId", clOrdId).Msg("Order not found")
-		return nil
-	}
-	metricOrderRoundtrip.WithLabelValues("NewOrderSingle").Observe(time.Since(ts).Seconds())
 	status, err := execReport.GetOrdStatus()
 	if err != nil {
 		return errors.New("missing OrdStatus in ExecutionReport")
 	}
+	m.trackOpenOrder(clOrdId, status)
+	if status == enum.OrdStatus_PENDING_NEW {
+		// The order is answered by the report following the pending one
+		return nil
+	}
+	order, found := m.getOrder(clOrdId)
+	if !found {
+		app.Logger.Trace().Str("clOrdId", clOrdId).Msg("Order not found")
+		return nil
+	}
+	latency := time.Since(order.timestamp)
+	metricOrderRoundtrip.WithLabelValues(msgTypeNewOrderSingle, app.Session()).Observe(latency.Seconds())
+	stats.recordLatency(msgTypeNewOrderSingle, order.symbol, latency)
+	if status == enum.OrdStatus_REJECTED {
+		stats.recordReject(msgTypeNewOrderSingle, order.symbol)
+	} else {
+		stats.recordAck(msgTypeNewOrderSingle, order.symbol)
+	}
 	switch status {
 	case enum.OrdStatus_NEW:
 		return nil
@@ -173,6 +342,9 @@ func (m *SampledManager) processExecutionReport(execReport executionreport.Execu
 		return nil
 	case enum.OrdStatus_REJECTED:
 		return nil
+	case enum.OrdStatus_CANCELED, enum.OrdStatus_EXPIRED:
+		// Remainder of a market, immediate or cancel or fill or kill order, or expired order
+		return nil
 	default:
 		return fmt.Errorf("order status not handled: %v", status)
 	}
@@ -0,0 +1,247 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Phase is one step of a scenario. Before the phase starts, orders of the previous
+// phase can be mass cancelled and the run can wait for a while.
+type Phase struct {
+	Name         string        `mapstructure:"name"`
+	Workflow     string        `mapstructure:"workflow"`
+	Duration     time.Duration `mapstructure:"duration"`
+	Rate         float64       `mapstructure:"rate"`
+	RateProfile  string        `mapstructure:"rate-profile"`
+	Arrival      string        `mapstructure:"arrival"`
+	UpdateTempo  time.Duration `mapstructure:"update-tempo"`
+	Symbols      []string      `mapstructure:"symbols"`
+	RefPrices    []float64     `mapstructure:"refprices"`
+	PriceModels  []string      `mapstructure:"price-models"`
+	Instruments  []string      `mapstructure:"instruments"`
+	Accounts     []string      `mapstructure:"accounts"`
+	Parties      []string      `mapstructure:"parties"`
+	OrderTypes   string        `mapstructure:"order-types"`
+	TimeInForce  string        `mapstructure:"time-in-force"`
+	ExpireAfter  time.Duration `mapstructure:"expire-after"`
+	Iceberg      string        `mapstructure:"iceberg"`
+	MinQty       string        `mapstructure:"min-qty"`
+	OrderActions string        `mapstructure:"order-actions"`
+	MassCancel   bool          `mapstructure:"mass-cancel"`
+	Wait         time.Duration `mapstructure:"wait"`
+}
+
+type Scenario struct {
+	Phases []Phase `mapstructure:"phases"`
+
+	// market, when set, is followed by the prices of every phase.
+	market *MarketData
+	follow string
+}
+
+// FollowMarket makes the prices of every phase follow the market data.
+func (s *Scenario) FollowMarket(market *MarketData, follow string) {
+	s.market = market
+	s.follow = follow
+}
+
+// Symbols returns the symbols of all the phases.
+func (s *Scenario) Symbols() []string {
+	seen := make(map[string]bool)
+	symbols := make([]string, 0)
+	for _, phase := range s.Phases {
+		for _, symbol := range phase.Symbols {
+			if !seen[symbol] {
+				seen[symbol] = true
+				symbols = append(symbols, symbol)
+			}
+		}
+	}
+	return symbols
+}
+
+func (p *Phase) Validate() error {
+	switch p.Workflow {
+	case WorkflowAmend, WorkflowQuote:
+	case WorkflowSampled:
+		if p.Rate <= 0 && len(p.RateProfile) == 0 {
+			return fmt.Errorf("phase %s: sampled workflow needs a rate or a rate profile", p.Name)
+		}
+		if p.Rate > 0 && len(p.RateProfile) > 0 {
+			return fmt.Errorf("phase %s: rate and rate profile are mutually exclusive", p.Name)
+		}
+		if _, err := p.scheduler(); err != nil {
+			return fmt.Errorf("phase %s: %w", p.Name, err)
+		}
+	default:
+		return fmt.Errorf("phase %s: unknown workflow %q", p.Name, p.Workflow)
+	}
+	if len(p.Symbols) == 0 {
+		return fmt.Errorf("phase %s: missing symbol list", p.Name)
+	}
+	if len(p.Symbols) != len(p.RefPrices) {
+		return fmt.Errorf("phase %s: number of symbols must match number of reference prices", p.Name)
+	}
+	if len(p.Accounts) == 0 {
+		return fmt.Errorf("phase %s: missing account list", p.Name)
+	}
+	if _, err := NewPriceModels(p.Symbols, p.RefPrices, p.PriceModels); err != nil {
+		return fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	instruments, err := NewInstruments(p.Symbols, p.Instruments)
+	if err != nil {
+		return fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	if _, err := NewParties(p.Accounts, p.Parties); err != nil {
+		return fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	mix, err := p.orderMix()
+	if err != nil {
+		return fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	if err := mix.CheckInstruments(instruments); err != nil {
+		return fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+func (p *Phase) orderMix() (*OrderMix, error) {
+	mix, err := ParseOrderMix(p.OrderTypes, p.TimeInForce, p.ExpireAfter)
+	if err != nil {
+		return nil, err
+	}
+	if err := mix.SetIceberg(p.Iceberg); err != nil {
+		return nil, err
+	}
+	if err := mix.SetMinQty(p.MinQty); err != nil {
+		return nil, err
+	}
+	if err := mix.SetActions(p.OrderActions); err != nil {
+		return nil, err
+	}
+	return mix, nil
+}
+
+func (s *Scenario) Validate() error {
+	if len(s.Phases) == 0 {
+		return errors.New("scenario has no phase")
+	}
+	for i := range s.Phases {
+		if len(s.Phases[i].Name) == 0 {
+			s.Phases[i].Name = fmt.Sprintf("#%d", i+1)
+		}
+		if err := s.Phases[i].Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Phase) newWorkflow(ctx context.Context, pool *SessionPool, market *MarketData, follow string) (Workflow, error) {
+	prices, err := NewPriceModels(p.Symbols, p.RefPrices, p.PriceModels)
+	if err != nil {
+		return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	if market != nil {
+		prices, err = FollowMarket(prices, market, follow)
+		if err != nil {
+			return nil, err
+		}
+	}
+	instruments, err := NewInstruments(p.Symbols, p.Instruments)
+	if err != nil {
+		return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	parties, err := NewParties(p.Accounts, p.Parties)
+	if err != nil {
+		return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	mix, err := p.orderMix()
+	if err != nil {
+		return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	if err := mix.CheckInstruments(instruments); err != nil {
+		return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	if p.Workflow != WorkflowSampled {
+		return NewManager(ctx, pool, p.Accounts, parties, p.Symbols, instruments, prices, mix, p.Workflow == WorkflowQuote, p.UpdateTempo), nil
+	}
+
+	scheduler, err := p.scheduler()
+	if err != nil {
+		return nil, fmt.Errorf("phase %s: %w", p.Name, err)
+	}
+	return NewSampledManager(ctx, pool, p.Accounts, parties, p.Symbols, instruments, prices, mix, scheduler), nil
+}
+
+// scheduler builds the schedule of the sampled workflow from the rate or rate profile
+// of the phase, fixed arrivals being the default.
+func (p *Phase) scheduler() (*Scheduler, error) {
+	var rateProfile RateProfile = NewConstantRate(p.Rate)
+	if len(p.RateProfile) > 0 {
+		var err error
+		rateProfile, err = ParseRateProfile(p.RateProfile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	arrival := p.Arrival
+	if len(arrival) == 0 {
+		arrival = ArrivalFixed
+	}
+	return NewScheduler(rateProfile, arrival)
+}
+
+// Run plays the phases one after the other until the last one ends or the context is cancelled.
+// A phase without duration lasts until the context is cancelled. The workflow of the last
+// played phase is returned so its orders can be cancelled. The controller follows the
+// workflow of the running phase.
+func (s *Scenario) Run(ctx context.Context, pool *SessionPool, controller *Controller) (Workflow, error) {
+	var previous Workflow
+	for _, phase := range s.Phases {
+		phaseCtx, cancel := context.WithCancel(ctx)
+
+		workflow, err := phase.newWorkflow(phaseCtx, pool, s.market, s.follow)
+		if err != nil {
+			cancel()
+			return previous, err
+		}
+
+		if phase.MassCancel {
+			if previous != nil {
+				previous.CancelAllOrders()
+			} else {
+				workflow.CancelAllOrders()
+			}
+		}
+		if phase.Wait > 0 {
+			select {
+			case <-time.After(phase.Wait):
+			case <-ctx.Done():
+			}
+		}
+
+		pool.Logger().Info().Str("phase", phase.Name).Str("workflow", phase.Workflow).Dur("duration", phase.Duration).Msg("Starting scenario phase")
+		controller.Attach(pool, workflow)
+		workflow.Start()
+		if phase.Duration > 0 {
+			select {
+			case <-time.After(phase.Duration):
+			case <-ctx.Done():
+			}
+		} else {
+			<-ctx.Done()
+		}
+		cancel()
+		<-workflow.Done()
+		previous = workflow
+
+		if ctx.Err() != nil {
+			return previous, nil
+		}
+	}
+	pool.Logger().Info().Msg("Scenario is over")
+	return previous, nil
+}
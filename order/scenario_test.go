@@ -0,0 +1,43 @@
+package order
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPhaseValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		phase Phase
+		err   string
+	}{
+		{"amend", Phase{Workflow: WorkflowAmend}, ""},
+		{"sampled at a rate", Phase{Workflow: WorkflowSampled, Rate: 10}, ""},
+		{"sampled with a rate profile", Phase{Workflow: WorkflowSampled, RateProfile: "ramp:from=10,to=100,duration=1m"}, ""},
+		{"poisson arrivals", Phase{Workflow: WorkflowSampled, Rate: 10, Arrival: ArrivalPoisson}, ""},
+		{"unknown workflow", Phase{Workflow: "spray"}, "unknown workflow"},
+		{"sampled without rate", Phase{Workflow: WorkflowSampled}, "needs a rate"},
+		{"rate and rate profile", Phase{Workflow: WorkflowSampled, Rate: 10, RateProfile: "constant:rate=10"}, "mutually exclusive"},
+		{"invalid rate profile", Phase{Workflow: WorkflowSampled, RateProfile: "ramp:from=10"}, "phase p"},
+		{"unknown arrival process", Phase{Workflow: WorkflowSampled, Rate: 10, Arrival: "bursty"}, "unknown arrival process"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			phase := test.phase
+			phase.Name = "p"
+			phase.Symbols = []string{"XXX"}
+			phase.RefPrices = []float64{100}
+			phase.Accounts = []string{"A1"}
+			phase.OrderTypes = OrderLimit
+			phase.TimeInForce = TimeInForceDay
+			phase.ExpireAfter = DefaultExpireAfter
+			err := phase.Validate()
+			switch {
+			case len(test.err) == 0 && err != nil:
+				t.Fatalf("unexpected error %v", err)
+			case len(test.err) > 0 && (err == nil || !strings.Contains(err.Error(), test.err)):
+				t.Fatalf("expected error %q, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package order
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	ArrivalFixed   = "fixed"
+	ArrivalPoisson = "poisson"
+
+	rateIdlePollInterval = 100 * time.Millisecond
+)
+
+var (
+	metricScheduleLag = prometheus.NewSummary(
+		prometheus.SummaryOpts{
+			Subsystem: "order_gatling",
+			Name:      "schedule_lag_seconds_summary",
+			Help:      "Delay between the intended and the actual send time of new orders",
+			Objectives: map[float64]float64{
+				0.5:  0.05,
+				0.9:  0.05,
+				0.95: 0.01,
+				0.99: 0.005,
+			},
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(metricScheduleLag)
+}
+
+// Scheduler computes the intended send time of each order of an open-loop load.
+// Intended times only depend on the rate profile and never on how long it took
+// to send previous orders, so a slow sender does not hide queueing delay.
+type Scheduler struct {
+	profile RateProfile
+	poisson bool
+	start   time.Time
+	next    time.Time
+	lock    sync.Mutex
+}
+
+func NewScheduler(profile RateProfile, arrival string) (*Scheduler, error) {
+	switch arrival {
+	case ArrivalFixed, ArrivalPoisson:
+	default:
+		return nil, fmt.Errorf("unknown arrival process %q", arrival)
+	}
+	return &Scheduler{
+		profile: profile,
+		poisson: arrival == ArrivalPoisson,
+	}, nil
+}
+
+// Start sets the origin of the schedule.
+func (s *Scheduler) Start(start time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.start = start
+	s.next = start
+}
+
+// Next returns the intended time of the next event and whether an order must be
+// sent at that time. No order is due while the profile rate is zero, the rate is
+// then polled again periodically.
+func (s *Scheduler) Next() (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	rate := s.profile.Rate(s.next.Sub(s.start))
+	if rate <= 0 {
+		s.next = s.next.Add(rateIdlePollInterval)
+		return s.next, false
+	}
+	interval := 1 / rate
+	if s.poisson {
+		interval = rand.ExpFloat64() / rate
+	}
+	s.next = s.next.Add(time.Duration(interval * float64(time.Second)))
+	return s.next, true
+}
+
+// SetProfile replaces the rate profile from the next event on.
+func (s *Scheduler) SetProfile(profile RateProfile) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.profile = profile
+}
+
+// Rate returns the rate of the profile at the next event.
+func (s *Scheduler) Rate() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.profile.Rate(s.next.Sub(s.start))
+}
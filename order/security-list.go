@@ -0,0 +1,254 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/securitylist"
+	"github.com/quickfixgo/fix50sp2/securitylistrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// Fields of the security list giving the trading parameters of a security, which
+// are not part of the generated group.
+const (
+	tagRoundLot              quickfix.Tag = 561
+	tagMinTradeVol           quickfix.Tag = 562
+	tagMinPriceIncrement     quickfix.Tag = 969
+	tagMaxTradeVol           quickfix.Tag = 1140
+	tagTradingReferencePrice quickfix.Tag = 1150
+)
+
+// Security is a security listed by the venue. Trading parameters not given by
+// the venue are zero.
+type Security struct {
+	Symbol   string
+	TickSize decimal.Decimal
+	LotSize  decimal.Decimal
+	MinQty   decimal.Decimal
+	MaxQty   decimal.Decimal
+	RefPrice decimal.Decimal
+}
+
+// Instrument describes the trading parameters of the security in the format of
+// ParseInstrument, parameters not given by the venue being left to their default.
+// A maximum quantity below the default minimum quantity brings the minimum down
+// to keep the default range of lots, or at least one lot.
+func (s Security) Instrument() string {
+	minQty := s.MinQty
+	if !minQty.IsPositive() && s.MaxQty.IsPositive() {
+		lotSize := s.LotSize
+		if !lotSize.IsPositive() {
+			lotSize = DefaultInstrument(s.Symbol).LotSize
+		}
+		if s.MaxQty.LessThan(lotSize.Mul(decimal.NewFromInt(defaultMinLots))) {
+			minQty = decimal.Max(lotSize, s.MaxQty.Sub(lotSize.Mul(decimal.NewFromInt(defaultLotsRange))))
+		}
+	}
+	params := make([]string, 0, 4)
+	for _, param := range []struct {
+		key   string
+		value decimal.Decimal
+	}{
+		{"tick", s.TickSize},
+		{"lot", s.LotSize},
+		{"min-qty", minQty},
+		{"max-qty", s.MaxQty},
+	} {
+		if param.value.IsPositive() {
+			params = append(params, param.key+"="+param.value.String())
+		}
+	}
+	return s.Symbol + ":" + strings.Join(params, ",")
+}
+
+// ParseSecurity builds a security from a description such as
+// "MONA_EUR:tick=0.005,lot=100,min-qty=100,max-qty=10000,price=42.5".
+func ParseSecurity(description string) (Security, error) {
+	symbol, params, err := parseSpec(description)
+	if err != nil {
+		return Security{}, fmt.Errorf("invalid security: %w", err)
+	}
+	if len(symbol) == 0 {
+		return Security{}, errors.New("security needs a symbol")
+	}
+	security := Security{
+		Symbol:   symbol,
+		TickSize: params.decimalValue("tick", decimal.Zero),
+		LotSize:  params.decimalValue("lot", decimal.Zero),
+		MinQty:   params.decimalValue("min-qty", decimal.Zero),
+		MaxQty:   params.decimalValue("max-qty", decimal.Zero),
+		RefPrice: params.decimalValue("price", decimal.Zero),
+	}
+	if params.err != nil {
+		return Security{}, fmt.Errorf("invalid security %s: %w", symbol, params.err)
+	}
+	return security, nil
+}
+
+// Write sets the security in an entry of the security list group, leaving out
+// the parameters which are not given.
+func (s Security) Write(entry *quickfix.Group) {
+	entry.SetField(tag.Symbol, quickfix.FIXString(s.Symbol))
+	for _, value := range []struct {
+		tag   quickfix.Tag
+		value decimal.Decimal
+	}{
+		{tagMinPriceIncrement, s.TickSize},
+		{tagRoundLot, s.LotSize},
+		{tagMinTradeVol, s.MinQty},
+		{tagMaxTradeVol, s.MaxQty},
+		{tagTradingReferencePrice, s.RefPrice},
+	} {
+		if value.value.IsPositive() {
+			entry.SetField(value.tag, quickfix.FIXDecimal{Decimal: value.value, Scale: precisionOf(value.value)})
+		}
+	}
+}
+
+// NewSecurityListGroup returns the NoRelatedSym group of a security list holding
+// the trading parameters and reference price of each security.
+func NewSecurityListGroup() *quickfix.RepeatingGroup {
+	return quickfix.NewRepeatingGroup(tag.NoRelatedSym, quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.Symbol),
+		quickfix.GroupElement(tag.SecurityID),
+		quickfix.GroupElement(tag.SecurityIDSource),
+		quickfix.GroupElement(tag.Currency),
+		quickfix.GroupElement(tagMinPriceIncrement),
+		quickfix.GroupElement(tagRoundLot),
+		quickfix.GroupElement(tagMinTradeVol),
+		quickfix.GroupElement(tagMaxTradeVol),
+		quickfix.GroupElement(tagTradingReferencePrice),
+		quickfix.GroupElement(tag.LastPx),
+	})
+}
+
+// securityListRequest follows the fragments of the security list answering a request.
+type securityListRequest struct {
+	id         string
+	securities []Security
+	done       chan error
+}
+
+// DiscoverSecurities asks the venue for every security it lists and waits for
+// the whole security list, which may come in several fragments.
+func (a *SenderApp) DiscoverSecurities(timeout time.Duration) ([]Security, error) {
+	request := &securityListRequest{
+		id:   uuid.New().String(),
+		done: make(chan error, 1),
+	}
+	a.securityLock.Lock()
+	a.securityRequest = request
+	a.securityLock.Unlock()
+	defer func() {
+		a.securityLock.Lock()
+		a.securityRequest = nil
+		a.securityLock.Unlock()
+	}()
+
+	message := securitylistrequest.New(
+		field.NewSecurityReqID(request.id),
+		field.NewSecurityListRequestType(enum.SecurityListRequestType_ALL_SECURITIES),
+	)
+	if err := a.Send(message); err != nil {
+		return nil, err
+	}
+	a.Logger.Info().Str("securityReqId", request.id).Msg("SecurityListRequest sent")
+
+	select {
+	case err := <-request.done:
+		if err != nil {
+			return nil, err
+		}
+		return request.securities, nil
+	case <-time.After(timeout):
+		return nil, errors.New("no security list received before timeout")
+	}
+}
+
+func (a *SenderApp) onSecurityList(msg securitylist.SecurityList, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.securityLock.Lock()
+	defer a.securityLock.Unlock()
+	request := a.securityRequest
+	if request == nil {
+		a.Logger.Warn().Msg("Unexpected SecurityList received")
+		return nil
+	}
+	if reqId, err := msg.GetSecurityReqID(); err == nil && reqId != request.id {
+		a.Logger.Warn().Str("securityReqId", reqId).Msg("SecurityList of another request received")
+		return nil
+	}
+
+	if msg.HasSecurityRequestResult() {
+		result, err := msg.GetSecurityRequestResult()
+		if err != nil {
+			return err
+		}
+		if result != enum.SecurityRequestResult_VALID_REQUEST {
+			request.done <- fmt.Errorf("security list request refused with result %s", result)
+			a.securityRequest = nil
+			return nil
+		}
+	}
+
+	group := NewSecurityListGroup()
+	if err := msg.Body.GetGroup(group); err != nil && msg.HasNoRelatedSym() {
+		return err
+	}
+	for i := 0; i < group.Len(); i++ {
+		security, err := readSecurity(group.Get(i))
+		if err != nil {
+			return err
+		}
+		request.securities = append(request.securities, security)
+	}
+
+	// A list sent in a single message may not set LastFragment
+	if last, err := msg.GetLastFragment(); err == nil && !last {
+		return nil
+	}
+	a.Logger.Info().Int("securities", len(request.securities)).Msg("SecurityList received")
+	request.done <- nil
+	a.securityRequest = nil
+	return nil
+}
+
+// readSecurity reads an entry of the security list, the reference price being the
+// trading reference price or else the last price.
+func readSecurity(entry *quickfix.Group) (Security, quickfix.MessageRejectError) {
+	var security Security
+	var symbol field.SymbolField
+	if err := entry.Get(&symbol); err != nil {
+		return security, err
+	}
+	security.Symbol = symbol.Value()
+
+	for _, value := range []struct {
+		tag  quickfix.Tag
+		dest *decimal.Decimal
+	}{
+		{tagMinPriceIncrement, &security.TickSize},
+		{tagRoundLot, &security.LotSize},
+		{tagMinTradeVol, &security.MinQty},
+		{tagMaxTradeVol, &security.MaxQty},
+		{tag.LastPx, &security.RefPrice},
+		{tagTradingReferencePrice, &security.RefPrice},
+	} {
+		if !entry.Has(value.tag) {
+			continue
+		}
+		var d quickfix.FIXDecimal
+		if err := entry.GetField(value.tag, &d); err != nil {
+			return security, err
+		}
+		*value.dest = d.Decimal
+	}
+	return security, nil
+}
@@ -0,0 +1,43 @@
+package order
+
+import (
+	"testing"
+)
+
+func TestSecurityInstrument(t *testing.T) {
+	tests := []struct {
+		security string
+		// expected instrument description, then its minimum and maximum quantities
+		want   string
+		minQty string
+		maxQty string
+	}{
+		{security: "XXX:tick=0.5,lot=10,min-qty=100,max-qty=1000", want: "XXX:tick=0.5,lot=10,min-qty=100,max-qty=1000", minQty: "100", maxQty: "1000"},
+		{security: "XXX", want: "XXX:", minQty: "90", maxQty: "109"},
+		{security: "XXX:min-qty=5", want: "XXX:min-qty=5", minQty: "5", maxQty: "109"},
+		{security: "XXX:max-qty=500", want: "XXX:max-qty=500", minQty: "90", maxQty: "500"},
+		{security: "XXX:max-qty=50", want: "XXX:min-qty=31,max-qty=50", minQty: "31", maxQty: "50"},
+		{security: "XXX:max-qty=10", want: "XXX:min-qty=1,max-qty=10", minQty: "1", maxQty: "10"},
+		{security: "XXX:lot=100,max-qty=3000", want: "XXX:lot=100,min-qty=1100,max-qty=3000", minQty: "1100", maxQty: "3000"},
+		{security: "XXX:lot=100,max-qty=100", want: "XXX:lot=100,min-qty=100,max-qty=100", minQty: "100", maxQty: "100"},
+	}
+	for _, test := range tests {
+		t.Run(test.security, func(t *testing.T) {
+			security, err := ParseSecurity(test.security)
+			if err != nil {
+				t.Fatal(err)
+			}
+			description := security.Instrument()
+			if description != test.want {
+				t.Fatalf("instrument %q, expected %q", description, test.want)
+			}
+			instrument, err := ParseInstrument(description)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if instrument.MinQty.String() != test.minQty || instrument.MaxQty.String() != test.maxQty {
+				t.Fatalf("quantities from %s to %s, expected from %s to %s", instrument.MinQty, instrument.MaxQty, test.minQty, test.maxQty)
+			}
+		})
+	}
+}
@@ -4,14 +4,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/quickfixgo/enum"
 	"github.com/quickfixgo/fix50sp2/executionreport"
 	"github.com/quickfixgo/fix50sp2/ordercancelreject"
 	"github.com/quickfixgo/fix50sp2/ordermasscancelreport"
 	"github.com/quickfixgo/fix50sp2/quotestatusreport"
+	"github.com/quickfixgo/fix50sp2/securitylist"
 	"github.com/quickfixgo/quickfix"
 	"github.com/quickfixgo/tag"
 	"github.com/rs/zerolog"
@@ -46,21 +49,70 @@ type SenderApp struct {
 	// QuoteStatusReportNotification forwards received fix message to subscriber.
 	QuoteStatusReportNotification chan quotestatusreport.QuoteStatusReport
 
+	// OrderCancelRejectNotification forwards received fix message to subscriber.
+	OrderCancelRejectNotification chan ordercancelreject.OrderCancelReject
+
+	// ReconnectNotification notifies the subscriber of a logon following a logout.
+	ReconnectNotification chan bool
+
 	// sessionId is the session connected to the market to send orders.
 	sessionId quickfix.SessionID
 
-	isConnectionUp bool
+	isConnectionUp atomic.Bool
 
 	// Closed is a chan to notify when application is closed properly.
 	Closed chan bool
 
 	isStopping atomic.Bool
+
+	// isDraining is set at shutdown, notifications are then discarded.
+	isDraining atomic.Bool
+
+	// pendingCancels counts the cancels sent while draining which are not answered yet, by ClOrdID or QuoteID.
+	pendingCancels  map[string]int
+	cancelLock      sync.Mutex
+	cancelsAnswered chan bool
+
+	// securityRequest is the security list request waiting for its answer, if any.
+	securityRequest *securityListRequest
+	securityLock    sync.Mutex
+
+	// tradeRequest is the trade capture report request waiting for its answers, if any.
+	tradeRequest *tradeCaptureRequest
+	tradeLock    sync.Mutex
+
+	// feed, when set, follows the requests sent until the market data feed publishes them.
+	feed *feedTracker
+	// own, when set, follows the orders and quotes of the session shown in the market data.
+	own *ownOrders
+
+	// conformance checks the execution reports of the orders sent on the session.
+	conformance *conformanceChecker
+
+	// dropCopy, when set, matches the execution reports with their drop copies.
+	dropCopy *dropCopyMatcher
+
+	// risk, when set, follows the orders resting on the venue for the risk limits.
+	risk *RiskGuard
 }
 
 var (
 	_ quickfix.Application = (*SenderApp)(nil)
+
+	metricDisconnects = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Subsystem: "order_gatling",
+			Name:      "disconnects_total",
+			Help:      "Number of logouts not requested by the gatling",
+		},
+		[]string{"session"},
+	)
 )
 
+func init() {
+	prometheus.MustRegister(metricDisconnects)
+}
+
 // NewOrderSender creates an Application which implements quickfix.Application.
 func NewOrderSender(
 	ctx context.Context,
@@ -75,15 +127,23 @@ func NewOrderSender(
 		logonStatusChan:               make(chan bool),
 		ExecReportNotification:        make(chan executionreport.ExecutionReport, 10),
 		QuoteStatusReportNotification: make(chan quotestatusreport.QuoteStatusReport, 10),
-		isConnectionUp:                false,
+		OrderCancelRejectNotification: make(chan ordercancelreject.OrderCancelReject, 10),
+		ReconnectNotification:         make(chan bool, 1),
+		isConnectionUp:                atomic.Bool{},
 		Closed:                        make(chan bool),
 		isStopping:                    atomic.Bool{},
+		pendingCancels:                make(map[string]int),
+		cancelsAnswered:               make(chan bool, 1),
+		conformance:                   newConformanceChecker(sessionConfig.Name),
 	}
 
 	app.MessageRouter.AddRoute(executionreport.Route(app.onExecutionReport))
 	app.MessageRouter.AddRoute(quotestatusreport.Route(app.OnQuoteStatusReport))
 	app.MessageRouter.AddRoute(ordercancelreject.Route(app.onOrderCancelReject))
 	app.MessageRouter.AddRoute(ordermasscancelreport.Route(app.onOrderMassCancelReport))
+	app.MessageRouter.AddRoute(securitylist.Route(app.onSecurityList))
+	app.MessageRouter.AddRoute(quickfix.ApplVerIDFIX50SP2, string(enum.MsgType_TRADE_CAPTURE_REPORT_REQUEST_ACK), app.onTradeCaptureReportRequestAck)
+	app.MessageRouter.AddRoute(quickfix.ApplVerIDFIX50SP2, string(enum.MsgType_TRADE_CAPTURE_REPORT), app.onTradeCaptureReport)
 
 	go app.handleContextDone(ctx)
 
@@ -99,6 +159,7 @@ func (a *SenderApp) handleContextDone(ctx context.Context) {
 	close(a.logonStatusChan)
 	close(a.ExecReportNotification)
 	close(a.QuoteStatusReportNotification)
+	close(a.OrderCancelRejectNotification)
 	a.Closed <- true
 }
 
@@ -169,6 +230,28 @@ func (a *SenderApp) FromAdmin(message *quickfix.Message, sessionID quickfix.Sess
 func (a *SenderApp) ToApp(message *quickfix.Message, sessionID quickfix.SessionID) error {
 	a.LogMessage(zerolog.TraceLevel, message, sessionID, true)
 
+	if a.feed != nil {
+		a.feed.trackMessage(message, a.Session())
+	}
+	a.conformance.trackMessage(message)
+
+	if a.isDraining.Load() {
+		typ, err := message.MsgType()
+		if err != nil {
+			return nil
+		}
+		switch enum.MsgType(typ) {
+		case enum.MsgType_ORDER_MASS_CANCEL_REQUEST:
+			if clOrdId, err := message.Body.GetString(tag.ClOrdID); err == nil {
+				a.trackCancel(clOrdId)
+			}
+		case enum.MsgType_QUOTE_CANCEL:
+			if quoteId, err := message.Body.GetString(tag.QuoteID); err == nil {
+				a.trackCancel(quoteId)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -179,11 +262,38 @@ func (a *SenderApp) FromApp(message *quickfix.Message, sessionID quickfix.Sessio
 }
 
 func (a *SenderApp) onExecutionReport(msg executionreport.ExecutionReport, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	duplicate := false
+	for _, v := range a.conformance.check(msg) {
+		a.conformance.record(v)
+		a.Logger.Warn().Str("violation", v.kind).Str("clOrdId", v.clOrdId).Str("detail", v.detail).Msg("ExecutionReport breaks the order lifecycle")
+		duplicate = duplicate || v.kind == violationDuplicateExecId
+	}
+	// A duplicate execution report must not count its fill twice
+	if !duplicate {
+		positions.recordFill(msg, a.Session())
+	}
+	if a.dropCopy != nil {
+		a.dropCopy.reported(msg)
+	}
+	if a.risk != nil {
+		a.risk.recordExecutionReport(msg)
+	}
+	if a.own != nil {
+		a.own.recordExecutionReport(msg)
+	}
 	a.ExecReportNotification <- msg
 	return nil
 }
 
 func (a *SenderApp) OnQuoteStatusReport(msg quotestatusreport.QuoteStatusReport, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	if a.isDraining.Load() {
+		if quoteId, err := msg.GetQuoteID(); err == nil {
+			a.answerCancel(quoteId)
+		}
+	}
+	if a.own != nil {
+		a.own.recordQuoteStatusReport(msg, a.Session())
+	}
 	a.QuoteStatusReportNotification <- msg
 	return nil
 }
@@ -203,14 +313,18 @@ func (a *SenderApp) onOrderMassCancelReport(msg ordermasscancelreport.OrderMassC
 	if err != nil {
 		txt = "no reason"
 	}
+	symbol, _ := msg.GetSymbol()
 	switch rsp {
 	case enum.MassCancelResponse_CANCEL_ORDERS_FOR_A_SECURITY:
+		stats.recordAck(msgTypeOrderMassCancelRequest, symbol)
 		a.Logger.Info().Str("clOrdId", clOrdId).Msg("OrderMassCancelRequest accepted")
 	case enum.MassCancelResponse_CANCEL_REQUEST_REJECTED:
+		stats.recordReject(msgTypeOrderMassCancelRequest, symbol)
 		a.Logger.Error().Str("clOrdId", clOrdId).Str("reason", txt).Msg("OrderMassCancelRequest rejected")
 	default:
 		a.Logger.Error().Any("value", rsp).Str("clOrdId", clOrdId).Str("reason", txt).Msg("OrderMassCancelResponse invalid")
 	}
+	a.answerCancel(clOrdId)
 	return nil
 }
 
@@ -224,12 +338,119 @@ func (a *SenderApp) onOrderCancelReject(msg ordercancelreject.OrderCancelReject,
 	if err != nil {
 		reason = "No exchange reason"
 	}
+	messageType := msgTypeOrderCancelReplaceRequest
+	if responseTo, err := msg.GetCxlRejResponseTo(); err == nil && responseTo == enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST {
+		messageType = msgTypeOrderCancelRequest
+	}
+	stats.recordReject(messageType, "")
+	a.conformance.rejected(clOrdId)
+	if a.risk != nil {
+		a.risk.forget(clOrdId)
+	}
 	a.Logger.Warn().Str("clOrdId", clOrdId).Str("text", reason).Msg("OrderCancelReject received")
+	a.OrderCancelRejectNotification <- msg
 	return nil
 }
 
+// Session returns the name of the configured session, used to label metrics.
+func (a *SenderApp) Session() string {
+	return a.sessionConfig.Name
+}
+
+// Drain stops forwarding notifications to the workflow and starts tracking the
+// cancels sent from now on, so that WaitCancels can wait for their answers.
+func (a *SenderApp) Drain() {
+	if a.isDraining.Swap(true) {
+		return
+	}
+	go func() {
+		for range a.ExecReportNotification {
+		}
+	}()
+	go func() {
+		for range a.QuoteStatusReportNotification {
+		}
+	}()
+	go func() {
+		for range a.OrderCancelRejectNotification {
+		}
+	}()
+}
+
+// WaitCancels waits until every cancel sent while draining is answered by the venue.
+// It returns false if some are still pending when the timeout expires.
+func (a *SenderApp) WaitCancels(timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		a.cancelLock.Lock()
+		pending := len(a.pendingCancels)
+		a.cancelLock.Unlock()
+		if pending == 0 {
+			return true
+		}
+		select {
+		case <-a.cancelsAnswered:
+		case <-deadline:
+			a.Logger.Warn().Str("session", a.Session()).Int("pending", pending).Msg("Cancels not answered before timeout")
+			return false
+		}
+	}
+}
+
+func (a *SenderApp) trackCancel(id string) {
+	a.cancelLock.Lock()
+	defer a.cancelLock.Unlock()
+	a.pendingCancels[id]++
+}
+
+func (a *SenderApp) answerCancel(id string) {
+	a.cancelLock.Lock()
+	defer a.cancelLock.Unlock()
+	count, found := a.pendingCancels[id]
+	if !found {
+		return
+	}
+	if count > 1 {
+		a.pendingCancels[id] = count - 1
+		return
+	}
+	delete(a.pendingCancels, id)
+	select {
+	case a.cancelsAnswered <- true:
+	default:
+	}
+}
+
+// MeasureFeedLatency measures the latency between the requests sent on the session
+// and the updates of their price levels in the market data.
+func (a *SenderApp) MeasureFeedLatency(market *MarketData) {
+	a.feed = market.feed
+}
+
+// ExcludeOwnOrders leaves the orders and quotes of the session resting on the
+// book out of the best bid and offer followed by the prices.
+func (a *SenderApp) ExcludeOwnOrders(market *MarketData) {
+	a.own = market.own
+}
+
+// MatchDropCopy matches the execution reports received on the session with the
+// ones copied on the drop copy session.
+func (a *SenderApp) MatchDropCopy(dropCopy *DropCopyApp) {
+	a.dropCopy = dropCopy.matcher
+}
+
+// EnforceRiskLimits follows the orders of the session resting on the venue for the risk limits.
+func (a *SenderApp) EnforceRiskLimits(guard *RiskGuard) {
+	a.risk = guard
+}
+
+// IsConnected tells whether the session is logged on.
+func (a *SenderApp) IsConnected() bool {
+	return a.isConnectionUp.Load()
+}
+
 func (a *SenderApp) Send(message quickfix.Messagable) error {
-	if !a.isConnectionUp {
+	if !a.isConnectionUp.Load() {
 		return errors.New("order fix session is logged out")
 	}
 	return quickfix.SendToTarget(message, a.sessionId)
@@ -260,11 +481,23 @@ func (a *SenderApp) Connect() error {
 		if !ok {
 			return fixerrors.FixLogout
 		}
-		a.isConnectionUp = status
+		a.isConnectionUp.Store(status)
 	}
 	go func() {
-		for {
-			a.isConnectionUp = <-a.logonStatusChan
+		// The initiator logs on again by itself after a logout, subscribers are
+		// notified so they can resume their workflow.
+		for status := range a.logonStatusChan {
+			wasUp := a.isConnectionUp.Swap(status)
+			switch {
+			case status && !wasUp:
+				select {
+				case a.ReconnectNotification <- true:
+				default:
+				}
+			case !status && wasUp:
+				metricDisconnects.WithLabelValues(a.Session()).Inc()
+				stats.recordDisconnect()
+			}
 		}
 	}()
 
@@ -0,0 +1,119 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/quickfixgo/quickfix"
+	"github.com/rs/zerolog"
+)
+
+const (
+	DispatchAccount    = "account"
+	DispatchRoundRobin = "round-robin"
+
+	ReconnectResume  = "resume"
+	ReconnectRestart = "restart"
+)
+
+// SessionPool spreads the orders of a workflow over several FIX sessions, either
+// by account (all orders of an account go through the same session) or round-robin.
+// After a session logs on again, workflows either resume from the last acknowledged
+// orders or mass cancel them and start over.
+type SessionPool struct {
+	apps        []*SenderApp
+	dispatch    string
+	onReconnect string
+	next        int
+	byAccount   map[string]*SenderApp
+	risk        *RiskGuard
+	lock        sync.Mutex
+}
+
+func NewSessionPool(apps []*SenderApp, dispatch string, onReconnect string) (*SessionPool, error) {
+	if len(apps) == 0 {
+		return nil, errors.New("session pool needs at least one session")
+	}
+	switch dispatch {
+	case DispatchAccount, DispatchRoundRobin:
+	default:
+		return nil, fmt.Errorf("unknown session dispatch %q", dispatch)
+	}
+	switch onReconnect {
+	case ReconnectResume, ReconnectRestart:
+	default:
+		return nil, fmt.Errorf("unknown reconnect behaviour %q", onReconnect)
+	}
+	return &SessionPool{
+		apps:        apps,
+		dispatch:    dispatch,
+		onReconnect: onReconnect,
+		byAccount:   make(map[string]*SenderApp),
+	}, nil
+}
+
+// Apps returns every session of the pool.
+func (p *SessionPool) Apps() []*SenderApp {
+	return p.apps
+}
+
+// Logger returns the logger of the first session, for messages not related to a session.
+func (p *SessionPool) Logger() *zerolog.Logger {
+	return p.apps[0].Logger
+}
+
+// Assign picks the session of a new order of the account. Accounts are given
+// sessions in turn the first time they are seen.
+func (p *SessionPool) Assign(account string) *SenderApp {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.dispatch == DispatchAccount {
+		if app, found := p.byAccount[account]; found {
+			return app
+		}
+	}
+	app := p.apps[p.next%len(p.apps)]
+	p.next++
+	if p.dispatch == DispatchAccount {
+		p.byAccount[account] = app
+	}
+	return app
+}
+
+// SessionsOf returns the sessions which may hold orders of the account.
+func (p *SessionPool) SessionsOf(account string) []*SenderApp {
+	if p.dispatch == DispatchRoundRobin {
+		return p.apps
+	}
+	return []*SenderApp{p.Assign(account)}
+}
+
+// restartOnReconnect tells whether orders are mass cancelled and created again after a re-logon.
+func (p *SessionPool) restartOnReconnect() bool {
+	return p.onReconnect == ReconnectRestart
+}
+
+// EnforceRiskLimits makes the workflows check their requests against the risk limits
+// of the guard before sending them.
+func (p *SessionPool) EnforceRiskLimits(guard *RiskGuard) {
+	p.risk = guard
+	for _, app := range p.apps {
+		app.EnforceRiskLimits(guard)
+	}
+}
+
+// checkRisk tells whether the request can be sent, it always can without risk limits.
+func (p *SessionPool) checkRisk(message quickfix.Messagable, account string, symbol string, prices PriceModel) error {
+	if p.risk == nil {
+		return nil
+	}
+	return p.risk.check(message, account, symbol, prices)
+}
+
+// forgetRisk releases the exposure of a request which could not be sent.
+func (p *SessionPool) forgetRisk(clOrdId string) {
+	if p.risk != nil {
+		p.risk.forget(clOrdId)
+	}
+}
@@ -0,0 +1,351 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/shopspring/decimal"
+)
+
+// Fields of the trade capture messages, which have no generated package.
+const (
+	tagTradeRequestID     quickfix.Tag = 568
+	tagTradeRequestType   quickfix.Tag = 569
+	tagTradeReportID      quickfix.Tag = 571
+	tagNoDates            quickfix.Tag = 580
+	tagNoSides            quickfix.Tag = 552
+	tagTotNumTradeReports quickfix.Tag = 748
+	tagTradeRequestResult quickfix.Tag = 749
+	tagTradeRequestStatus quickfix.Tag = 750
+)
+
+// Values of TradeRequestType, TradeRequestResult and TradeRequestStatus.
+const (
+	tradeRequestTypeAllTrades = "0"
+	tradeRequestResultOK      = "0"
+	tradeRequestResultOther   = "99"
+	tradeRequestStatusOK      = "0"
+	tradeRequestStatusRefused = "2"
+)
+
+// Trade is a fill of an order reported by the venue in a TradeCaptureReport, from
+// the point of view of the session which sent the order.
+type Trade struct {
+	ReportID string
+	ExecID   string
+	OrderID  string
+	ClOrdID  string
+	Account  string
+	Symbol   string
+	Side     enum.Side
+	Qty      decimal.Decimal
+	Price    decimal.Decimal
+	Time     time.Time
+}
+
+// TradeCaptureRequest asks for the trades of a session executed between two times.
+type TradeCaptureRequest struct {
+	ID   string
+	From time.Time
+	To   time.Time
+}
+
+func newMessage(msgType enum.MsgType) *quickfix.Message {
+	message := quickfix.NewMessage()
+	message.Header.SetField(tag.MsgType, quickfix.FIXString(msgType))
+	return message
+}
+
+func newTradeDatesGroup() *quickfix.RepeatingGroup {
+	return quickfix.NewRepeatingGroup(tagNoDates, quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.TransactTime),
+	})
+}
+
+func newTradeSidesGroup() *quickfix.RepeatingGroup {
+	return quickfix.NewRepeatingGroup(tagNoSides, quickfix.GroupTemplate{
+		quickfix.GroupElement(tag.Side),
+		quickfix.GroupElement(tag.OrderID),
+		quickfix.GroupElement(tag.ClOrdID),
+		quickfix.GroupElement(tag.Account),
+	})
+}
+
+// NewTradeCaptureReportRequest asks for a snapshot of every trade executed
+// between the times of the request.
+func NewTradeCaptureReportRequest(request TradeCaptureRequest) *quickfix.Message {
+	message := newMessage(enum.MsgType_TRADE_CAPTURE_REPORT_REQUEST)
+	message.Body.SetField(tagTradeRequestID, quickfix.FIXString(request.ID))
+	message.Body.SetField(tagTradeRequestType, quickfix.FIXString(tradeRequestTypeAllTrades))
+	message.Body.SetField(tag.SubscriptionRequestType, quickfix.FIXString(enum.SubscriptionRequestType_SNAPSHOT))
+	dates := newTradeDatesGroup()
+	dates.Add().SetField(tag.TransactTime, quickfix.FIXUTCTimestamp{Time: request.From})
+	dates.Add().SetField(tag.TransactTime, quickfix.FIXUTCTimestamp{Time: request.To})
+	message.Body.SetGroup(dates)
+	return message
+}
+
+// ReadTradeCaptureReportRequest reads a request built by NewTradeCaptureReportRequest.
+// Times which are not given are zero.
+func ReadTradeCaptureReportRequest(message *quickfix.Message) (TradeCaptureRequest, quickfix.MessageRejectError) {
+	var request TradeCaptureRequest
+	var err quickfix.MessageRejectError
+	if request.ID, err = message.Body.GetString(tagTradeRequestID); err != nil {
+		return request, err
+	}
+	dates := newTradeDatesGroup()
+	if err := message.Body.GetGroup(dates); err != nil && message.Body.Has(tagNoDates) {
+		return request, err
+	}
+	for i, bound := range []*time.Time{&request.From, &request.To} {
+		if i >= dates.Len() {
+			break
+		}
+		var t quickfix.FIXUTCTimestamp
+		if err := dates.Get(i).GetField(tag.TransactTime, &t); err != nil {
+			return request, err
+		}
+		*bound = t.Time
+	}
+	return request, nil
+}
+
+// NewTradeCaptureReportRequestAck answers a request with the number of trades
+// which follow, or refuses it when the reason is not empty.
+func NewTradeCaptureReportRequestAck(requestId string, total int, reason string) *quickfix.Message {
+	message := newMessage(enum.MsgType_TRADE_CAPTURE_REPORT_REQUEST_ACK)
+	message.Body.SetField(tagTradeRequestID, quickfix.FIXString(requestId))
+	message.Body.SetField(tagTradeRequestType, quickfix.FIXString(tradeRequestTypeAllTrades))
+	if len(reason) > 0 {
+		message.Body.SetField(tagTradeRequestResult, quickfix.FIXString(tradeRequestResultOther))
+		message.Body.SetField(tagTradeRequestStatus, quickfix.FIXString(tradeRequestStatusRefused))
+		message.Body.SetField(tag.Text, quickfix.FIXString(reason))
+		return message
+	}
+	message.Body.SetField(tagTradeRequestResult, quickfix.FIXString(tradeRequestResultOK))
+	message.Body.SetField(tagTradeRequestStatus, quickfix.FIXString(tradeRequestStatusOK))
+	message.Body.SetField(tagTotNumTradeReports, quickfix.FIXInt(total))
+	return message
+}
+
+// NewTradeCaptureReport reports a trade answering a request, last telling whether
+// it is the last trade of the answer.
+func NewTradeCaptureReport(requestId string, total int, last bool, trade Trade) *quickfix.Message {
+	message := newMessage(enum.MsgType_TRADE_CAPTURE_REPORT)
+	message.Body.SetField(tagTradeReportID, quickfix.FIXString(trade.ReportID))
+	message.Body.SetField(tagTradeRequestID, quickfix.FIXString(requestId))
+	message.Body.SetField(tagTotNumTradeReports, quickfix.FIXInt(total))
+	message.Body.SetField(tag.LastRptRequested, quickfix.FIXBoolean(last))
+	message.Body.SetField(tag.ExecID, quickfix.FIXString(trade.ExecID))
+	message.Body.SetField(tag.Symbol, quickfix.FIXString(trade.Symbol))
+	message.Body.SetField(tag.LastQty, quickfix.FIXDecimal{Decimal: trade.Qty, Scale: precisionOf(trade.Qty)})
+	message.Body.SetField(tag.LastPx, quickfix.FIXDecimal{Decimal: trade.Price, Scale: precisionOf(trade.Price)})
+	message.Body.SetField(tag.TransactTime, quickfix.FIXUTCTimestamp{Time: trade.Time})
+	sides := newTradeSidesGroup()
+	side := sides.Add()
+	side.SetField(tag.Side, quickfix.FIXString(trade.Side))
+	side.SetField(tag.OrderID, quickfix.FIXString(trade.OrderID))
+	if len(trade.ClOrdID) > 0 {
+		side.SetField(tag.ClOrdID, quickfix.FIXString(trade.ClOrdID))
+	}
+	if len(trade.Account) > 0 {
+		side.SetField(tag.Account, quickfix.FIXString(trade.Account))
+	}
+	message.Body.SetGroup(sides)
+	return message
+}
+
+// readTradeCaptureReport reads the trade of a report, taken from its first side.
+func readTradeCaptureReport(message *quickfix.Message) (Trade, quickfix.MessageRejectError) {
+	var trade Trade
+	var err quickfix.MessageRejectError
+	if trade.ReportID, err = message.Body.GetString(tagTradeReportID); err != nil {
+		return trade, err
+	}
+	trade.ExecID, _ = message.Body.GetString(tag.ExecID)
+	if trade.Symbol, err = message.Body.GetString(tag.Symbol); err != nil {
+		return trade, err
+	}
+	for _, value := range []struct {
+		tag  quickfix.Tag
+		dest *decimal.Decimal
+	}{
+		{tag.LastQty, &trade.Qty},
+		{tag.LastPx, &trade.Price},
+	} {
+		var d quickfix.FIXDecimal
+		if err := message.Body.GetField(value.tag, &d); err != nil {
+			return trade, err
+		}
+		*value.dest = d.Decimal
+	}
+	var transactTime quickfix.FIXUTCTimestamp
+	if err := message.Body.GetField(tag.TransactTime, &transactTime); err == nil {
+		trade.Time = transactTime.Time
+	}
+
+	sides := newTradeSidesGroup()
+	if err := message.Body.GetGroup(sides); err != nil {
+		return trade, err
+	}
+	if sides.Len() == 0 {
+		return trade, quickfix.RequiredTagMissing(tagNoSides)
+	}
+	side := sides.Get(0)
+	value, err := side.GetString(tag.Side)
+	if err != nil {
+		return trade, err
+	}
+	trade.Side = enum.Side(value)
+	trade.OrderID, _ = side.GetString(tag.OrderID)
+	trade.ClOrdID, _ = side.GetString(tag.ClOrdID)
+	trade.Account, _ = side.GetString(tag.Account)
+	return trade, nil
+}
+
+// tradeCaptureRequest follows the reports answering a trade capture request.
+type tradeCaptureRequest struct {
+	id     string
+	total  int
+	trades []Trade
+	done   chan error
+}
+
+// RequestTrades asks the venue for the trades of the session executed since the
+// given time and waits for all of them.
+func (a *SenderApp) RequestTrades(since time.Time, timeout time.Duration) ([]Trade, error) {
+	request := &tradeCaptureRequest{
+		id:    uuid.New().String(),
+		total: -1,
+		done:  make(chan error, 1),
+	}
+	a.tradeLock.Lock()
+	a.tradeRequest = request
+	a.tradeLock.Unlock()
+	defer func() {
+		a.tradeLock.Lock()
+		a.tradeRequest = nil
+		a.tradeLock.Unlock()
+	}()
+
+	message := NewTradeCaptureReportRequest(TradeCaptureRequest{ID: request.id, From: since, To: time.Now()})
+	if err := a.Send(message); err != nil {
+		return nil, err
+	}
+	a.Logger.Info().Str("tradeRequestId", request.id).Msg("TradeCaptureReportRequest sent")
+
+	select {
+	case err := <-request.done:
+		if err != nil {
+			return nil, err
+		}
+		return request.trades, nil
+	case <-time.After(timeout):
+		return nil, errors.New("trade capture reports not received before timeout")
+	}
+}
+
+func (a *SenderApp) onTradeCaptureReportRequestAck(message *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.tradeLock.Lock()
+	defer a.tradeLock.Unlock()
+	request := a.ongoingTradeRequest(message)
+	if request == nil {
+		return nil
+	}
+
+	status, _ := message.Body.GetString(tagTradeRequestStatus)
+	if status == tradeRequestStatusRefused {
+		reason, err := message.Body.GetString(tag.Text)
+		if err != nil {
+			reason = "no reason"
+		}
+		request.done <- fmt.Errorf("trade capture report request refused: %s", reason)
+		a.tradeRequest = nil
+		return nil
+	}
+	if total, err := message.Body.GetInt(tagTotNumTradeReports); err == nil {
+		request.total = total
+	}
+	a.completeTradeRequest(request, false)
+	return nil
+}
+
+func (a *SenderApp) onTradeCaptureReport(message *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.tradeLock.Lock()
+	defer a.tradeLock.Unlock()
+	request := a.ongoingTradeRequest(message)
+	if request == nil {
+		return nil
+	}
+
+	trade, err := readTradeCaptureReport(message)
+	if err != nil {
+		return err
+	}
+	request.trades = append(request.trades, trade)
+	if total, err := message.Body.GetInt(tagTotNumTradeReports); err == nil {
+		request.total = total
+	}
+	var last quickfix.FIXBoolean
+	if err := message.Body.GetField(tag.LastRptRequested, &last); err != nil {
+		last = false
+	}
+	a.completeTradeRequest(request, bool(last))
+	return nil
+}
+
+// ongoingTradeRequest returns the request answered by the message, if it is the
+// ongoing one. Must be called with the lock held.
+func (a *SenderApp) ongoingTradeRequest(message *quickfix.Message) *tradeCaptureRequest {
+	request := a.tradeRequest
+	if request == nil {
+		a.Logger.Warn().Msg("Unexpected trade capture message received")
+		return nil
+	}
+	if requestId, err := message.Body.GetString(tagTradeRequestID); err == nil && requestId != request.id {
+		a.Logger.Warn().Str("tradeRequestId", requestId).Msg("Trade capture message of another request received")
+		return nil
+	}
+	return request
+}
+
+// completeTradeRequest ends the request once every announced trade is received.
+// Must be called with the lock held.
+func (a *SenderApp) completeTradeRequest(request *tradeCaptureRequest, last bool) {
+	if !last && (request.total < 0 || len(request.trades) < request.total) {
+		return
+	}
+	a.Logger.Info().Int("trades", len(request.trades)).Msg("TradeCaptureReports received")
+	request.done <- nil
+	a.tradeRequest = nil
+}
+
+// ReconcileTrades requests the trades executed since the given time on every
+// session and compares the positions they make with the ones of the execution
+// reports. The result is part of the report of the run.
+func ReconcileTrades(senders []*SenderApp, since time.Time, timeout time.Duration) error {
+	venue := newPositionBook()
+	for _, sender := range senders {
+		if !sender.IsConnected() {
+			return fmt.Errorf("session %s is logged out", sender.Session())
+		}
+		trades, err := sender.RequestTrades(since, timeout)
+		if err != nil {
+			return fmt.Errorf("session %s: %w", sender.Session(), err)
+		}
+		for _, trade := range trades {
+			venue.add(trade.Account, trade.Symbol, trade.Side, trade.Qty, trade.Price)
+		}
+	}
+	reconciliation := reconcile("trade_capture", positions, venue)
+	stats.recordReconciliation(reconciliation)
+	if len(reconciliation.Breaks) > 0 {
+		return fmt.Errorf("%d positions differ from the trades of the venue", len(reconciliation.Breaks))
+	}
+	return nil
+}
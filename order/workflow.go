@@ -0,0 +1,52 @@
+package order
+
+import "time"
+
+const (
+	WorkflowAmend   = "amend"
+	WorkflowQuote   = "quote"
+	WorkflowSampled = "sampled"
+)
+
+// Workflow is a way of generating load on the order sender.
+type Workflow interface {
+	CancelAllOrders()
+	Start()
+
+	// Pause stops sending new requests until Resume is called.
+	Pause()
+	Resume()
+
+	// State describes the requests of the workflow at the time of the call.
+	State() WorkflowState
+
+	// Done is closed once the workflow does not consume sender notifications anymore.
+	Done() <-chan bool
+}
+
+// RateController is implemented by workflows sending new orders at a given rate.
+type RateController interface {
+	SetRate(rate float64)
+}
+
+// TempoController is implemented by workflows waiting before amending their orders.
+type TempoController interface {
+	SetUpdateTempo(tempo time.Duration)
+}
+
+type WorkflowState struct {
+	Workflow    string  `json:"workflow"`
+	Paused      bool    `json:"paused"`
+	OpenOrders  int     `json:"open_orders"`
+	InFlight    int     `json:"in_flight"`
+	Rate        float64 `json:"rate,omitempty"`
+	UpdateTempo string  `json:"update_tempo,omitempty"`
+}
+
+var (
+	_ Workflow = (*Manager)(nil)
+	_ Workflow = (*SampledManager)(nil)
+
+	_ TempoController = (*Manager)(nil)
+	_ RateController  = (*SampledManager)(nil)
+)
@@ -0,0 +1,34 @@
+package simulator
+
+import (
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/quickfix"
+)
+
+// CopyExecutionReports sends a copy of the execution reports of every other session
+// to the given one, as a drop copy.
+func (a *VenueApp) CopyExecutionReports(sessionID quickfix.SessionID) {
+	a.dropCopy = &sessionID
+}
+
+func (a *VenueApp) isDropCopy(sessionID quickfix.SessionID) bool {
+	return a.dropCopy != nil &&
+		sessionID.BeginString == a.dropCopy.BeginString &&
+		sessionID.SenderCompID == a.dropCopy.SenderCompID &&
+		sessionID.TargetCompID == a.dropCopy.TargetCompID
+}
+
+// copyExecutionReport sends a copy of an outgoing execution report on the drop
+// copy session, when it is logged on.
+func (a *VenueApp) copyExecutionReport(message *quickfix.Message, sessionID quickfix.SessionID) {
+	if !a.dropCopyUp.Load() || a.isDropCopy(sessionID) {
+		return
+	}
+	typ, err := message.MsgType()
+	if err != nil || enum.MsgType(typ) != enum.MsgType_EXECUTION_REPORT {
+		return
+	}
+	copied := quickfix.NewMessage()
+	message.CopyInto(copied)
+	a.send(copied, *a.dropCopy)
+}
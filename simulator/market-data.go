@@ -0,0 +1,177 @@
+package simulator
+
+import (
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/marketdataincrementalrefresh"
+	"github.com/quickfixgo/fix50sp2/marketdatarequest"
+	"github.com/quickfixgo/fix50sp2/marketdatarequestreject"
+	"github.com/quickfixgo/fix50sp2/marketdatasnapshotfullrefresh"
+	"github.com/quickfixgo/quickfix"
+)
+
+// subscription is a market data subscription of a session. Subscribers receive
+// every price level of the books of their symbols.
+type subscription struct {
+	reqId     string
+	symbols   map[string]bool
+	sessionId quickfix.SessionID
+}
+
+// publishedBook holds the price levels of a book as last published.
+type publishedBook struct {
+	bids   map[string]level
+	offers map[string]level
+}
+
+func (a *VenueApp) onMarketDataRequest(msg marketdatarequest.MarketDataRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	reqId, err := msg.GetMDReqID()
+	if err != nil {
+		return err
+	}
+	requestType, err := msg.GetSubscriptionRequestType()
+	if err != nil {
+		return err
+	}
+	symbols := make(map[string]bool)
+	if related, err := msg.GetNoRelatedSym(); err == nil {
+		for i := 0; i < related.Len(); i++ {
+			if symbol, err := related.Get(i).GetSymbol(); err == nil {
+				symbols[symbol] = true
+			}
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	switch requestType {
+	case enum.SubscriptionRequestType_DISABLE_PREVIOUS_SNAPSHOT_PLUS_UPDATE_REQUEST:
+		for i, s := range a.subscriptions {
+			if s.reqId == reqId && s.sessionId == sessionID {
+				a.subscriptions = append(a.subscriptions[:i], a.subscriptions[i+1:]...)
+				break
+			}
+		}
+		return nil
+	case enum.SubscriptionRequestType_SNAPSHOT_PLUS_UPDATES:
+		a.subscriptions = append(a.subscriptions, &subscription{reqId: reqId, symbols: symbols, sessionId: sessionID})
+	case enum.SubscriptionRequestType_SNAPSHOT:
+	default:
+		reject := marketdatarequestreject.New(field.NewMDReqID(reqId))
+		reject.SetText("unsupported subscription request type")
+		a.send(reject, sessionID)
+		return nil
+	}
+	a.Logger.Info().Str("mdReqId", reqId).Int("symbols", len(symbols)).Msg("MarketDataRequest accepted")
+
+	// Pending changes are published first so the snapshot and the published levels match
+	a.publishMarketData()
+	for symbol := range symbols {
+		a.sendSnapshot(reqId, symbol, sessionID)
+	}
+	return nil
+}
+
+// unsubscribe drops the market data subscriptions of a session.
+func (a *VenueApp) unsubscribe(sessionID quickfix.SessionID) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	subscriptions := a.subscriptions[:0]
+	for _, s := range a.subscriptions {
+		if s.sessionId != sessionID {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+	a.subscriptions = subscriptions
+}
+
+func (a *VenueApp) sendSnapshot(reqId string, symbol string, sessionID quickfix.SessionID) {
+	book := a.getBook(symbol)
+	snapshot := marketdatasnapshotfullrefresh.New(field.NewLastUpdateTime(time.Now()))
+	snapshot.SetMDReqID(reqId)
+	snapshot.SetSymbol(symbol)
+	entries := marketdatasnapshotfullrefresh.NewNoMDEntriesRepeatingGroup()
+	for _, side := range []struct {
+		entryType enum.MDEntryType
+		levels    map[string]level
+	}{
+		{enum.MDEntryType_BID, levels(book.bids)},
+		{enum.MDEntryType_OFFER, levels(book.asks)},
+	} {
+		for _, l := range side.levels {
+			entry := entries.Add()
+			entry.SetMDEntryType(side.entryType)
+			entry.SetMDEntryPx(l.price, scale(l.price))
+			entry.SetMDEntrySize(l.qty, scale(l.qty))
+		}
+	}
+	snapshot.SetNoMDEntries(entries)
+	a.send(snapshot, sessionID)
+}
+
+// publishMarketData sends the price levels which changed since the last publication
+// to the subscribers of the changed books. Must be called with the lock held.
+func (a *VenueApp) publishMarketData() {
+	for symbol, book := range a.books {
+		if !book.changed {
+			continue
+		}
+		book.changed = false
+
+		previous, found := a.published[symbol]
+		if !found {
+			previous = &publishedBook{bids: make(map[string]level), offers: make(map[string]level)}
+		}
+		current := &publishedBook{bids: levels(book.bids), offers: levels(book.asks)}
+		a.published[symbol] = current
+
+		if len(a.subscriptions) == 0 {
+			continue
+		}
+		entries := marketdataincrementalrefresh.NewNoMDEntriesRepeatingGroup()
+		addLevelChanges(entries, symbol, enum.MDEntryType_BID, previous.bids, current.bids)
+		addLevelChanges(entries, symbol, enum.MDEntryType_OFFER, previous.offers, current.offers)
+		if entries.Len() == 0 {
+			continue
+		}
+		for _, s := range a.subscriptions {
+			if !s.symbols[symbol] {
+				continue
+			}
+			refresh := marketdataincrementalrefresh.New()
+			refresh.SetMDReqID(s.reqId)
+			refresh.SetNoMDEntries(entries)
+			a.send(refresh, s.sessionId)
+		}
+	}
+}
+
+func addLevelChanges(entries marketdataincrementalrefresh.NoMDEntriesRepeatingGroup, symbol string, entryType enum.MDEntryType, previous map[string]level, current map[string]level) {
+	for key, l := range current {
+		action := enum.MDUpdateAction_NEW
+		if old, found := previous[key]; found {
+			if old.qty.Equal(l.qty) {
+				continue
+			}
+			action = enum.MDUpdateAction_CHANGE
+		}
+		entry := entries.Add()
+		entry.SetMDUpdateAction(action)
+		entry.SetMDEntryType(entryType)
+		entry.SetSymbol(symbol)
+		entry.SetMDEntryPx(l.price, scale(l.price))
+		entry.SetMDEntrySize(l.qty, scale(l.qty))
+	}
+	for key, l := range previous {
+		if _, found := current[key]; found {
+			continue
+		}
+		entry := entries.Add()
+		entry.SetMDUpdateAction(enum.MDUpdateAction_DELETE)
+		entry.SetMDEntryType(entryType)
+		entry.SetSymbol(symbol)
+		entry.SetMDEntryPx(l.price, scale(l.price))
+	}
+}
@@ -0,0 +1,167 @@
+package simulator
+
+import (
+	"sort"
+
+	"github.com/quickfixgo/enum"
+	"github.com/shopspring/decimal"
+)
+
+type fill struct {
+	aggressor *simulatedOrder
+	resting   *simulatedOrder
+	qty       decimal.Decimal
+	price     decimal.Decimal
+	matchId   string
+}
+
+// orderBook keeps the resting orders of one symbol sorted by price then time priority.
+type orderBook struct {
+	symbol string
+	bids   []*simulatedOrder
+	asks   []*simulatedOrder
+
+	// changed tells the book changed since its market data was last published.
+	changed bool
+}
+
+// level is the aggregated quantity resting at a price.
+type level struct {
+	price decimal.Decimal
+	qty   decimal.Decimal
+}
+
+func newOrderBook(symbol string) *orderBook {
+	return &orderBook{
+		symbol: symbol,
+		bids:   make([]*simulatedOrder, 0),
+		asks:   make([]*simulatedOrder, 0),
+	}
+}
+
+func (b *orderBook) add(o *simulatedOrder) {
+	b.changed = true
+	if o.side == enum.Side_BUY {
+		idx := sort.Search(len(b.bids), func(i int) bool {
+			return b.bids[i].price.LessThan(o.price)
+		})
+		b.bids = insertAt(b.bids, idx, o)
+	} else {
+		idx := sort.Search(len(b.asks), func(i int) bool {
+			return b.asks[i].price.GreaterThan(o.price)
+		})
+		b.asks = insertAt(b.asks, idx, o)
+	}
+}
+
+func (b *orderBook) remove(o *simulatedOrder) bool {
+	b.changed = true
+	if o.side == enum.Side_BUY {
+		var found bool
+		b.bids, found = removeFrom(b.bids, o)
+		return found
+	}
+	var found bool
+	b.asks, found = removeFrom(b.asks, o)
+	return found
+}
+
+// match crosses the aggressive order against the opposite side of the book,
+// each fill being handed over before the next one so that it is reported with the
+// quantities of both orders at that time. Resting orders which are completely
+// filled are removed from the book. Icebergs trade their shown quantity, then
+// refill it from their hidden quantity and go to the back of their price level.
+func (b *orderBook) match(o *simulatedOrder, nextMatchId func() string, onFill func(f fill)) {
+	for o.leavesQty().IsPositive() {
+		var resting *simulatedOrder
+		opposite := b.opposite(o)
+		if len(opposite) == 0 || !crosses(o, opposite[0]) {
+			break
+		}
+		resting = opposite[0]
+
+		b.changed = true
+		qty := decimal.Min(o.leavesQty(), resting.visibleQty())
+		price := resting.price
+		o.execute(qty, price)
+		resting.execute(qty, price)
+		onFill(fill{
+			aggressor: o,
+			resting:   resting,
+			qty:       qty,
+			price:     price,
+			matchId:   nextMatchId(),
+		})
+
+		switch {
+		case !resting.leavesQty().IsPositive():
+			b.remove(resting)
+		case resting.isIceberg():
+			resting.shownQty = resting.shownQty.Sub(qty)
+			if !resting.shownQty.IsPositive() {
+				b.remove(resting)
+				resting.refill()
+				b.add(resting)
+			}
+		}
+	}
+}
+
+// opposite returns the side of the book an order matches with.
+func (b *orderBook) opposite(o *simulatedOrder) []*simulatedOrder {
+	if o.side == enum.Side_BUY {
+		return b.asks
+	}
+	return b.bids
+}
+
+// crosses tells whether an order can trade with a resting order, market orders
+// trading at any price.
+func crosses(o *simulatedOrder, resting *simulatedOrder) bool {
+	if o.isMarket() {
+		return true
+	}
+	if o.side == enum.Side_BUY {
+		return !resting.price.GreaterThan(o.price)
+	}
+	return !resting.price.LessThan(o.price)
+}
+
+// available returns the quantity an order would execute right away.
+func (b *orderBook) available(o *simulatedOrder) decimal.Decimal {
+	qty := decimal.Zero
+	for _, resting := range b.opposite(o) {
+		if !crosses(o, resting) {
+			break
+		}
+		qty = qty.Add(resting.leavesQty())
+	}
+	return qty
+}
+
+// levels aggregates the visible resting quantity of a side by price.
+func levels(orders []*simulatedOrder) map[string]level {
+	result := make(map[string]level)
+	for _, o := range orders {
+		key := o.price.String()
+		l := result[key]
+		result[key] = level{price: o.price, qty: l.qty.Add(o.visibleQty())}
+	}
+	return result
+}
+
+func insertAt(orders []*simulatedOrder, idx int, o *simulatedOrder) []*simulatedOrder {
+	orders = append(orders, nil)
+	copy(orders[idx+1:], orders[idx:])
+	orders[idx] = o
+	return orders
+}
+
+func removeFrom(orders []*simulatedOrder, o *simulatedOrder) ([]*simulatedOrder, bool) {
+	for i, resting := range orders {
+		if resting == o {
+			return append(orders[:i], orders[i+1:]...), true
+		}
+	}
+	return orders, false
+}
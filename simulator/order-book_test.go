@@ -0,0 +1,173 @@
+package simulator
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/shopspring/decimal"
+)
+
+func testOrder(id string, side enum.Side, qty int64, price string) *simulatedOrder {
+	o := &simulatedOrder{
+		orderId:  id,
+		clOrdId:  id,
+		symbol:   "XXX",
+		side:     side,
+		ordType:  enum.OrdType_LIMIT,
+		orderQty: decimal.NewFromInt(qty),
+	}
+	if len(price) == 0 {
+		o.ordType = enum.OrdType_MARKET
+	} else {
+		o.price = decimal.RequireFromString(price)
+	}
+	return o
+}
+
+func testIceberg(id string, side enum.Side, qty int64, display int64, price string) *simulatedOrder {
+	o := testOrder(id, side, qty, price)
+	o.displayQty = decimal.NewFromInt(display)
+	o.refill()
+	return o
+}
+
+// bookSide describes the orders of a side as "id:leaves@price" in priority order.
+func bookSide(orders []*simulatedOrder) []string {
+	result := make([]string, 0, len(orders))
+	for _, o := range orders {
+		result = append(result, fmt.Sprintf("%s:%s@%s", o.orderId, o.leavesQty(), o.price))
+	}
+	return result
+}
+
+func TestOrderBookMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		resting []*simulatedOrder
+		order   *simulatedOrder
+		// fills as "resting id:qty@price"
+		fills []string
+		bids  []string
+		asks  []string
+	}{
+		{
+			name:    "no cross",
+			resting: []*simulatedOrder{testOrder("a", enum.Side_SELL, 10, "101"), testOrder("b", enum.Side_BUY, 10, "99")},
+			order:   testOrder("x", enum.Side_BUY, 5, "100"),
+			bids:    []string{"b:10@99"},
+			asks:    []string{"a:10@101"},
+		},
+		{
+			name:    "best price first",
+			resting: []*simulatedOrder{testOrder("a", enum.Side_SELL, 10, "101"), testOrder("b", enum.Side_SELL, 10, "100"), testOrder("c", enum.Side_SELL, 10, "102")},
+			order:   testOrder("x", enum.Side_BUY, 15, "101"),
+			fills:   []string{"b:10@100", "a:5@101"},
+			bids:    []string{},
+			asks:    []string{"a:5@101", "c:10@102"},
+		},
+		{
+			name:    "time priority within a level",
+			resting: []*simulatedOrder{testOrder("a", enum.Side_BUY, 5, "100"), testOrder("b", enum.Side_BUY, 5, "100"), testOrder("c", enum.Side_BUY, 5, "101")},
+			order:   testOrder("x", enum.Side_SELL, 12, "100"),
+			fills:   []string{"c:5@101", "a:5@100", "b:2@100"},
+			bids:    []string{"b:3@100"},
+			asks:    []string{},
+		},
+		{
+			name:    "market order sweeps the book",
+			resting: []*simulatedOrder{testOrder("a", enum.Side_SELL, 5, "100"), testOrder("b", enum.Side_SELL, 5, "150")},
+			order:   testOrder("x", enum.Side_BUY, 20, ""),
+			fills:   []string{"a:5@100", "b:5@150"},
+			bids:    []string{},
+			asks:    []string{},
+		},
+		{
+			name:    "iceberg refilled at the back of its level",
+			resting: []*simulatedOrder{testIceberg("a", enum.Side_SELL, 30, 10, "100"), testOrder("b", enum.Side_SELL, 10, "100")},
+			order:   testOrder("x", enum.Side_BUY, 25, "100"),
+			fills:   []string{"a:10@100", "b:10@100", "a:5@100"},
+			bids:    []string{},
+			asks:    []string{"a:15@100"},
+		},
+		{
+			name:    "iceberg traded through its hidden quantity",
+			resting: []*simulatedOrder{testIceberg("a", enum.Side_BUY, 25, 10, "100")},
+			order:   testOrder("x", enum.Side_SELL, 30, "100"),
+			fills:   []string{"a:10@100", "a:10@100", "a:5@100"},
+			bids:    []string{},
+			asks:    []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			book := newOrderBook("XXX")
+			for _, o := range test.resting {
+				book.add(o)
+			}
+			matchId := 0
+			fills := make([]string, 0)
+			book.match(test.order, func() string { matchId++; return fmt.Sprint(matchId) }, func(f fill) {
+				if f.aggressor != test.order {
+					t.Fatalf("fill of %s instead of the aggressive order", f.aggressor.orderId)
+				}
+				fills = append(fills, fmt.Sprintf("%s:%s@%s", f.resting.orderId, f.qty, f.price))
+			})
+			if len(test.fills) == 0 {
+				test.fills = []string{}
+			}
+			if !reflect.DeepEqual(fills, test.fills) {
+				t.Fatalf("fills %v, expected %v", fills, test.fills)
+			}
+			if bids := bookSide(book.bids); test.bids != nil && !reflect.DeepEqual(bids, test.bids) {
+				t.Fatalf("bids %v, expected %v", bids, test.bids)
+			}
+			if asks := bookSide(book.asks); test.asks != nil && !reflect.DeepEqual(asks, test.asks) {
+				t.Fatalf("asks %v, expected %v", asks, test.asks)
+			}
+		})
+	}
+}
+
+func TestOrderBookAvailable(t *testing.T) {
+	book := newOrderBook("XXX")
+	book.add(testIceberg("a", enum.Side_SELL, 30, 5, "100"))
+	book.add(testOrder("b", enum.Side_SELL, 10, "101"))
+	book.add(testOrder("c", enum.Side_SELL, 10, "103"))
+
+	tests := []struct {
+		name  string
+		order *simulatedOrder
+		want  int64
+	}{
+		{"below the best offer", testOrder("x", enum.Side_BUY, 10, "99"), 0},
+		{"hidden quantity of the iceberg", testOrder("x", enum.Side_BUY, 10, "100"), 30},
+		{"several levels", testOrder("x", enum.Side_BUY, 10, "102"), 40},
+		{"market order", testOrder("x", enum.Side_BUY, 10, ""), 50},
+		{"same side", testOrder("x", enum.Side_SELL, 10, "90"), 0},
+	}
+	for _, test := range tests {
+		if got := book.available(test.order); !got.Equal(decimal.NewFromInt(test.want)) {
+			t.Errorf("%s: %s available, expected %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLevels(t *testing.T) {
+	book := newOrderBook("XXX")
+	book.add(testOrder("a", enum.Side_BUY, 10, "100"))
+	book.add(testIceberg("b", enum.Side_BUY, 50, 5, "100"))
+	book.add(testOrder("c", enum.Side_BUY, 7, "99.5"))
+
+	got := levels(book.bids)
+	want := map[string]int64{"100": 15, "99.5": 7}
+	if len(got) != len(want) {
+		t.Fatalf("%d levels, expected %d", len(got), len(want))
+	}
+	for price, qty := range want {
+		if l, found := got[price]; !found || !l.qty.Equal(decimal.NewFromInt(qty)) {
+			t.Fatalf("level %s: %v, expected %d shown", price, l.qty, qty)
+		}
+	}
+}
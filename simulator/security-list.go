@@ -0,0 +1,58 @@
+package simulator
+
+import (
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/fix50sp2/securitylist"
+	"github.com/quickfixgo/fix50sp2/securitylistrequest"
+	"github.com/quickfixgo/quickfix"
+
+	"github.com/alexppxela/order-gatling/order"
+)
+
+// securityListFragment is the maximum number of securities sent in one SecurityList message.
+const securityListFragment = 50
+
+// ListSecurities sets the securities sent in answer to security list requests.
+func (a *VenueApp) ListSecurities(securities []order.Security) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.securities = securities
+}
+
+func (a *VenueApp) onSecurityListRequest(msg securitylistrequest.SecurityListRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	reqId, err := msg.GetSecurityReqID()
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	securities := a.securities
+	a.lock.Unlock()
+
+	if len(securities) == 0 {
+		list := securitylist.New()
+		list.SetSecurityReqID(reqId)
+		list.SetSecurityResponseID(a.nextId("SL"))
+		list.SetSecurityRequestResult(enum.SecurityRequestResult_NO_INSTRUMENTS_FOUND_THAT_MATCH_SELECTION_CRITERIA)
+		a.send(list, sessionID)
+		return nil
+	}
+
+	responseId := a.nextId("SL")
+	for start := 0; start < len(securities); start += securityListFragment {
+		end := min(start+securityListFragment, len(securities))
+		list := securitylist.New()
+		list.SetSecurityReqID(reqId)
+		list.SetSecurityResponseID(responseId)
+		list.SetSecurityRequestResult(enum.SecurityRequestResult_VALID_REQUEST)
+		list.SetTotNoRelatedSym(len(securities))
+		list.SetLastFragment(end == len(securities))
+		group := order.NewSecurityListGroup()
+		for _, security := range securities[start:end] {
+			security.Write(group.Add())
+		}
+		list.Body.SetGroup(group)
+		a.send(list, sessionID)
+	}
+	a.Logger.Info().Int("securities", len(securities)).Msg("SecurityList sent")
+	return nil
+}
@@ -0,0 +1,51 @@
+package simulator
+
+import (
+	"time"
+
+	"github.com/quickfixgo/quickfix"
+
+	"github.com/alexppxela/order-gatling/order"
+)
+
+// recordTrade keeps a fill of an order for the trade capture reports of its
+// session. Must be called with the lock held.
+func (a *VenueApp) recordTrade(o *simulatedOrder, f fill, execId string) {
+	a.trades[o.sessionId] = append(a.trades[o.sessionId], order.Trade{
+		ReportID: a.nextId("TR"),
+		ExecID:   execId,
+		OrderID:  o.orderId,
+		ClOrdID:  o.clOrdId,
+		Account:  o.account,
+		Symbol:   o.symbol,
+		Side:     o.side,
+		Qty:      f.qty,
+		Price:    f.price,
+		Time:     time.Now(),
+	})
+}
+
+// onTradeCaptureReportRequest answers with the trades of the session executed
+// between the times of the request, a missing time leaving the range open.
+func (a *VenueApp) onTradeCaptureReportRequest(msg *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	request, err := order.ReadTradeCaptureReportRequest(msg)
+	if err != nil {
+		return err
+	}
+	a.lock.Lock()
+	trades := make([]order.Trade, 0)
+	for _, trade := range a.trades[sessionID] {
+		if trade.Time.Before(request.From) || (!request.To.IsZero() && trade.Time.After(request.To)) {
+			continue
+		}
+		trades = append(trades, trade)
+	}
+	a.lock.Unlock()
+
+	a.send(order.NewTradeCaptureReportRequestAck(request.ID, len(trades), ""), sessionID)
+	for i, trade := range trades {
+		a.send(order.NewTradeCaptureReport(request.ID, len(trades), i == len(trades)-1, trade), sessionID)
+	}
+	a.Logger.Info().Int("trades", len(trades)).Msg("TradeCaptureReports sent")
+	return nil
+}
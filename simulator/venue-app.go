@@ -0,0 +1,795 @@
+package simulator
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/executionreport"
+	"github.com/quickfixgo/fix50sp2/marketdatarequest"
+	"github.com/quickfixgo/fix50sp2/newordersingle"
+	"github.com/quickfixgo/fix50sp2/ordercancelreject"
+	"github.com/quickfixgo/fix50sp2/ordercancelreplacerequest"
+	"github.com/quickfixgo/fix50sp2/ordercancelrequest"
+	"github.com/quickfixgo/fix50sp2/ordermasscancelreport"
+	"github.com/quickfixgo/fix50sp2/ordermasscancelrequest"
+	"github.com/quickfixgo/fix50sp2/quote"
+	"github.com/quickfixgo/fix50sp2/quotecancel"
+	"github.com/quickfixgo/fix50sp2/quotestatusreport"
+	"github.com/quickfixgo/fix50sp2/securitylistrequest"
+	"github.com/quickfixgo/quickfix"
+	"github.com/quickfixgo/tag"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	"sylr.dev/fix/config"
+	"sylr.dev/fix/pkg/acceptor"
+	fixutils "sylr.dev/fix/pkg/utils"
+
+	"github.com/alexppxela/order-gatling/order"
+)
+
+type simulatedOrder struct {
+	orderId     string
+	clOrdId     string
+	account     string
+	symbol      string
+	side        enum.Side
+	ordType     enum.OrdType
+	timeInForce enum.TimeInForce
+	price       decimal.Decimal
+	stopPx      decimal.Decimal
+	orderQty    decimal.Decimal
+	displayQty  decimal.Decimal
+	shownQty    decimal.Decimal
+	minQty      decimal.Decimal
+	cumQty      decimal.Decimal
+	notional    decimal.Decimal
+	isQuote     bool
+	sessionId   quickfix.SessionID
+}
+
+func (o *simulatedOrder) leavesQty() decimal.Decimal {
+	return o.orderQty.Sub(o.cumQty)
+}
+
+// isIceberg tells whether only part of the quantity of the order is shown.
+func (o *simulatedOrder) isIceberg() bool {
+	return o.displayQty.IsPositive() && o.displayQty.LessThan(o.orderQty)
+}
+
+// visibleQty is the quantity of the order shown in the book, which is all of it
+// unless it is an iceberg.
+func (o *simulatedOrder) visibleQty() decimal.Decimal {
+	if !o.isIceberg() {
+		return o.leavesQty()
+	}
+	return decimal.Min(o.shownQty, o.leavesQty())
+}
+
+// refill shows a new slice of an iceberg from its hidden quantity.
+func (o *simulatedOrder) refill() {
+	o.shownQty = decimal.Min(o.displayQty, o.leavesQty())
+}
+
+func (o *simulatedOrder) avgPx() decimal.Decimal {
+	if o.cumQty.IsZero() {
+		return decimal.Zero
+	}
+	return o.notional.Div(o.cumQty)
+}
+
+func (o *simulatedOrder) execute(qty decimal.Decimal, price decimal.Decimal) {
+	o.cumQty = o.cumQty.Add(qty)
+	o.notional = o.notional.Add(qty.Mul(price))
+}
+
+// isMarket tells whether the order executes at any price.
+func (o *simulatedOrder) isMarket() bool {
+	return o.ordType == enum.OrdType_MARKET
+}
+
+// isStop tells whether the order waits for its stop price. Stops are never
+// triggered by the simulator, they only rest until they are cancelled.
+func (o *simulatedOrder) isStop() bool {
+	return o.ordType == enum.OrdType_STOP_STOP_LOSS || o.ordType == enum.OrdType_STOP_LIMIT
+}
+
+// isImmediate tells whether the quantity left after matching is cancelled
+// instead of resting on the book.
+func (o *simulatedOrder) isImmediate() bool {
+	return o.isMarket() ||
+		o.timeInForce == enum.TimeInForce_IMMEDIATE_OR_CANCEL ||
+		o.timeInForce == enum.TimeInForce_FILL_OR_KILL
+}
+
+func (o *simulatedOrder) status() enum.OrdStatus {
+	switch {
+	case !o.leavesQty().IsPositive():
+		return enum.OrdStatus_FILLED
+	case o.cumQty.IsPositive():
+		return enum.OrdStatus_PARTIALLY_FILLED
+	default:
+		return enum.OrdStatus_NEW
+	}
+}
+
+type simulatedQuote struct {
+	quoteId   string
+	account   string
+	symbol    string
+	bid       *simulatedOrder
+	offer     *simulatedOrder
+	sessionId quickfix.SessionID
+}
+
+type VenueApp struct {
+	// Logger.
+	fixutils.QuickFixAppMessageLogger
+
+	// Quickfix settings of the simulated venue.
+	settings *quickfix.Settings
+
+	// Quickfix acceptor.
+	acceptor *quickfix.Acceptor
+
+	// Message router.
+	*quickfix.MessageRouter
+
+	// orders holds resting orders indexed by their current ClOrdID.
+	orders map[string]*simulatedOrder
+
+	// quotes holds live quotes indexed by session, account and symbol.
+	quotes map[string]*simulatedQuote
+
+	// books holds the order book of each symbol.
+	books map[string]*orderBook
+
+	// securities is the security list of the venue.
+	securities []order.Security
+
+	// subscriptions are the market data subscriptions of the sessions.
+	subscriptions []*subscription
+
+	// published holds the price levels of each book as last published.
+	published map[string]*publishedBook
+
+	// trades holds the fills of the orders of each session.
+	trades map[quickfix.SessionID][]order.Trade
+
+	// dropCopy is the session receiving a copy of every execution report, if any.
+	dropCopy   *quickfix.SessionID
+	dropCopyUp atomic.Bool
+
+	lock sync.Mutex
+
+	lastId atomic.Uint64
+}
+
+var (
+	_ quickfix.Application = (*VenueApp)(nil)
+)
+
+// NewVenueApp creates an Application which acts as a simulated exchange.
+func NewVenueApp(quickFixAppMessageLogger fixutils.QuickFixAppMessageLogger, settings *quickfix.Settings) *VenueApp {
+	app := VenueApp{
+		QuickFixAppMessageLogger: quickFixAppMessageLogger,
+		MessageRouter:            quickfix.NewMessageRouter(),
+		settings:                 settings,
+		orders:                   make(map[string]*simulatedOrder),
+		quotes:                   make(map[string]*simulatedQuote),
+		books:                    make(map[string]*orderBook),
+		published:                make(map[string]*publishedBook),
+		trades:                   make(map[quickfix.SessionID][]order.Trade),
+	}
+
+	app.MessageRouter.AddRoute(newordersingle.Route(app.onNewOrderSingle))
+	app.MessageRouter.AddRoute(ordercancelreplacerequest.Route(app.onOrderCancelReplaceRequest))
+	app.MessageRouter.AddRoute(ordercancelrequest.Route(app.onOrderCancelRequest))
+	app.MessageRouter.AddRoute(ordermasscancelrequest.Route(app.onOrderMassCancelRequest))
+	app.MessageRouter.AddRoute(quote.Route(app.onQuote))
+	app.MessageRouter.AddRoute(quotecancel.Route(app.onQuoteCancel))
+	app.MessageRouter.AddRoute(securitylistrequest.Route(app.onSecurityListRequest))
+	app.MessageRouter.AddRoute(marketdatarequest.Route(app.onMarketDataRequest))
+	app.MessageRouter.AddRoute(quickfix.ApplVerIDFIX50SP2, string(enum.MsgType_TRADE_CAPTURE_REPORT_REQUEST), app.onTradeCaptureReportRequest)
+
+	return &app
+}
+
+func (a *VenueApp) Start() error {
+	opt := config.GetOptions()
+	var quickfixLogger *zerolog.Logger
+	if opt.QuickFixLogging {
+		quickfixLogger = a.Logger
+	}
+	var err error
+	a.acceptor, err = acceptor.NewAcceptor(a, a.settings, quickfixLogger)
+	if err != nil {
+		return fmt.Errorf("unable to create simulator acceptor: %s", err)
+	}
+
+	err = a.acceptor.Start()
+	if err != nil {
+		return fmt.Errorf("unable to start simulator acceptor: %s", err)
+	}
+	return nil
+}
+
+func (a *VenueApp) Stop() {
+	if a.acceptor != nil {
+		a.acceptor.Stop()
+	}
+}
+
+// OnCreate is called when a session is created. Note that sessions are created
+// upon initiator/acceptor start and not when a connection is established.
+func (a *VenueApp) OnCreate(sessionID quickfix.SessionID) {
+	a.Logger.Debug().Str("session", sessionID.String()).Msg("Created")
+}
+
+// OnLogon is called when a FIX logon occurs.
+func (a *VenueApp) OnLogon(sessionID quickfix.SessionID) {
+	a.Logger.Info().Str("session", sessionID.String()).Msg("Successful Logon")
+	if a.isDropCopy(sessionID) {
+		a.dropCopyUp.Store(true)
+	}
+}
+
+// OnLogout is called when a FIX logout occurs.
+func (a *VenueApp) OnLogout(sessionID quickfix.SessionID) {
+	a.Logger.Info().Str("session", sessionID.String()).Msg("Successful Logout")
+	a.unsubscribe(sessionID)
+	if a.isDropCopy(sessionID) {
+		a.dropCopyUp.Store(false)
+	}
+}
+
+// ToAdmin is called when sending a FIX message regarding the FIX protocol, e.g.:
+// LOGIN, LOGOUT, HEARTBEAT, TEST ... etc.
+func (a *VenueApp) ToAdmin(message *quickfix.Message, sessionID quickfix.SessionID) {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, true)
+}
+
+// FromAdmin is called when receiving a FIX message regarding the FIX protocol, e.g.:
+// LOGIN, LOGOUT, HEARTBEAT, TEST ... etc.
+func (a *VenueApp) FromAdmin(message *quickfix.Message, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, false)
+	return nil
+}
+
+// ToApp is called when sending a FIX message that is not considered "Admin".
+func (a *VenueApp) ToApp(message *quickfix.Message, sessionID quickfix.SessionID) error {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, true)
+	a.copyExecutionReport(message, sessionID)
+	return nil
+}
+
+// FromApp is called when receiving a FIX message that is not considered "Admin".
+func (a *VenueApp) FromApp(message *quickfix.Message, sessionID quickfix.SessionID) (reject quickfix.MessageRejectError) {
+	a.LogMessage(zerolog.TraceLevel, message, sessionID, false)
+	return a.MessageRouter.Route(message, sessionID)
+}
+
+func (a *VenueApp) nextId(prefix string) string {
+	return fmt.Sprintf("%s%d", prefix, a.lastId.Add(1))
+}
+
+func (a *VenueApp) getBook(symbol string) *orderBook {
+	book, found := a.books[symbol]
+	if !found {
+		book = newOrderBook(symbol)
+		a.books[symbol] = book
+	}
+	return book
+}
+
+func (a *VenueApp) onNewOrderSingle(msg newordersingle.NewOrderSingle, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	clOrdId, err := msg.GetClOrdID()
+	if err != nil {
+		return err
+	}
+	side, err := msg.GetSide()
+	if err != nil {
+		return err
+	}
+	symbol, err := msg.GetSymbol()
+	if err != nil {
+		return err
+	}
+	qty, err := msg.GetOrderQty()
+	if err != nil {
+		return err
+	}
+	ordType, err := msg.GetOrdType()
+	if err != nil {
+		return err
+	}
+	timeInForce, err := msg.GetTimeInForce()
+	if err != nil {
+		timeInForce = enum.TimeInForce_DAY
+	}
+	parties, _ := msg.GetNoPartyIDs()
+
+	order := &simulatedOrder{
+		orderId:     a.nextId("O"),
+		clOrdId:     clOrdId,
+		account:     getAccount(parties.RepeatingGroup),
+		symbol:      symbol,
+		side:        side,
+		ordType:     ordType,
+		timeInForce: timeInForce,
+		orderQty:    qty,
+		cumQty:      decimal.Zero,
+		notional:    decimal.Zero,
+		sessionId:   sessionID,
+	}
+	if rej := readOrderPrices(msg.Body, order); rej != nil {
+		return rej
+	}
+	readOrderQuantities(msg.Body, order)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	defer a.publishMarketData()
+	a.orders[clOrdId] = order
+	a.sendExecutionReport(order, enum.ExecType_NEW, enum.OrdStatus_NEW, "")
+	if order.isStop() {
+		return nil
+	}
+	a.matchOrder(order)
+	return nil
+}
+
+// readOrderPrices reads the limit and stop prices the type of the order needs.
+// Pegged orders rest at their limit price.
+func readOrderPrices(body *quickfix.Body, o *simulatedOrder) quickfix.MessageRejectError {
+	if o.isStop() {
+		var stopPx quickfix.FIXDecimal
+		if err := body.GetField(order.TagStopPx, &stopPx); err != nil {
+			return err
+		}
+		o.stopPx = stopPx.Decimal
+	}
+	if o.isMarket() || o.ordType == enum.OrdType_STOP_STOP_LOSS {
+		return nil
+	}
+	var price field.PriceField
+	if err := body.Get(&price); err != nil {
+		return err
+	}
+	o.price = price.Value()
+	return nil
+}
+
+// readOrderQuantities reads the displayed and minimum quantities of the order,
+// which are optional.
+func readOrderQuantities(body *quickfix.Body, o *simulatedOrder) {
+	o.displayQty, o.minQty = decimal.Zero, decimal.Zero
+	var qty quickfix.FIXDecimal
+	if err := body.GetField(order.TagDisplayQty, &qty); err == nil {
+		o.displayQty = qty.Decimal
+	}
+	if err := body.GetField(order.TagMinQty, &qty); err == nil {
+		o.minQty = qty.Decimal
+	}
+}
+
+func (a *VenueApp) onOrderCancelReplaceRequest(msg ordercancelreplacerequest.OrderCancelReplaceRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	clOrdId, err := msg.GetClOrdID()
+	if err != nil {
+		return err
+	}
+	origClOrdId, err := msg.GetOrigClOrdID()
+	if err != nil {
+		return err
+	}
+	qty, err := msg.GetOrderQty()
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	defer a.publishMarketData()
+	order, found := a.orders[origClOrdId]
+	if !found {
+		a.sendOrderCancelReject(clOrdId, origClOrdId, enum.CxlRejResponseTo_ORDER_CANCEL_REPLACE_REQUEST, sessionID)
+		return nil
+	}
+	replaced := *order
+	if rej := readOrderPrices(msg.Body, &replaced); rej != nil {
+		return rej
+	}
+	readOrderQuantities(msg.Body, &replaced)
+
+	// A replaced order loses its time priority.
+	book := a.getBook(order.symbol)
+	book.remove(order)
+	delete(a.orders, origClOrdId)
+	order.clOrdId = clOrdId
+	order.price = replaced.price
+	order.stopPx = replaced.stopPx
+	order.orderQty = qty
+	order.displayQty = replaced.displayQty
+	order.minQty = replaced.minQty
+
+	// Reducing the quantity below the executed quantity completes the order.
+	if !order.leavesQty().IsPositive() {
+		order.orderQty = order.cumQty
+		a.sendExecutionReport(order, enum.ExecType_REPLACED, enum.OrdStatus_FILLED, origClOrdId)
+		return nil
+	}
+
+	a.orders[clOrdId] = order
+	status := enum.OrdStatus_REPLACED
+	if order.cumQty.IsPositive() {
+		status = enum.OrdStatus_PARTIALLY_FILLED
+	}
+	a.sendExecutionReport(order, enum.ExecType_REPLACED, status, origClOrdId)
+	if !order.isStop() {
+		a.matchOrder(order)
+	}
+	return nil
+}
+
+func (a *VenueApp) onOrderCancelRequest(msg ordercancelrequest.OrderCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	clOrdId, err := msg.GetClOrdID()
+	if err != nil {
+		return err
+	}
+	origClOrdId, err := msg.GetOrigClOrdID()
+	if err != nil {
+		return err
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	defer a.publishMarketData()
+	order, found := a.orders[origClOrdId]
+	if !found {
+		a.sendOrderCancelReject(clOrdId, origClOrdId, enum.CxlRejResponseTo_ORDER_CANCEL_REQUEST, sessionID)
+		return nil
+	}
+	a.getBook(order.symbol).remove(order)
+	delete(a.orders, origClOrdId)
+	order.clOrdId = clOrdId
+	a.sendExecutionReport(order, enum.ExecType_CANCELED, enum.OrdStatus_CANCELED, origClOrdId)
+	return nil
+}
+
+func (a *VenueApp) onOrderMassCancelRequest(msg ordermasscancelrequest.OrderMassCancelRequest, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	clOrdId, err := msg.GetClOrdID()
+	if err != nil {
+		return err
+	}
+	requestType, err := msg.GetMassCancelRequestType()
+	if err != nil {
+		return err
+	}
+	symbol, _ := msg.GetSymbol()
+	side, _ := msg.GetSide()
+	parties, _ := msg.GetNoPartyIDs()
+	account := getAccount(parties.RepeatingGroup)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	defer a.publishMarketData()
+	for id, order := range a.orders {
+		if order.sessionId != sessionID {
+			continue
+		}
+		if len(symbol) > 0 && order.symbol != symbol {
+			continue
+		}
+		if len(side) > 0 && order.side != side {
+			continue
+		}
+		if len(account) > 0 && order.account != account {
+			continue
+		}
+		delete(a.orders, id)
+		a.getBook(order.symbol).remove(order)
+		a.sendExecutionReport(order, enum.ExecType_CANCELED, enum.OrdStatus_CANCELED, "")
+	}
+
+	report := ordermasscancelreport.New(
+		field.NewMassActionReportID(a.nextId("M")),
+		field.NewMassCancelRequestType(requestType),
+		field.NewMassCancelResponse(enum.MassCancelResponse(requestType)),
+	)
+	report.SetClOrdID(clOrdId)
+	report.SetOrderID(a.nextId("O"))
+	report.SetTransactTime(time.Now())
+	if len(symbol) > 0 {
+		report.SetSymbol(symbol)
+	}
+	if len(side) > 0 {
+		report.SetSide(side)
+	}
+	a.send(report, sessionID)
+	return nil
+}
+
+func (a *VenueApp) onQuote(msg quote.Quote, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	quoteId, err := msg.GetQuoteID()
+	if err != nil {
+		return err
+	}
+	symbol, err := msg.GetSymbol()
+	if err != nil {
+		return err
+	}
+	parties, _ := msg.GetNoPartyIDs()
+	account := getAccount(parties.RepeatingGroup)
+
+	q := &simulatedQuote{
+		quoteId:   quoteId,
+		account:   account,
+		symbol:    symbol,
+		sessionId: sessionID,
+	}
+	bidPx, bidErr := msg.GetBidPx()
+	bidSize, bidSizeErr := msg.GetBidSize()
+	if bidErr == nil && bidSizeErr == nil && bidSize.IsPositive() {
+		q.bid = a.newQuoteSide(q, enum.Side_BUY, bidPx, bidSize)
+	}
+	offerPx, offerErr := msg.GetOfferPx()
+	offerSize, offerSizeErr := msg.GetOfferSize()
+	if offerErr == nil && offerSizeErr == nil && offerSize.IsPositive() {
+		q.offer = a.newQuoteSide(q, enum.Side_SELL, offerPx, offerSize)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	defer a.publishMarketData()
+	key := quoteKey(sessionID, account, symbol)
+	if previous, found := a.quotes[key]; found {
+		a.removeQuote(previous)
+	}
+	a.quotes[key] = q
+
+	report := quotestatusreport.New()
+	report.SetQuoteID(quoteId)
+	report.SetQuoteStatus(enum.QuoteStatus_ACCEPTED)
+	report.SetSymbol(symbol)
+	report.SetBidQuoteID(quoteId + "-B")
+	report.SetOfferQuoteID(quoteId + "-O")
+	if q.bid != nil {
+		report.SetBidPx(bidPx, scale(bidPx))
+		report.SetBidSize(bidSize, scale(bidSize))
+	}
+	if q.offer != nil {
+		report.SetOfferPx(offerPx, scale(offerPx))
+		report.SetOfferSize(offerSize, scale(offerSize))
+	}
+	report.SetTransactTime(time.Now())
+	a.send(report, sessionID)
+
+	if q.bid != nil {
+		a.matchOrder(q.bid)
+	}
+	if q.offer != nil {
+		a.matchOrder(q.offer)
+	}
+	return nil
+}
+
+func (a *VenueApp) newQuoteSide(q *simulatedQuote, side enum.Side, price decimal.Decimal, size decimal.Decimal) *simulatedOrder {
+	suffix := "-B"
+	if side == enum.Side_SELL {
+		suffix = "-O"
+	}
+	return &simulatedOrder{
+		orderId:   q.quoteId + suffix,
+		clOrdId:   q.quoteId,
+		account:   q.account,
+		symbol:    q.symbol,
+		side:      side,
+		price:     price,
+		orderQty:  size,
+		cumQty:    decimal.Zero,
+		notional:  decimal.Zero,
+		isQuote:   true,
+		sessionId: q.sessionId,
+	}
+}
+
+func (a *VenueApp) removeQuote(q *simulatedQuote) {
+	book := a.getBook(q.symbol)
+	if q.bid != nil {
+		book.remove(q.bid)
+	}
+	if q.offer != nil {
+		book.remove(q.offer)
+	}
+}
+
+func (a *VenueApp) onQuoteCancel(msg quotecancel.QuoteCancel, sessionID quickfix.SessionID) quickfix.MessageRejectError {
+	cancelType, err := msg.GetQuoteCancelType()
+	if err != nil {
+		return err
+	}
+	quoteId, _ := msg.GetQuoteID()
+	// The quotes of the session are cancelled, only the ones of the account when
+	// the cancel gives one
+	account, _ := msg.GetAccount()
+	if len(account) == 0 {
+		parties, _ := msg.GetNoPartyIDs()
+		account = getAccount(parties.RepeatingGroup)
+	}
+
+	symbols := make(map[string]bool)
+	if entries, err := msg.GetNoQuoteEntries(); err == nil {
+		for i := 0; i < entries.Len(); i++ {
+			if symbol, err := entries.Get(i).GetSymbol(); err == nil {
+				symbols[symbol] = true
+			}
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	defer a.publishMarketData()
+	for key, q := range a.quotes {
+		if q.sessionId != sessionID || (len(account) > 0 && q.account != account) {
+			continue
+		}
+		if cancelType == enum.QuoteCancelType_CANCEL_FOR_ONE_OR_MORE_SECURITIES && !symbols[q.symbol] {
+			continue
+		}
+		a.removeQuote(q)
+		delete(a.quotes, key)
+	}
+
+	report := quotestatusreport.New()
+	report.SetQuoteID(quoteId)
+	report.SetQuoteStatus(enum.QuoteStatus_CANCELED)
+	report.SetQuoteCancelType(cancelType)
+	report.SetTransactTime(time.Now())
+	a.send(report, sessionID)
+	return nil
+}
+
+// matchOrder crosses an incoming order with the book, reports the trades to
+// both sides and rests the remaining quantity, or cancels it for market, immediate
+// or cancel and fill or kill orders. Fill or kill orders which can't be filled
+// right away and orders crossing the book without being able to execute their
+// minimum quantity are cancelled. Must be called with the lock held.
+func (a *VenueApp) matchOrder(order *simulatedOrder) {
+	book := a.getBook(order.symbol)
+	available := book.available(order)
+	if order.timeInForce == enum.TimeInForce_FILL_OR_KILL && available.LessThan(order.leavesQty()) {
+		a.cancelRemainder(order)
+		return
+	}
+	if available.IsPositive() && available.LessThan(decimal.Min(order.minQty, order.leavesQty())) {
+		a.cancelRemainder(order)
+		return
+	}
+	book.match(order, func() string { return a.nextId("T") }, func(f fill) {
+		a.sendTrade(f.resting, f)
+		a.sendTrade(f.aggressor, f)
+	})
+	switch {
+	case !order.leavesQty().IsPositive():
+		if !order.isQuote {
+			delete(a.orders, order.clOrdId)
+		}
+	case order.isImmediate():
+		a.cancelRemainder(order)
+	default:
+		order.refill()
+		book.add(order)
+	}
+}
+
+// cancelRemainder ends an order which can't rest on the book. Must be called with the lock held.
+func (a *VenueApp) cancelRemainder(order *simulatedOrder) {
+	delete(a.orders, order.clOrdId)
+	a.sendExecutionReport(order, enum.ExecType_CANCELED, enum.OrdStatus_CANCELED, "")
+}
+
+func (a *VenueApp) sendTrade(order *simulatedOrder, f fill) {
+	status := order.status()
+	if status == enum.OrdStatus_FILLED && !order.isQuote && order != f.aggressor {
+		delete(a.orders, order.clOrdId)
+	}
+	report := a.buildExecutionReport(order, enum.ExecType_TRADE, status, "")
+	report.SetLastQty(f.qty, scale(f.qty))
+	report.SetLastPx(f.price, scale(f.price))
+	report.SetTrdMatchID(f.matchId)
+	execId, _ := report.GetExecID()
+	a.recordTrade(order, f, execId)
+	a.send(report, order.sessionId)
+}
+
+func (a *VenueApp) sendExecutionReport(order *simulatedOrder, execType enum.ExecType, status enum.OrdStatus, origClOrdId string) {
+	a.send(a.buildExecutionReport(order, execType, status, origClOrdId), order.sessionId)
+}
+
+func (a *VenueApp) buildExecutionReport(order *simulatedOrder, execType enum.ExecType, status enum.OrdStatus, origClOrdId string) executionreport.ExecutionReport {
+	leavesQty := order.leavesQty()
+	if status == enum.OrdStatus_CANCELED {
+		leavesQty = decimal.Zero
+	}
+	report := executionreport.New(
+		field.NewOrderID(order.orderId),
+		field.NewExecID(a.nextId("E")),
+		field.NewExecType(execType),
+		field.NewOrdStatus(status),
+		field.NewSide(order.side),
+		field.NewLeavesQty(leavesQty, scale(order.orderQty)),
+		field.NewCumQty(order.cumQty, scale(order.orderQty)),
+	)
+	report.SetClOrdID(order.clOrdId)
+	if len(origClOrdId) > 0 {
+		report.SetOrigClOrdID(origClOrdId)
+	}
+	report.SetSymbol(order.symbol)
+	report.SetOrderQty(order.orderQty, scale(order.orderQty))
+	if order.price.IsPositive() {
+		report.SetPrice(order.price, scale(order.price))
+	}
+	report.SetAvgPx(order.avgPx(), scale(order.price)+2)
+	if order.isQuote {
+		report.SetOrdType(enum.OrdType_LIMIT)
+	} else {
+		report.SetOrdType(order.ordType)
+		report.SetTimeInForce(order.timeInForce)
+	}
+	report.SetTransactTime(time.Now())
+	if len(order.account) > 0 {
+		report.SetAccount(order.account)
+	}
+	return report
+}
+
+func (a *VenueApp) sendOrderCancelReject(clOrdId string, origClOrdId string, responseTo enum.CxlRejResponseTo, sessionID quickfix.SessionID) {
+	reject := ordercancelreject.New(
+		field.NewOrderID("NONE"),
+		field.NewClOrdID(clOrdId),
+		field.NewOrdStatus(enum.OrdStatus_REJECTED),
+		field.NewCxlRejResponseTo(responseTo),
+	)
+	reject.SetOrigClOrdID(origClOrdId)
+	reject.SetCxlRejReason(enum.CxlRejReason_UNKNOWN_ORDER)
+	reject.SetText("unknown order")
+	a.send(reject, sessionID)
+}
+
+func (a *VenueApp) send(message quickfix.Messagable, sessionID quickfix.SessionID) {
+	if err := quickfix.SendToTarget(message, sessionID); err != nil {
+		a.Logger.Error().Err(err).Str("session", sessionID.String()).Msg("Cannot send message")
+	}
+}
+
+func getAccount(parties *quickfix.RepeatingGroup) string {
+	if parties == nil {
+		return ""
+	}
+	for i := 0; i < parties.Len(); i++ {
+		party := parties.Get(i)
+		role, err := party.GetString(tag.PartyRole)
+		if err != nil || enum.PartyRole(role) != enum.PartyRole_CUSTOMER_ACCOUNT {
+			continue
+		}
+		account, err := party.GetString(tag.PartyID)
+		if err == nil {
+			return account
+		}
+	}
+	return ""
+}
+
+func quoteKey(sessionID quickfix.SessionID, account string, symbol string) string {
+	return fmt.Sprintf("%s|%s|%s", sessionID.String(), account, symbol)
+}
+
+func scale(d decimal.Decimal) int32 {
+	if d.Exponent() < 0 {
+		return -d.Exponent()
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package simulator
+
+import (
+	"testing"
+
+	"github.com/quickfixgo/enum"
+	"github.com/quickfixgo/field"
+	"github.com/quickfixgo/fix50sp2/quote"
+	"github.com/quickfixgo/fix50sp2/quotecancel"
+	"github.com/quickfixgo/quickfix"
+	"github.com/rs/zerolog"
+	"github.com/shopspring/decimal"
+	fixutils "sylr.dev/fix/pkg/utils"
+)
+
+func TestMatchOrderRemainder(t *testing.T) {
+	withTimeInForce := func(o *simulatedOrder, timeInForce enum.TimeInForce) *simulatedOrder {
+		o.timeInForce = timeInForce
+		return o
+	}
+	withMinQty := func(o *simulatedOrder, minQty int64) *simulatedOrder {
+		o.minQty = decimal.NewFromInt(minQty)
+		return o
+	}
+
+	tests := []struct {
+		name  string
+		order *simulatedOrder
+		// filled is the executed quantity of the order, which rests when it is live
+		filled int64
+		live   bool
+	}{
+		{"limit order rests", testOrder("x", enum.Side_BUY, 20, "101"), 15, true},
+		{"market remainder cancelled", testOrder("x", enum.Side_BUY, 20, ""), 15, false},
+		{"immediate or cancel remainder cancelled", withTimeInForce(testOrder("x", enum.Side_BUY, 20, "101"), enum.TimeInForce_IMMEDIATE_OR_CANCEL), 15, false},
+		{"fill or kill filled", withTimeInForce(testOrder("x", enum.Side_BUY, 15, "101"), enum.TimeInForce_FILL_OR_KILL), 15, false},
+		{"fill or kill killed", withTimeInForce(testOrder("x", enum.Side_BUY, 20, "101"), enum.TimeInForce_FILL_OR_KILL), 0, false},
+		{"minimum quantity executed", withMinQty(testOrder("x", enum.Side_BUY, 20, "101"), 15), 15, true},
+		{"minimum quantity not available", withMinQty(testOrder("x", enum.Side_BUY, 20, "100"), 11), 0, false},
+		{"minimum quantity without cross", withMinQty(testOrder("x", enum.Side_BUY, 20, "99"), 11), 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			// Sessions are not registered in quickfix, reports are built then dropped
+			app := NewVenueApp(fixutils.QuickFixAppMessageLogger{Logger: &logger}, nil)
+			book := app.getBook("XXX")
+			for _, resting := range []*simulatedOrder{testOrder("a", enum.Side_SELL, 10, "100"), testOrder("b", enum.Side_SELL, 5, "101")} {
+				app.orders[resting.clOrdId] = resting
+				book.add(resting)
+			}
+			app.orders[test.order.clOrdId] = test.order
+
+			app.matchOrder(test.order)
+			if !test.order.cumQty.Equal(decimal.NewFromInt(test.filled)) {
+				t.Fatalf("%s executed, expected %d", test.order.cumQty, test.filled)
+			}
+			_, live := app.orders[test.order.clOrdId]
+			rests := false
+			for _, bid := range book.bids {
+				rests = rests || bid == test.order
+			}
+			if live != test.live || rests != test.live {
+				t.Fatalf("order live %v and resting %v, expected %v", live, rests, test.live)
+			}
+		})
+	}
+}
+
+func TestQuoteCancel(t *testing.T) {
+	sessions := []quickfix.SessionID{
+		{BeginString: "FIXT.1.1", SenderCompID: "VENUE", TargetCompID: "S1"},
+		{BeginString: "FIXT.1.1", SenderCompID: "VENUE", TargetCompID: "S2"},
+	}
+	// testQuote quotes XXX on a session for an account, given as a customer account party
+	testQuote := func(quoteId string, account string) *quote.Quote {
+		q := quote.New(field.NewQuoteID(quoteId))
+		q.SetSymbol("XXX")
+		q.SetBidPx(decimal.NewFromInt(99), 0)
+		q.SetBidSize(decimal.NewFromInt(10), 0)
+		parties := quote.NewNoPartyIDsRepeatingGroup()
+		party := parties.Add()
+		party.SetPartyID(account)
+		party.SetPartyRole(enum.PartyRole_CUSTOMER_ACCOUNT)
+		q.SetNoPartyIDs(parties)
+		return &q
+	}
+
+	tests := []struct {
+		name    string
+		account string
+		// remaining is the number of quotes left once the first session cancels
+		remaining int
+	}{
+		{"cancel without account", "", 1},
+		{"cancel of an account", "A1", 2},
+		{"cancel of another account", "A3", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger := zerolog.Nop()
+			// Sessions are not registered in quickfix, reports are built then dropped
+			app := NewVenueApp(fixutils.QuickFixAppMessageLogger{Logger: &logger}, nil)
+			for i, q := range []*quote.Quote{testQuote("Q1", "A1"), testQuote("Q2", "A2"), testQuote("Q3", "A1")} {
+				if err := app.onQuote(*q, sessions[i/2]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			cancel := quotecancel.New(field.NewQuoteCancelType(enum.QuoteCancelType_CANCEL_ALL_QUOTES))
+			cancel.SetQuoteID("C1")
+			if len(test.account) > 0 {
+				cancel.SetAccount(test.account)
+			}
+			if err := app.onQuoteCancel(cancel, sessions[0]); err != nil {
+				t.Fatal(err)
+			}
+			if len(app.quotes) != test.remaining {
+				t.Fatalf("%d quotes left, expected %d", len(app.quotes), test.remaining)
+			}
+			if _, found := app.quotes[quoteKey(sessions[1], "A1", "XXX")]; !found {
+				t.Fatal("quote of the other session cancelled")
+			}
+		})
+	}
+}